@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxAddressLen is the default limit of ParcelService on the
+// length of an address, in characters. Longer addresses do not fit on
+// the shipping labels.
+const DefaultMaxAddressLen = 512
+
+// ErrEmptyAddress is returned when an address is empty or consists of
+// whitespace only.
+var ErrEmptyAddress = errors.New("address must not be empty")
+
+// ErrAddressRejected is returned by ParcelService methods, wrapping
+// the validator's error, when the service's AddressValidator rejects an
+// address.
+var ErrAddressRejected = errors.New("address rejected")
+
+// ErrAddressTooLong is returned by ParcelService methods given an
+// address longer than the limit set with WithMaxAddressLen.
+var ErrAddressTooLong = errors.New("address is too long")
+
+// AddressValidator decides whether an address is acceptable for
+// delivery. ParcelService consults it in Register and ChangeAddress.
+//
+// Implementations can enforce regional rules such as postal-code
+// formats or geocoding lookups.
+type AddressValidator interface {
+	// Validate returns a non-nil error if the address is rejected.
+	Validate(address string) error
+}
+
+// AddressValidatorFunc adapts an ordinary function to AddressValidator.
+type AddressValidatorFunc func(address string) error
+
+// Validate calls f(address).
+func (f AddressValidatorFunc) Validate(address string) error {
+	return f(address)
+}
+
+// nonEmptyAddress is the default AddressValidator. It only rejects
+// blank addresses.
+type nonEmptyAddress struct{}
+
+// Validate returns ErrEmptyAddress for blank addresses.
+func (nonEmptyAddress) Validate(address string) error {
+	if strings.TrimSpace(address) == "" {
+		return ErrEmptyAddress
+	}
+
+	return nil
+}
+
+// collapseWhitespace is the default address normalization of
+// ParcelService: it trims the address and replaces every run of
+// whitespace inside it with a single space.
+func collapseWhitespace(address string) string {
+	return strings.Join(strings.Fields(address), " ")
+}
+
+// checkAddress rejects a normalized address longer than the service's
+// limit, counted in characters rather than bytes so Cyrillic addresses
+// get the same room as Latin ones, and then consults the service's
+// AddressValidator. The validator's errors are wrapped with
+// ErrAddressRejected, so callers can tell them from other failures.
+func (s ParcelService) checkAddress(address string) error {
+	if s.maxAddressLen > 0 {
+		if n := utf8.RuneCountInString(address); n > s.maxAddressLen {
+			return fmt.Errorf("%w: %d characters, at most %d allowed", ErrAddressTooLong, n, s.maxAddressLen)
+		}
+	}
+
+	if err := s.addressValidator.Validate(address); err != nil {
+		return fmt.Errorf("%w: %w", ErrAddressRejected, err)
+	}
+
+	return nil
+}
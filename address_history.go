@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrEmptyReason is returned by ParcelService.ChangeAddressWithReason
+// when the reason is empty or consists of whitespace only.
+var ErrEmptyReason = errors.New("reason must not be empty")
+
+// AddressChange is a single entry of a parcel's address history.
+type AddressChange struct {
+	// Number is the number of the parcel whose address changed.
+	Number int64 `json:"number"`
+	// OldAddress is the address the parcel had before the change.
+	OldAddress string `json:"old_address"`
+	// NewAddress is the address the parcel has after the change.
+	NewAddress string `json:"new_address"`
+	// Reason explains why the address was changed.
+	Reason string `json:"reason"`
+	// ChangedAt is the moment of the change.
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// SetAddressWithReason updates the address of a parcel like SetAddress
+// and records the change with its reason in the parcel's address
+// history within the same transaction.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the queries.
+// - number: the unique number of the parcel to be updated.
+// - address: the new address to set for the parcel.
+// - reason: why the address is changed, kept for audits.
+// - version: the version of the parcel the change is based on.
+//
+// Returns:
+// - ErrParcelNotFound if no parcel has the given number.
+// - ErrVersionConflict if the parcel is no longer at version.
+// - An error, if any occurs during the update operation.
+func (s ParcelStore) SetAddressWithReason(ctx context.Context, number int, address, reason string, version int) (err error) {
+	ctx, span := s.startSpan(ctx, "SetAddressWithReason", attrNumber(number))
+	defer s.observe(span, "SetAddressWithReason", &err)
+
+	return s.WithTx(ctx, func(tx ParcelStore) error {
+		var current string
+
+		err := tx.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT address FROM "+s.table.String()+" WHERE number = ?"), number).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrParcelNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+
+		result, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND version = ?"),
+			address, now, number, version)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.requireVersion(ctx, result, number); err != nil {
+			return err
+		}
+
+		_, err = tx.executor().ExecContext(ctx, s.dialect.Rebind("INSERT INTO "+s.table.addressHistory()+" (number, old_address, new_address, reason, changed_at) VALUES (?, ?, ?, ?, ?)"),
+			number, current, address, reason, now)
+		return err
+	})
+}
+
+// GetAddressHistory retrieves the address changes of a parcel made
+// with SetAddressWithReason, oldest first.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel.
+//
+// Returns:
+// - A slice of AddressChange entries; empty if none was recorded.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetAddressHistory(ctx context.Context, number int) (_ []AddressChange, err error) {
+	ctx, span := s.startSpan(ctx, "GetAddressHistory", attrNumber(number))
+	defer s.observe(span, "GetAddressHistory", &err)
+
+	rows, err := s.forReads().executor().QueryContext(ctx, s.dialect.Rebind("SELECT number, old_address, new_address, reason, changed_at FROM "+s.table.addressHistory()+" WHERE number = ? ORDER BY id"), number)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var changes []AddressChange
+	for rows.Next() {
+		var change AddressChange
+
+		err = rows.Scan(&change.Number, &change.OldAddress, &change.NewAddress, &change.Reason, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// ChangeAddressWithReason updates the delivery address of a parcel like
+// ChangeAddress and records why it was changed, so audits can tell the
+// reason of every reroute. The reason is trimmed before it is stored.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store calls.
+//   - number: An integer representing the unique identifier of the parcel.
+//   - address: A string containing the new address to which the parcel
+//     should be sent.
+//   - reason: Why the address is changed, such as a customer request.
+//
+// Returns:
+// - ErrEmptyReason if the reason is blank.
+// - ErrParcelNotFound if the parcel does not exist.
+// - ErrParcelAlreadyDelivered if the parcel has been delivered.
+// - ErrVersionConflict if the parcel was changed concurrently.
+// - An error if the address is rejected or the update fails; otherwise, it returns nil.
+func (s ParcelService) ChangeAddressWithReason(ctx context.Context, number int, address, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrEmptyReason
+	}
+
+	return s.changeAddress(ctx, number, address, func(ctx context.Context, address string, version int) error {
+		return s.store.SetAddressWithReason(ctx, number, address, reason, version)
+	})
+}
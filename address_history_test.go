@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeAddressWithReason(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			service := NewParcelService(repo)
+			parcel := addParcel(t, repo, 1000, createdAt)
+			number := int(parcel.Number)
+
+			t.Run("reason is recorded", func(t *testing.T) {
+				require.NoError(t, service.ChangeAddressWithReason(ctx, number, "  new   address ", " customer moved "))
+				require.NoError(t, service.ChangeAddressWithReason(ctx, number, "office address", "customer at work"))
+
+				got, err := repo.Get(ctx, number)
+				require.NoError(t, err)
+				require.Equal(t, "office address", got.Address)
+
+				history, err := repo.GetAddressHistory(ctx, number)
+				require.NoError(t, err)
+				require.Len(t, history, 2)
+
+				require.Equal(t, parcel.Number, history[0].Number)
+				require.Equal(t, "test address", history[0].OldAddress)
+				require.Equal(t, "new address", history[0].NewAddress)
+				require.Equal(t, "customer moved", history[0].Reason)
+				require.False(t, history[0].ChangedAt.IsZero())
+
+				require.Equal(t, "new address", history[1].OldAddress)
+				require.Equal(t, "office address", history[1].NewAddress)
+				require.Equal(t, "customer at work", history[1].Reason)
+			})
+
+			t.Run("empty reason", func(t *testing.T) {
+				err := service.ChangeAddressWithReason(ctx, number, "another address", " \t")
+				require.ErrorIs(t, err, ErrEmptyReason)
+
+				got, err := repo.Get(ctx, number)
+				require.NoError(t, err)
+				require.Equal(t, "office address", got.Address)
+			})
+
+			t.Run("missing parcel", func(t *testing.T) {
+				err := service.ChangeAddressWithReason(ctx, 9999, "another address", "customer moved")
+				require.ErrorIs(t, err, ErrParcelNotFound)
+			})
+
+			t.Run("plain change is not recorded", func(t *testing.T) {
+				other := addParcel(t, repo, 1000, createdAt)
+				require.NoError(t, service.ChangeAddress(ctx, int(other.Number), "another address"))
+
+				history, err := repo.GetAddressHistory(ctx, int(other.Number))
+				require.NoError(t, err)
+				require.Empty(t, history)
+			})
+		})
+	}
+}
+
+func TestSetAddressWithReasonSQL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "success",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM parcel WHERE number = $1")).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("old address"))
+				dbMock.ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = $1, updated_at = $2, version = version + 1 WHERE number = $3 AND version = $4")).
+					WithArgs("new address", sqlmock.AnyArg(), 1, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectExec(regexp.QuoteMeta("INSERT INTO parcel_address_history (number, old_address, new_address, reason, changed_at) VALUES ($1, $2, $3, $4, $5)")).
+					WithArgs(1, "old address", "new address", "customer moved", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "not found",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT address FROM parcel WHERE number = $1")).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"address"}))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			store := NewParcelStore(db, WithDialect(DialectPostgres))
+			err = store.SetAddressWithReason(context.Background(), 1, "new address", "customer moved", 3)
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonEmptyAddress(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, nonEmptyAddress{}.Validate("Псков, ул. Колотушкина, д. 5"))
+	require.ErrorIs(t, nonEmptyAddress{}.Validate(""), ErrEmptyAddress)
+	require.ErrorIs(t, nonEmptyAddress{}.Validate(" \t "), ErrEmptyAddress)
+}
+
+func TestServiceAddressValidator(t *testing.T) {
+	t.Parallel()
+
+	errPOBox := errors.New("PO boxes are not delivered to")
+	noPOBoxes := AddressValidatorFunc(func(address string) error {
+		if strings.Contains(strings.ToLower(address), "po box") {
+			return errPOBox
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		validator AddressValidator
+		address   string
+		wantErr   error
+	}{
+		{
+			name:      "custom validator accepts",
+			validator: noPOBoxes,
+			address:   "Main street 1",
+		},
+		{
+			name:      "custom validator rejects",
+			validator: noPOBoxes,
+			address:   "PO Box 42",
+			wantErr:   errPOBox,
+		},
+		{
+			name:    "default validator rejects blank",
+			address: "  ",
+			wantErr: ErrEmptyAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &fakeRepository{
+				parcels: map[int]Parcel{
+					1: {Number: 1, Client: 1, Status: ParcelStatusRegistered, Address: "old address"},
+				},
+			}
+			service := NewParcelService(repo, WithAddressValidator(tt.validator))
+
+			_, err := service.Register(context.Background(), 1, tt.address)
+			require.ErrorIs(t, err, tt.wantErr)
+
+			err = service.ChangeAddress(context.Background(), 1, tt.address)
+			require.ErrorIs(t, err, tt.wantErr)
+
+			if tt.wantErr != nil {
+				require.Len(t, repo.parcels, 1)
+				require.Equal(t, "old address", repo.parcels[1].Address)
+			}
+		})
+	}
+}
+
+func TestCollapseWhitespace(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{name: "already normal", address: "Псков, ул. Колотушкина, д. 5", want: "Псков, ул. Колотушкина, д. 5"},
+		{name: "trims", address: "  Main street 1 \n", want: "Main street 1"},
+		{name: "collapses runs", address: "Main   street\t\t1", want: "Main street 1"},
+		{name: "blank", address: " \t ", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, collapseWhitespace(tt.address))
+		})
+	}
+}
+
+func TestServiceAddressNormalizer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		opts      []ServiceOption
+		address   string
+		wantSaved string
+	}{
+		{
+			name:      "default collapses whitespace",
+			address:   "  Main   street  1 ",
+			wantSaved: "Main street 1",
+		},
+		{
+			name:      "custom normalizer",
+			opts:      []ServiceOption{WithAddressNormalizer(strings.ToUpper)},
+			address:   "main street 1",
+			wantSaved: "MAIN STREET 1",
+		},
+		{
+			name:      "nil disables normalization",
+			opts:      []ServiceOption{WithAddressNormalizer(nil)},
+			address:   "  Main   street  1 ",
+			wantSaved: "  Main   street  1 ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := NewMemoryStore()
+			service := NewParcelService(store, append(tt.opts, WithOutput(io.Discard))...)
+
+			parcel, err := service.Register(ctx, 1000, tt.address)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSaved, parcel.Address)
+
+			require.NoError(t, service.ChangeAddress(ctx, int(parcel.Number), tt.address))
+
+			got, err := store.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSaved, got.Address)
+		})
+	}
+}
+
+func TestServiceMaxAddressLen(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		opts    []ServiceOption
+		address string
+		wantErr error
+	}{
+		{
+			name:    "default limit",
+			address: strings.Repeat("a", DefaultMaxAddressLen),
+		},
+		{
+			name:    "over default limit",
+			address: strings.Repeat("a", DefaultMaxAddressLen+1),
+			wantErr: ErrAddressTooLong,
+		},
+		{
+			name:    "cyrillic at limit",
+			opts:    []ServiceOption{WithMaxAddressLen(10)},
+			address: "ПсковКремл",
+		},
+		{
+			name:    "cyrillic over limit",
+			opts:    []ServiceOption{WithMaxAddressLen(10)},
+			address: "ПсковКремль",
+			wantErr: ErrAddressTooLong,
+		},
+		{
+			name:    "counted after normalization",
+			opts:    []ServiceOption{WithMaxAddressLen(10)},
+			address: "  Main     str  ",
+		},
+		{
+			name:    "zero disables",
+			opts:    []ServiceOption{WithMaxAddressLen(0)},
+			address: strings.Repeat("a", 10*DefaultMaxAddressLen),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &fakeRepository{
+				parcels: map[int]Parcel{
+					1: {Number: 1, Client: 1, Status: ParcelStatusRegistered, Address: "old address"},
+				},
+			}
+			service := NewParcelService(repo, append(tt.opts, WithOutput(io.Discard))...)
+
+			_, err := service.Register(context.Background(), 1, tt.address)
+			require.ErrorIs(t, err, tt.wantErr)
+
+			err = service.ChangeAddress(context.Background(), 1, tt.address)
+			require.ErrorIs(t, err, tt.wantErr)
+
+			if tt.wantErr != nil {
+				require.Len(t, repo.parcels, 1)
+				require.Equal(t, "old address", repo.parcels[1].Address)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// archivedBefore selects the delivered parcels ArchiveDelivered moves.
+// Parcels delivered before delivered_at was recorded fall back to their
+// last update.
+const archivedBefore = "status = ? AND COALESCE(delivered_at, updated_at) < ?"
+
+// ArchiveDelivered moves the parcels delivered before olderThan from the
+// parcel table to the parcel_archive table, so the parcel table does not
+// grow without bound.
+//
+// The parcels are copied and deleted in a single transaction: either
+// all of them are moved or none. Their status history is kept.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the transaction.
+// - olderThan: the cutoff; parcels delivered at or after it are kept.
+//
+// Returns:
+// - The number of parcels moved.
+// - ErrStatusChanged if parcels were delivered or changed concurrently,
+// or any error of the transaction.
+func (s ParcelStore) ArchiveDelivered(ctx context.Context, olderThan time.Time) (_ int64, err error) {
+	ctx, span := s.startSpan(ctx, "ArchiveDelivered")
+	defer s.observe(span, "ArchiveDelivered", &err)
+
+	var moved int64
+
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		result, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("INSERT INTO "+s.table.archive()+" ("+parcelColumns+", archived_at) "+
+			"SELECT "+parcelColumns+", ? FROM "+s.table.String()+" WHERE "+archivedBefore),
+			time.Now().UTC(), ParcelStatusDelivered, olderThan.UTC())
+		if err != nil {
+			return err
+		}
+
+		copied, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		result, err = tx.executor().ExecContext(ctx, s.dialect.Rebind("DELETE FROM "+s.table.String()+" WHERE "+archivedBefore),
+			ParcelStatusDelivered, olderThan.UTC())
+		if err != nil {
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if deleted != copied {
+			return fmt.Errorf("%w: copied %d parcels to the archive but deleted %d", ErrStatusChanged, copied, deleted)
+		}
+
+		moved = deleted
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
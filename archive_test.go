@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveDelivered(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+
+	cutoff := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := cutoff.AddDate(0, -1, 0)
+
+	// deliver moves a parcel to delivered at deliveredAt.
+	deliver := func(deliveredAt time.Time) Parcel {
+		parcel := addParcel(t, store, 1000, createdAt)
+		for range 2 {
+			_, _, err := store.AdvanceStatus(ctx, int(parcel.Number))
+			require.NoError(t, err)
+		}
+
+		_, err := db.ExecContext(ctx, "UPDATE parcel SET delivered_at = ? WHERE number = ?", deliveredAt, parcel.Number)
+		require.NoError(t, err)
+
+		return parcel
+	}
+
+	old := deliver(cutoff.Add(-time.Hour))
+	recent := deliver(cutoff.Add(time.Hour))
+	registered := addParcel(t, store, 1000, createdAt)
+
+	moved, err := store.ArchiveDelivered(ctx, cutoff)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), moved)
+
+	_, err = store.Get(ctx, int(old.Number))
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	for _, parcel := range []Parcel{recent, registered} {
+		_, err = store.Get(ctx, int(parcel.Number))
+		require.NoError(t, err)
+	}
+
+	var (
+		archived Parcel
+		at       time.Time
+	)
+	row := db.QueryRowContext(ctx, "SELECT "+parcelColumns+", archived_at FROM parcel_archive")
+	require.NoError(t, scanParcel(extraColumns{row: row, extra: []any{&at}}, &archived))
+	require.Equal(t, old.Number, archived.Number)
+	require.Equal(t, old.TrackingCode, archived.TrackingCode)
+	require.Equal(t, ParcelStatusDelivered, archived.Status)
+	require.False(t, at.IsZero())
+
+	// Nothing is left to archive.
+	moved, err = store.ArchiveDelivered(ctx, cutoff)
+	require.NoError(t, err)
+	require.Zero(t, moved)
+}
+
+func TestArchiveDeliveredSQL(t *testing.T) {
+	t.Parallel()
+
+	const (
+		insertArchive = "INSERT INTO parcel_archive (" + parcelColumns + ", archived_at) SELECT " + parcelColumns +
+			", ? FROM parcel WHERE status = ? AND COALESCE(delivered_at, updated_at) < ?"
+		deleteParcels = "DELETE FROM parcel WHERE status = ? AND COALESCE(delivered_at, updated_at) < ?"
+	)
+
+	cutoff := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		mocks     func(dbMock sqlmock.Sqlmock)
+		wantMoved int64
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name: "moves parcels",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta(insertArchive)).
+					WithArgs(sqlmock.AnyArg(), ParcelStatusDelivered, cutoff).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				dbMock.ExpectExec(regexp.QuoteMeta(deleteParcels)).
+					WithArgs(ParcelStatusDelivered, cutoff).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				dbMock.ExpectCommit()
+			},
+			wantMoved: 3,
+			wantErr:   require.NoError,
+		},
+		{
+			name: "concurrent delivery rolls back",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta(insertArchive)).
+					WithArgs(sqlmock.AnyArg(), ParcelStatusDelivered, cutoff).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				dbMock.ExpectExec(regexp.QuoteMeta(deleteParcels)).
+					WithArgs(ParcelStatusDelivered, cutoff).
+					WillReturnResult(sqlmock.NewResult(0, 4))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrStatusChanged, i...)
+			},
+		},
+		{
+			name: "copy error rolls back",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta(insertArchive)).
+					WithArgs(sqlmock.AnyArg(), ParcelStatusDelivered, cutoff).
+					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.ArchiveDelivered: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			moved, err := NewParcelStore(db).ArchiveDelivered(context.Background(), cutoff)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantMoved, moved)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errUsage is returned by runCommand when the command line does not
+// name a known command or misses a required flag.
+var errUsage = errors.New("usage: go-db-sql-final register|get|list|next-status|delete [flags]")
+
+// commands maps each subcommand name to the function running it. The
+// functions receive the arguments following the name.
+var commands = map[string]func(ctx context.Context, service ParcelService, args []string) error{
+	"register":    runRegister,
+	"get":         runGet,
+	"list":        runList,
+	"next-status": runNextStatus,
+	"delete":      runDelete,
+}
+
+// runCommand runs the subcommand named by the first of args with the
+// remaining args as its flags. Results are written to the service
+// output (see WithOutput).
+//
+// The subcommands are:
+// - register -client N -address A: registers a parcel.
+// - get -number N: prints the parcel as JSON.
+// - list -client N [-status S,...]: prints the parcels of the client,
+// optionally only those in the given statuses.
+// - next-status -number N: advances the parcel to its next status.
+// - delete -number N: deletes a registered parcel.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the service calls.
+// - service: the service the subcommands call.
+// - args: the command line without the program name.
+//
+// Returns:
+// - An error wrapping errUsage if the command line is invalid.
+// - Any error returned by the service.
+func runCommand(ctx context.Context, service ParcelService, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	run, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	return run(ctx, service, args[1:])
+}
+
+// newFlagSet returns a flag set for the named subcommand that reports
+// parse errors instead of exiting and prints its usage to w.
+func newFlagSet(name string, w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(w)
+
+	return fs
+}
+
+// parseFlags parses args into fs and wraps any failure in errUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %v", errUsage, fs.Name(), err)
+	}
+
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: %s: unexpected argument %q", errUsage, fs.Name(), fs.Arg(0))
+	}
+
+	return nil
+}
+
+// parseNumber parses the -number flag shared by the subcommands that
+// act on a single parcel.
+func parseNumber(name string, service ParcelService, args []string) (int, error) {
+	fs := newFlagSet(name, service.out)
+	number := fs.Int("number", 0, "number of the parcel")
+
+	if err := parseFlags(fs, args); err != nil {
+		return 0, err
+	}
+
+	if *number <= 0 {
+		return 0, fmt.Errorf("%w: %s: -number is required", errUsage, name)
+	}
+
+	return *number, nil
+}
+
+// runRegister registers a parcel for -client at -address.
+func runRegister(ctx context.Context, service ParcelService, args []string) error {
+	fs := newFlagSet("register", service.out)
+	client := fs.Int64("client", 0, "identifier of the client")
+	address := fs.String("address", "", "destination address")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *client <= 0 {
+		return fmt.Errorf("%w: register: -client is required", errUsage)
+	}
+
+	_, err := service.Register(ctx, *client, *address)
+	return err
+}
+
+// runGet prints the parcel with -number as JSON.
+func runGet(ctx context.Context, service ParcelService, args []string) error {
+	number, err := parseNumber("get", service, args)
+	if err != nil {
+		return err
+	}
+
+	parcel, err := service.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(service.out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(parcel)
+}
+
+// runList prints the parcels of -client, only those in the statuses of
+// -status if it is set.
+func runList(ctx context.Context, service ParcelService, args []string) error {
+	fs := newFlagSet("list", service.out)
+	client := fs.Int("client", 0, "identifier of the client")
+	status := fs.String("status", "", "comma-separated statuses of the parcels to list")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *client <= 0 {
+		return fmt.Errorf("%w: list: -client is required", errUsage)
+	}
+
+	var statuses []ParcelStatus
+	if *status != "" {
+		for _, value := range strings.Split(*status, ",") {
+			parsed, err := ParseStatus(value)
+			if err != nil {
+				return fmt.Errorf("%w: list: %w", errUsage, err)
+			}
+
+			statuses = append(statuses, parsed)
+		}
+	}
+
+	return service.PrintClientParcels(ctx, *client, statuses...)
+}
+
+// runNextStatus advances the parcel with -number to its next status.
+func runNextStatus(ctx context.Context, service ParcelService, args []string) error {
+	number, err := parseNumber("next-status", service, args)
+	if err != nil {
+		return err
+	}
+
+	return service.NextStatus(ctx, number)
+}
+
+// runDelete deletes the parcel with -number.
+func runDelete(ctx context.Context, service ParcelService, args []string) error {
+	number, err := parseNumber("delete", service, args)
+	if err != nil {
+		return err
+	}
+
+	if err = service.Delete(ctx, number); err != nil {
+		return err
+	}
+
+	return service.printEvent(fmt.Sprintf("Посылка № %d удалена\n", number),
+		outputEvent{Event: "parcel.deleted", Number: int64(number)})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		args    []string
+		setup   func(t *testing.T, store *MemoryStore)
+		wantOut []string
+		check   func(t *testing.T, store *MemoryStore)
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "register",
+			args:    []string{"register", "-client", "1000", "-address", "test address"},
+			setup:   func(t *testing.T, store *MemoryStore) {},
+			wantOut: []string{"Новая посылка № 1 на адрес test address от клиента с идентификатором 1000"},
+			check: func(t *testing.T, store *MemoryStore) {
+				parcels, err := store.GetByClient(context.Background(), 1000)
+				require.NoError(t, err)
+				require.Len(t, parcels, 1)
+				require.Equal(t, ParcelStatusRegistered, parcels[0].Status)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "get",
+			args: []string{"get", "-number", "1"},
+			setup: func(t *testing.T, store *MemoryStore) {
+				addParcel(t, store, 1000, createdAt)
+			},
+			wantOut: []string{`"number": 1`, `"client": 1000`, `"status": "registered"`},
+			wantErr: require.NoError,
+		},
+		{
+			name: "list",
+			args: []string{"list", "-client", "1000"},
+			setup: func(t *testing.T, store *MemoryStore) {
+				addParcel(t, store, 1000, createdAt)
+				addParcel(t, store, 1000, createdAt)
+			},
+			wantOut: []string{"Посылки клиента 1000:", "Посылка № 1 ", "Посылка № 2 "},
+			wantErr: require.NoError,
+		},
+		{
+			name: "list by status",
+			args: []string{"list", "-client", "1000", "-status", "sent,delivered"},
+			setup: func(t *testing.T, store *MemoryStore) {
+				addParcel(t, store, 1000, createdAt)
+				addParcel(t, store, 1000, createdAt)
+				_, _, err := store.AdvanceStatus(context.Background(), 2)
+				require.NoError(t, err)
+			},
+			wantOut: []string{"Посылки клиента 1000:\nПосылка № 2 "},
+			wantErr: require.NoError,
+		},
+		{
+			name: "next-status",
+			args: []string{"next-status", "-number", "1"},
+			setup: func(t *testing.T, store *MemoryStore) {
+				addParcel(t, store, 1000, createdAt)
+			},
+			wantOut: []string{"У посылки № 1 новый статус: sent"},
+			check: func(t *testing.T, store *MemoryStore) {
+				parcel, err := store.Get(context.Background(), 1)
+				require.NoError(t, err)
+				require.Equal(t, ParcelStatusSent, parcel.Status)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "delete",
+			args: []string{"delete", "-number", "1"},
+			setup: func(t *testing.T, store *MemoryStore) {
+				addParcel(t, store, 1000, createdAt)
+			},
+			wantOut: []string{"Посылка № 1 удалена"},
+			check: func(t *testing.T, store *MemoryStore) {
+				_, err := store.Get(context.Background(), 1)
+				require.ErrorIs(t, err, ErrParcelNotFound)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:  "get missing parcel",
+			args:  []string{"get", "-number", "999"},
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name:  "no command",
+			args:  nil,
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUsage, i...)
+			},
+		},
+		{
+			name:  "unknown command",
+			args:  []string{"ship"},
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUsage, i...)
+				require.ErrorContains(tt, err, `unknown command "ship"`, i...)
+			},
+		},
+		{
+			name:  "missing number",
+			args:  []string{"next-status"},
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUsage, i...)
+				require.ErrorContains(tt, err, "-number is required", i...)
+			},
+		},
+		{
+			name:  "malformed flag",
+			args:  []string{"list", "-client", "abc"},
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUsage, i...)
+			},
+		},
+		{
+			name:  "list unknown status",
+			args:  []string{"list", "-client", "1000", "-status", "lost"},
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUsage, i...)
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+		{
+			name:  "unexpected argument",
+			args:  []string{"register", "-client", "1000", "extra"},
+			setup: func(t *testing.T, store *MemoryStore) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUsage, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := NewMemoryStore()
+			tt.setup(t, store)
+
+			var out bytes.Buffer
+			service := NewParcelService(store, WithOutput(&out))
+
+			err := runCommand(context.Background(), service, tt.args)
+			tt.wantErr(t, err)
+
+			for _, want := range tt.wantOut {
+				require.Contains(t, out.String(), want)
+			}
+
+			if tt.check != nil {
+				tt.check(t, store)
+			}
+		})
+	}
+}
+
+func TestRunCommandGetJSON(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+	parcel := addParcel(t, store, 1000, time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+
+	var out bytes.Buffer
+	service := NewParcelService(store, WithOutput(&out))
+
+	require.NoError(t, runCommand(context.Background(), service, []string{"get", "-number", "1"}))
+
+	var got Parcel
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	require.Equal(t, parcel.Number, got.Number)
+	require.Equal(t, parcel.Address, got.Address)
+	require.True(t, parcel.CreatedAt.Equal(got.CreatedAt))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// ParcelWithClient is a parcel together with the name of its client, as
+// returned by ParcelStore.GetByClientWithName.
+type ParcelWithClient struct {
+	Parcel
+	// ClientName is the name of the client from the client table. It is
+	// not valid when the client has no row there.
+	ClientName sql.NullString
+}
+
+// qualifiedParcelColumns returns parcelColumns with every column
+// prefixed by the parcel table name, for queries joining other tables.
+func qualifiedParcelColumns(table TableName) string {
+	prefix := table.String() + "."
+
+	return prefix + strings.ReplaceAll(parcelColumns, ", ", ", "+prefix)
+}
+
+// extraColumns scans a row holding parcelColumns followed by further
+// columns, so scanParcel can read the parcel part of a joined row.
+type extraColumns struct {
+	row   rowScanner
+	extra []any
+}
+
+// Scan scans the row into dest followed by the extra destinations.
+func (e extraColumns) Scan(dest ...any) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// GetByClientWithName retrieves all parcels associated with a client,
+// ordered by number, together with the client's name.
+//
+// The name is joined from the client table with a LEFT JOIN, so parcels
+// are returned even if the client has no row there; their ClientName
+// is then not valid.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the client's unique identifier.
+//
+// Returns:
+// - A slice of ParcelWithClient values; empty if the client has no parcels.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetByClientWithName(ctx context.Context, client int) (_ []ParcelWithClient, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientWithName")
+	defer s.observe(span, "GetByClientWithName", &err)
+
+	table := s.table.String()
+	rows, err := s.forReads().executor().QueryContext(ctx, s.dialect.Rebind("SELECT "+qualifiedParcelColumns(s.table)+", client.name FROM "+table+
+		" LEFT JOIN client ON client.id = "+table+".client WHERE "+table+".client = ? AND "+table+".deleted_at IS NULL ORDER BY "+table+".number"), client)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var parcels []ParcelWithClient
+	for rows.Next() {
+		var parcel ParcelWithClient
+
+		if err = scanParcel(extraColumns{row: rows, extra: []any{&parcel.ClientName}}, &parcel.Parcel); err != nil {
+			return nil, err
+		}
+
+		parcels = append(parcels, parcel)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return parcels, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetByClientWithName(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	_, err := db.ExecContext(ctx, "INSERT INTO client (id, name) VALUES (?, ?)", 1000, "Acme Ltd")
+	require.NoError(t, err)
+
+	first := addParcel(t, store, 1000, createdAt)
+	second := addParcel(t, store, 1000, createdAt)
+	unnamed := addParcel(t, store, 2000, createdAt)
+
+	tests := []struct {
+		name        string
+		client      int
+		wantNumbers []int64
+		wantName    sql.NullString
+	}{
+		{
+			name:        "matched client",
+			client:      1000,
+			wantNumbers: []int64{first.Number, second.Number},
+			wantName:    sql.NullString{String: "Acme Ltd", Valid: true},
+		},
+		{
+			name:        "client without a row",
+			client:      2000,
+			wantNumbers: []int64{unnamed.Number},
+			wantName:    sql.NullString{},
+		},
+		{
+			name:        "client without parcels",
+			client:      3000,
+			wantNumbers: []int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parcels, err := store.GetByClientWithName(ctx, tt.client)
+			require.NoError(t, err)
+
+			numbers := []int64{}
+			for _, parcel := range parcels {
+				numbers = append(numbers, parcel.Number)
+				require.Equal(t, int64(tt.client), parcel.Client)
+				require.Equal(t, tt.wantName, parcel.ClientName)
+			}
+			require.Equal(t, tt.wantNumbers, numbers)
+		})
+	}
+}
+
+func TestGetByClientWithNameSQL(t *testing.T) {
+	t.Parallel()
+
+	query := "SELECT parcel.number, parcel.client, parcel.status, parcel.address, parcel.created_at, parcel.updated_at, parcel.deleted_at, " +
+		"parcel.registered_by, parcel.external_ref, parcel.version, parcel.weight_grams, parcel.length_mm, parcel.width_mm, parcel.height_mm, " +
+		"parcel.tracking_code, parcel.sent_at, parcel.delivered_at, parcel.priority, parcel.metadata, client.name FROM parcel " +
+		"LEFT JOIN client ON client.id = parcel.client WHERE parcel.client = ? AND parcel.deleted_at IS NULL ORDER BY parcel.number"
+
+	parcel := Parcel{Number: 101, Client: 1000, Status: ParcelStatusRegistered, Address: "test address"}
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		want    []ParcelWithClient
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "null name",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(append(strings.Split(parcelColumns, ", "), "name")).
+					AddRow(append(parcelValues(parcel), nil)...)
+				dbMock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(1000).WillReturnRows(rows)
+			},
+			want:    []ParcelWithClient{{Parcel: parcel}},
+			wantErr: require.NoError,
+		},
+		{
+			name: "database error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(1000).WillReturnError(errors.New("database error"))
+			},
+			want: nil,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.GetByClientWithName: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			got, err := NewParcelStore(db).GetByClientWithName(context.Background(), 1000)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, got)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
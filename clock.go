@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// Clock tells ParcelService the current time, so tests can fix the
+// time stamped on new parcels.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// realClock is the Clock of the system, used by default.
+type realClock struct{}
+
+// Now returns time.Now().
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes the service read the current time from clock, for
+// example to stamp CreatedAt on registered parcels. A nil clock keeps
+// the system clock.
+func WithClock(clock Clock) ServiceOption {
+	return func(s *ParcelService) {
+		if clock != nil {
+			s.clock = clock
+		}
+	}
+}
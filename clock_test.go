@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock stopped at a fixed time.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestRegisterUsesClock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			service := NewParcelService(repo, WithClock(fakeClock{now: now}), WithOutput(io.Discard))
+
+			parcel, err := service.Register(ctx, 1000, "test address")
+			require.NoError(t, err)
+			require.Equal(t, now, parcel.CreatedAt)
+
+			stored, err := repo.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.True(t, now.Equal(stored.CreatedAt), "stored %s, want %s", stored.CreatedAt, now)
+
+			batch, err := service.RegisterBatch(ctx, 1000, []string{"first address", "second address"})
+			require.NoError(t, err)
+			for _, parcel := range batch {
+				require.Equal(t, now, parcel.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestWithClockNil(t *testing.T) {
+	t.Parallel()
+
+	service := NewParcelService(NewMemoryStore(), WithClock(nil), WithOutput(io.Discard))
+
+	before := time.Now().UTC()
+	parcel, err := service.Register(context.Background(), 1000, "test address")
+	require.NoError(t, err)
+	require.WithinDuration(t, before, parcel.CreatedAt, time.Minute)
+}
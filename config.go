@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by NewConfigFromEnv.
+const (
+	envDriver          = "DB_DRIVER"
+	envDSN             = "DB_DNS"
+	envMaxOpenConns    = "DB_MAX_OPEN_CONNS"
+	envMaxIdleConns    = "DB_MAX_IDLE_CONNS"
+	envConnMaxLifetime = "DB_CONN_MAX_LIFETIME"
+	envNotifyURL       = "NOTIFY_URL"
+	envOutputFormat    = "OUTPUT_FORMAT"
+)
+
+// Defaults used by NewConfigFromEnv for unset variables.
+const (
+	defaultDriver = "sqlite"
+	defaultDSN    = "tracker.db"
+)
+
+// ErrInvalidConfig is returned by NewConfigFromEnv when a variable has
+// an unusable value.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config holds the database connection settings of the application.
+type Config struct {
+	// Driver is the database/sql driver name.
+	Driver string
+	// DSN is the data source name passed to the driver.
+	DSN string
+	// MaxOpenConns limits open connections; zero means unlimited.
+	MaxOpenConns int
+	// MaxIdleConns limits idle connections; zero uses the database/sql default.
+	MaxIdleConns int
+	// ConnMaxLifetime limits how long a connection is reused; zero means forever.
+	ConnMaxLifetime time.Duration
+	// NotifyURL is the webhook told about status changes; empty disables it.
+	NotifyURL string
+	// OutputFormat is the format of the messages the service prints.
+	OutputFormat OutputFormat
+}
+
+// NewConfigFromEnv reads the configuration from environment variables.
+//
+// DB_DRIVER and DB_DNS default to sqlite and tracker.db, the database
+// file shipped with the project.
+// DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS are non-negative integers,
+// and DB_CONN_MAX_LIFETIME is a duration such as "5m"; they default to
+// zero. NOTIFY_URL, if set, is an absolute http or https URL.
+// OUTPUT_FORMAT is "text" or "json" and defaults to "text".
+//
+// Returns:
+//   - The parsed configuration.
+//   - An error wrapping ErrInvalidConfig if a variable cannot be parsed,
+//     the driver is unknown, or the DSN does not fit the driver.
+func NewConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Driver: getenv(envDriver, defaultDriver),
+		DSN:    getenv(envDSN, defaultDSN),
+	}
+
+	var err error
+
+	cfg.OutputFormat, err = ParseOutputFormat(getenv(envOutputFormat, string(OutputFormatText)))
+	if err != nil {
+		return Config{}, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, envOutputFormat, err)
+	}
+
+	// The drivers the application can work with are those it has a
+	// dialect for.
+	if _, err := dialectForDriver(cfg.Driver); err != nil {
+		return Config{}, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if err := validateDSN(cfg.Driver, cfg.DSN); err != nil {
+		return Config{}, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	cfg.MaxOpenConns, err = envInt(envMaxOpenConns)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg.MaxIdleConns, err = envInt(envMaxIdleConns)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if value := os.Getenv(envConnMaxLifetime); value != "" {
+		cfg.ConnMaxLifetime, err = time.ParseDuration(value)
+		if err != nil || cfg.ConnMaxLifetime < 0 {
+			return Config{}, fmt.Errorf("%w: %s must be a non-negative duration, got %q", ErrInvalidConfig, envConnMaxLifetime, value)
+		}
+	}
+
+	if value := os.Getenv(envNotifyURL); value != "" {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("%w: %s must be an http or https URL, got %q", ErrInvalidConfig, envNotifyURL, value)
+		}
+
+		cfg.NotifyURL = value
+	}
+
+	return cfg, nil
+}
+
+// getenv returns the value of the environment variable key, or
+// fallback if it is unset or empty.
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// envInt parses the environment variable key as a non-negative
+// integer. An unset variable yields zero.
+func envInt(key string) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s must be a non-negative integer, got %q", ErrInvalidConfig, key, value)
+	}
+
+	return n, nil
+}
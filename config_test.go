@@ -0,0 +1,160 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Config
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{},
+			want: Config{
+				Driver:       "sqlite",
+				DSN:          "tracker.db",
+				OutputFormat: OutputFormatText,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "postgres",
+			env: map[string]string{
+				envDriver: "postgres",
+				envDSN:    "postgres://localhost/parcels",
+			},
+			want: Config{
+				Driver:       "postgres",
+				DSN:          "postgres://localhost/parcels",
+				OutputFormat: OutputFormatText,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "overrides",
+			env: map[string]string{
+				envDriver:          "sqlite",
+				envDSN:             "tracker.db",
+				envMaxOpenConns:    "10",
+				envMaxIdleConns:    "5",
+				envConnMaxLifetime: "5m",
+				envNotifyURL:       "https://hooks.example.com/parcels",
+				envOutputFormat:    "json",
+			},
+			want: Config{
+				Driver:          "sqlite",
+				DSN:             "tracker.db",
+				MaxOpenConns:    10,
+				MaxIdleConns:    5,
+				ConnMaxLifetime: 5 * time.Minute,
+				NotifyURL:       "https://hooks.example.com/parcels",
+				OutputFormat:    OutputFormatJSON,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "dsn does not fit driver",
+			env: map[string]string{
+				envDriver: "postgres",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidConfig, i...)
+				require.ErrorIs(tt, err, errDSNMismatch, i...)
+			},
+		},
+		{
+			name: "mysql driver",
+			env: map[string]string{
+				envDriver: "mysql",
+				envDSN:    "user:password@tcp(localhost:3306)/parcels?parseTime=true",
+			},
+			want: Config{
+				Driver:       "mysql",
+				DSN:          "user:password@tcp(localhost:3306)/parcels?parseTime=true",
+				OutputFormat: OutputFormatText,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "invalid driver",
+			env: map[string]string{
+				envDriver: "oracle",
+				envDSN:    "tracker.db",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidConfig, i...)
+				require.ErrorIs(tt, err, ErrUnknownDriver, i...)
+				require.ErrorContains(tt, err, "oracle", i...)
+			},
+		},
+		{
+			name: "invalid max open conns",
+			env: map[string]string{
+				envDriver:       "sqlite",
+				envDSN:          "tracker.db",
+				envMaxOpenConns: "-1",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidConfig, i...)
+				require.ErrorContains(tt, err, envMaxOpenConns, i...)
+			},
+		},
+		{
+			name: "invalid conn max lifetime",
+			env: map[string]string{
+				envDriver:          "sqlite",
+				envDSN:             "tracker.db",
+				envConnMaxLifetime: "forever",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidConfig, i...)
+				require.ErrorContains(tt, err, envConnMaxLifetime, i...)
+			},
+		},
+		{
+			name: "invalid output format",
+			env: map[string]string{
+				envDriver:       "sqlite",
+				envDSN:          "tracker.db",
+				envOutputFormat: "yaml",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidConfig, i...)
+				require.ErrorIs(tt, err, ErrInvalidOutputFormat, i...)
+			},
+		},
+		{
+			name: "invalid notify url",
+			env: map[string]string{
+				envDriver:    "sqlite",
+				envDSN:       "tracker.db",
+				envNotifyURL: "hooks.example.com/parcels",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidConfig, i...)
+				require.ErrorContains(tt, err, envNotifyURL, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{envDriver, envDSN, envMaxOpenConns, envMaxIdleConns, envConnMaxLifetime, envNotifyURL, envOutputFormat} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			cfg, err := NewConfigFromEnv()
+			tt.wantErr(t, err)
+			if err == nil {
+				require.Equal(t, tt.want, cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "time"
+
+// DeliveryPolicy holds the average number of days a parcel spends in
+// each stage of its lifecycle, used to estimate when it is delivered.
+type DeliveryPolicy struct {
+	// RegisteredDays is the average number of days between registering
+	// a parcel and sending it.
+	RegisteredDays float64
+	// SentDays is the average number of days a sent parcel takes to be
+	// delivered.
+	SentDays float64
+}
+
+// DefaultDeliveryPolicy is the DeliveryPolicy of ParcelService unless
+// configured with WithDeliveryPolicy: a day to send a parcel and four
+// more to deliver it.
+var DefaultDeliveryPolicy = DeliveryPolicy{RegisteredDays: 1, SentDays: 4}
+
+// WithDeliveryPolicy sets the policy Track estimates delivery times
+// with.
+func WithDeliveryPolicy(policy DeliveryPolicy) ServiceOption {
+	return func(s *ParcelService) {
+		s.deliveryPolicy = policy
+	}
+}
+
+// EstimatedDelivery estimates when p is delivered from its status and
+// the average stage durations of policy.
+//
+// A registered parcel is expected RegisteredDays and SentDays after
+// CreatedAt. A sent parcel is expected SentDays after SentAt, or after
+// the moment it was expected to be sent if SentAt is unknown. A
+// delivered parcel returns DeliveredAt, or UpdatedAt for parcels
+// delivered before DeliveredAt was recorded.
+//
+// Parameters:
+// - policy: the average number of days of each stage.
+//
+// Returns:
+// - The actual or estimated delivery time.
+func (p Parcel) EstimatedDelivery(policy DeliveryPolicy) time.Time {
+	sentAt := p.CreatedAt.Add(days(policy.RegisteredDays))
+
+	switch p.Status {
+	case ParcelStatusDelivered:
+		if p.DeliveredAt.Valid {
+			return p.DeliveredAt.Time
+		}
+
+		return p.UpdatedAt
+	case ParcelStatusSent:
+		if p.SentAt.Valid {
+			sentAt = p.SentAt.Time
+		}
+	}
+
+	return sentAt.Add(days(policy.SentDays))
+}
+
+// days converts a possibly fractional number of days to a duration.
+func days(n float64) time.Duration {
+	return time.Duration(n * float64(24*time.Hour))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimatedDelivery(t *testing.T) {
+	t.Parallel()
+
+	policy := DeliveryPolicy{RegisteredDays: 1.5, SentDays: 3}
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	sentAt := createdAt.Add(4 * time.Hour)
+	deliveredAt := createdAt.Add(50 * time.Hour)
+	updatedAt := createdAt.Add(60 * time.Hour)
+
+	tests := []struct {
+		name   string
+		parcel Parcel
+		want   time.Time
+	}{
+		{
+			name:   "registered",
+			parcel: Parcel{Status: ParcelStatusRegistered, CreatedAt: createdAt},
+			want:   createdAt.Add(108 * time.Hour),
+		},
+		{
+			name:   "sent",
+			parcel: Parcel{Status: ParcelStatusSent, CreatedAt: createdAt, SentAt: sql.NullTime{Time: sentAt, Valid: true}},
+			want:   sentAt.Add(72 * time.Hour),
+		},
+		{
+			name:   "sent without sent_at",
+			parcel: Parcel{Status: ParcelStatusSent, CreatedAt: createdAt},
+			want:   createdAt.Add(108 * time.Hour),
+		},
+		{
+			name: "delivered",
+			parcel: Parcel{
+				Status:      ParcelStatusDelivered,
+				CreatedAt:   createdAt,
+				UpdatedAt:   updatedAt,
+				SentAt:      sql.NullTime{Time: sentAt, Valid: true},
+				DeliveredAt: sql.NullTime{Time: deliveredAt, Valid: true},
+			},
+			want: deliveredAt,
+		},
+		{
+			name:   "delivered without delivered_at",
+			parcel: Parcel{Status: ParcelStatusDelivered, CreatedAt: createdAt, UpdatedAt: updatedAt},
+			want:   updatedAt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, tt.parcel.EstimatedDelivery(policy))
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the SQL flavour spoken by the database driver.
+//
+// Queries in ParcelStore are written with "?" placeholders and
+// rewritten by the dialect before they are sent to the database.
+type Dialect int
+
+const (
+	// DialectSQLite uses "?" placeholders. It is the default dialect.
+	DialectSQLite Dialect = iota
+	// DialectPostgres uses numbered "$1, $2, ..." placeholders and its
+	// own schema (see CreateSchema).
+	DialectPostgres
+	// DialectMySQL uses "?" placeholders like SQLite and reads inserted
+	// ids with LastInsertId, but needs its own schema (see CreateSchema).
+	DialectMySQL
+)
+
+// ErrUnknownDriver is returned for a database driver whose dialect is
+// not known.
+var ErrUnknownDriver = errors.New("unknown database driver")
+
+// dialectForDriver returns the dialect spoken by the named database
+// driver, or ErrUnknownDriver if the driver is not known.
+func dialectForDriver(driver string) (Dialect, error) {
+	switch driver {
+	case "sqlite":
+		return DialectSQLite, nil
+	case "postgres", "pgx":
+		return DialectPostgres, nil
+	case "mysql":
+		return DialectMySQL, nil
+	default:
+		return DialectSQLite, fmt.Errorf("%w: %q", ErrUnknownDriver, driver)
+	}
+}
+
+// String returns the name of the dialect.
+func (d Dialect) String() string {
+	switch d {
+	case DialectSQLite:
+		return "sqlite"
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	default:
+		return "Dialect(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+// usesReturning reports whether inserted ids must be read with a
+// RETURNING clause because the driver does not support LastInsertId.
+func (d Dialect) usesReturning() bool {
+	return d == DialectPostgres
+}
+
+// resetSequenceQuery returns the statement that moves the sequence
+// generating the numbers of table past the highest stored number, or ""
+// if the database does so itself when a number is inserted explicitly.
+func (d Dialect) resetSequenceQuery(table TableName) string {
+	if d != DialectPostgres {
+		return ""
+	}
+
+	return "SELECT setval(pg_get_serial_sequence('" + table.String() + "', 'number'), (SELECT COALESCE(MAX(number), 0) + 1 FROM " + table.String() + "), false)"
+}
+
+// Rebind rewrites the "?" placeholders of query into the dialect's
+// native placeholder syntax.
+//
+// Question marks inside single-quoted string literals are left intact.
+//
+// Parameters:
+// - query: the SQL statement written with "?" placeholders.
+//
+// Returns:
+// - The statement using the dialect's placeholders.
+func (d Dialect) Rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var (
+		builder  strings.Builder
+		position int
+		quoted   bool
+	)
+
+	builder.Grow(len(query) + 8)
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			position++
+			builder.WriteByte('$')
+			builder.WriteString(strconv.Itoa(position))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialectRebind(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{
+			name:    "sqlite keeps question marks",
+			dialect: DialectSQLite,
+			query:   "UPDATE parcel SET status = ? WHERE number = ?",
+			want:    "UPDATE parcel SET status = ? WHERE number = ?",
+		},
+		{
+			name:    "postgres numbers placeholders",
+			dialect: DialectPostgres,
+			query:   "UPDATE parcel SET status = ? WHERE number = ?",
+			want:    "UPDATE parcel SET status = $1 WHERE number = $2",
+		},
+		{
+			name:    "postgres skips string literals",
+			dialect: DialectPostgres,
+			query:   "SELECT number FROM parcel WHERE address = '?' AND client = ?",
+			want:    "SELECT number FROM parcel WHERE address = '?' AND client = $1",
+		},
+		{
+			name:    "mysql keeps question marks",
+			dialect: DialectMySQL,
+			query:   "UPDATE parcel SET status = ? WHERE number = ?",
+			want:    "UPDATE parcel SET status = ? WHERE number = ?",
+		},
+		{
+			name:    "postgres without placeholders",
+			dialect: DialectPostgres,
+			query:   "SELECT number FROM parcel",
+			want:    "SELECT number FROM parcel",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, tt.dialect.Rebind(tt.query))
+		})
+	}
+}
+
+func TestNewParcelStoreForDriver(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		driver  string
+		want    Dialect
+		wantErr require.ErrorAssertionFunc
+	}{
+		{driver: "sqlite", want: DialectSQLite, wantErr: require.NoError},
+		{driver: "postgres", want: DialectPostgres, wantErr: require.NoError},
+		{driver: "pgx", want: DialectPostgres, wantErr: require.NoError},
+		{driver: "mysql", want: DialectMySQL, wantErr: require.NoError},
+		{
+			driver: "oracle",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrUnknownDriver, i...)
+				require.ErrorContains(tt, err, `"oracle"`, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			t.Parallel()
+
+			store, err := NewParcelStoreForDriver(nil, tt.driver)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, store.dialect)
+		})
+	}
+}
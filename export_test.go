@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	source := NewParcelStore(openTestDB(t))
+	numbers := seedParcels(t, source, 1000, 3)
+	require.NoError(t, source.SetStatus(ctx, int(numbers[1]), ParcelStatusSent, 1))
+	require.NoError(t, source.SetAddress(ctx, int(numbers[2]), "new address", 1))
+	require.NoError(t, source.Delete(ctx, int(numbers[0])))
+
+	detailed := Parcel{
+		Client:       1000,
+		Status:       ParcelStatusRegistered,
+		Address:      "test address",
+		CreatedAt:    createdAt,
+		RegisteredBy: "op-1",
+		ExternalRef:  "order-1",
+		WeightGrams:  1200,
+		LengthMM:     300,
+		WidthMM:      200,
+		HeightMM:     100,
+		Priority:     7,
+		Metadata:     map[string]string{"fragile": "yes"},
+	}
+	require.NoError(t, source.Add(ctx, &detailed))
+	require.NoError(t, source.SetStatus(ctx, int(detailed.Number), ParcelStatusSent, 1))
+	require.NoError(t, source.SetStatus(ctx, int(detailed.Number), ParcelStatusDelivered, 2))
+
+	var dump bytes.Buffer
+	require.NoError(t, source.ExportAllJSON(ctx, &dump))
+
+	var exported []Parcel
+	require.NoError(t, json.Unmarshal(dump.Bytes(), &exported))
+	require.Equal(t, []int64{numbers[1], numbers[2], detailed.Number}, parcelNumbers(exported))
+
+	target := NewParcelStore(openTestDB(t))
+	count, err := target.ImportJSON(ctx, bytes.NewReader(dump.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, len(exported), count)
+
+	var redump bytes.Buffer
+	require.NoError(t, target.ExportAllJSON(ctx, &redump))
+	require.JSONEq(t, dump.String(), redump.String())
+
+	got, err := target.Get(ctx, int(detailed.Number))
+	require.NoError(t, err)
+	require.Equal(t, TrackingCode(detailed.Number), got.TrackingCode)
+	require.Equal(t, 3, got.Version)
+	require.True(t, got.SentAt.Valid)
+	require.True(t, got.DeliveredAt.Valid)
+	require.Equal(t, map[string]string{"fragile": "yes"}, got.Metadata)
+
+	added := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt}
+	require.NoError(t, target.Add(ctx, &added))
+	require.Greater(t, added.Number, detailed.Number)
+}
+
+func TestImportJSONKeepsDeletedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	count, err := store.ImportJSON(ctx, strings.NewReader(`[
+		{"number": 7, "client": 1000, "status": "registered", "address": "a", "created_at": "2024-03-10T12:00:00Z",
+		 "updated_at": "2024-03-11T12:00:00Z", "deleted_at": "2024-03-11T12:00:00Z", "version": 2}
+	]`))
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	_, err = store.Get(ctx, 7)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	require.NoError(t, store.Restore(ctx, 7))
+
+	got, err := store.Get(ctx, 7)
+	require.NoError(t, err)
+	require.Equal(t, 3, got.Version)
+	require.Equal(t, TrackingCode(7), got.TrackingCode)
+}
+
+func TestImportJSONNumberConflict(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	number := seedParcels(t, store, 1000, 1)[0]
+
+	dump, err := json.Marshal([]Parcel{
+		{Number: number + 1, Client: 1000, Status: ParcelStatusRegistered, Address: "a", CreatedAt: time.Now().UTC()},
+		{Number: number, Client: 1000, Status: ParcelStatusRegistered, Address: "b", CreatedAt: time.Now().UTC()},
+	})
+	require.NoError(t, err)
+
+	count, err := store.ImportJSON(ctx, bytes.NewReader(dump))
+	require.ErrorIs(t, err, ErrParcelNumberConflict)
+	require.Zero(t, count)
+
+	_, err = store.Get(ctx, int(number+1))
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+func TestExportAllJSONEmpty(t *testing.T) {
+	t.Parallel()
+
+	var dump bytes.Buffer
+	require.NoError(t, NewParcelStore(openTestDB(t)).ExportAllJSON(context.Background(), &dump))
+	require.Equal(t, "[]", dump.String())
+}
+
+func TestImportJSONInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	_, err := store.ImportJSON(ctx, strings.NewReader(`[{"client": 1000, "status": "registered", "created_at": "yesterday"}]`))
+	require.ErrorContains(t, err, "parcelstore.ImportJSON")
+
+	_, err = store.ImportJSON(ctx, strings.NewReader(`[
+		{"client": 1000, "status": "registered", "address": "a", "created_at": "2024-03-10T12:00:00Z"},
+		{"client": 1000, "status": "lost", "address": "b", "created_at": "2024-03-10T12:00:00Z"}
+	]`))
+	require.ErrorIs(t, err, ErrInvalidStatus)
+
+	count, err := store.CountByClient(ctx, 1000)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+// cancelAfterReads cancels a context once a number of bytes have been
+// read, to abort an import part way through the input.
+type cancelAfterReads struct {
+	r      io.Reader
+	cancel context.CancelFunc
+	left   int
+}
+
+func (c *cancelAfterReads) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p[:min(len(p), 64)])
+
+	c.left -= n
+	if c.left <= 0 {
+		c.cancel()
+	}
+
+	return n, err
+}
+
+func TestImportJSONCancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+
+	parcels := make([]Parcel, 50)
+	for i := range parcels {
+		parcels[i] = Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: time.Now().UTC()}
+	}
+	dump, err := json.Marshal(parcels)
+	require.NoError(t, err)
+
+	count, err := store.ImportJSON(ctx, &cancelAfterReads{r: bytes.NewReader(dump), cancel: cancel, left: len(dump) / 2})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, count)
+
+	var stored int
+	require.NoError(t, db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM parcel").Scan(&stored))
+	require.Zero(t, stored)
+}
+
+func TestImportJSONPostgresResetsSequence(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM parcel WHERE number = $1")).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	dbMock.ExpectExec(regexp.QuoteMeta("INSERT INTO parcel (number, client, status")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(regexp.QuoteMeta("SELECT setval(pg_get_serial_sequence('parcel', 'number')")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+
+	store := NewParcelStore(db, WithDialect(DialectPostgres), WithPreparedStatements(false))
+	count, err := store.ImportJSON(context.Background(), strings.NewReader(
+		`[{"number": 7, "client": 1000, "status": "registered", "address": "a", "created_at": "2024-03-10T12:00:00Z"}]`))
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// registerRequest is the body of POST /parcels. Its fields use the same
+// JSON names as Parcel.
+type registerRequest struct {
+	Client       int64  `json:"client"`
+	Address      string `json:"address"`
+	RegisteredBy string `json:"registered_by"`
+}
+
+// addressRequest is the body of PATCH /parcels/{number}/address.
+type addressRequest struct {
+	Address string `json:"address"`
+}
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// parcelHandler serves the REST API over a ParcelService.
+type parcelHandler struct {
+	service ParcelService
+	// registrar registers the parcels of POST /parcels; it is the
+	// service unless WithRegistrar is used.
+	registrar Registrar
+}
+
+// HandlerOption configures the http.Handler returned by Handler.
+type HandlerOption func(*parcelHandler)
+
+// WithRegistrar makes the handler register parcels through registrar
+// instead of the service, such as a RateLimitedRegistrar wrapping it.
+// A nil registrar keeps the service.
+func WithRegistrar(registrar Registrar) HandlerOption {
+	return func(h *parcelHandler) {
+		if registrar != nil {
+			h.registrar = registrar
+		}
+	}
+}
+
+// Handler returns an http.Handler exposing service as a JSON REST API.
+//
+// Routes:
+//   - POST /parcels registers a parcel.
+//   - GET /parcels/{number} returns a parcel.
+//   - GET /clients/{id}/parcels lists the parcels of a client.
+//   - PATCH /parcels/{number}/address changes the address of a parcel.
+//   - POST /parcels/{number}/next-status advances the status of a parcel.
+//   - DELETE /parcels/{number} deletes a registered parcel.
+//
+// Parcels are encoded with the Parcel JSON representation. Errors are
+// returned as {"error": "..."} with a status code matching the cause:
+// 400 for invalid input, 403 for a client that may not register
+// parcels, 404 for a missing parcel, 409 for a parcel that cannot be
+// deleted, rerouted or changed concurrently and 429 for a client over
+// its registration rate limit (see WithRegistrar). Other errors are
+// logged with the service logger and answered with a 500 that does
+// not reveal them.
+func Handler(service ParcelService, opts ...HandlerOption) http.Handler {
+	h := parcelHandler{service: service, registrar: service}
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /parcels", h.register)
+	mux.HandleFunc("GET /parcels/{number}", h.get)
+	mux.HandleFunc("GET /clients/{id}/parcels", h.clientParcels)
+	mux.HandleFunc("PATCH /parcels/{number}/address", h.changeAddress)
+	mux.HandleFunc("POST /parcels/{number}/next-status", h.nextStatus)
+	mux.HandleFunc("DELETE /parcels/{number}", h.delete)
+
+	return mux
+}
+
+func (h parcelHandler) register(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	parcel, err := h.registrar.RegisterBy(r.Context(), req.Client, req.Address, req.RegisteredBy)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, parcel)
+}
+
+func (h parcelHandler) get(w http.ResponseWriter, r *http.Request) {
+	number, ok := pathInt(w, r, "number")
+	if !ok {
+		return
+	}
+
+	parcel, err := h.service.Get(r.Context(), number)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, parcel)
+}
+
+func (h parcelHandler) clientParcels(w http.ResponseWriter, r *http.Request) {
+	client, ok := pathInt(w, r, "id")
+	if !ok {
+		return
+	}
+
+	parcels, err := h.service.ClientParcels(r.Context(), client)
+	if err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	if parcels == nil {
+		parcels = []Parcel{}
+	}
+
+	writeJSON(w, http.StatusOK, parcels)
+}
+
+func (h parcelHandler) changeAddress(w http.ResponseWriter, r *http.Request) {
+	number, ok := pathInt(w, r, "number")
+	if !ok {
+		return
+	}
+
+	var req addressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.service.ChangeAddress(r.Context(), number, req.Address); err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.get(w, r)
+}
+
+func (h parcelHandler) nextStatus(w http.ResponseWriter, r *http.Request) {
+	number, ok := pathInt(w, r, "number")
+	if !ok {
+		return
+	}
+
+	if err := h.service.NextStatus(r.Context(), number); err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	h.get(w, r)
+}
+
+func (h parcelHandler) delete(w http.ResponseWriter, r *http.Request) {
+	number, ok := pathInt(w, r, "number")
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), number); err != nil {
+		h.writeServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathInt parses the named path value as an integer. On failure it
+// writes a 400 response and returns false.
+func pathInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid "+name))
+		return 0, false
+	}
+
+	return value, true
+}
+
+// writeServiceError writes err with the status code matching its
+// cause. An unexpected error is logged and not sent to the client, as
+// it may reveal internals such as queries.
+func (h parcelHandler) writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, ErrParcelNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrParcelNotDeletable), errors.Is(err, ErrParcelAlreadyDelivered), errors.Is(err, ErrStatusChanged),
+		errors.Is(err, ErrVersionConflict):
+		status = http.StatusConflict
+	case errors.Is(err, ErrClientNotAllowed), errors.Is(err, ErrClientParcelLimitExceeded):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrEmptyAddress), errors.Is(err, ErrAddressRejected), errors.Is(err, ErrAddressTooLong),
+		errors.Is(err, ErrInvalidStatus), errors.Is(err, ErrInvalidClient), errors.Is(err, ErrInvalidDimensions),
+		errors.Is(err, ErrInvalidPriority):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrRateLimited):
+		status = http.StatusTooManyRequests
+	}
+
+	if status >= http.StatusInternalServerError {
+		h.service.logger.ErrorContext(r.Context(), "http.request_failed",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("error", err.Error()))
+
+		err = errors.New(http.StatusText(status))
+	}
+
+	writeError(w, status, err)
+}
+
+// writeError writes err as an errorResponse with the given status code.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes v as a JSON body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHandler returns a Handler backed by a fresh in-memory store.
+func newTestHandler(t *testing.T, opts ...ServiceOption) (http.Handler, ParcelStore) {
+	t.Helper()
+
+	store := NewParcelStore(openTestDB(t))
+	service := NewParcelService(store, append([]ServiceOption{WithOutput(io.Discard)}, opts...)...)
+
+	return Handler(service), store
+}
+
+// serve runs a request against h and returns the recorded response.
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// decodeParcel decodes a Parcel from the body of rec.
+func decodeParcel(t *testing.T, rec *httptest.ResponseRecorder) Parcel {
+	t.Helper()
+
+	var parcel Parcel
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &parcel))
+
+	return parcel
+}
+
+func parcelPath(number int64, suffix string) string {
+	return "/parcels/" + strconv.FormatInt(number, 10) + suffix
+}
+
+func TestHandlerRegister(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		opts       []ServiceOption
+		wantStatus int
+	}{
+		{
+			name:       "success",
+			body:       `{"client": 1000, "address": "test address", "registered_by": "op-1"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "malformed body",
+			body:       `{"client":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "empty address",
+			body:       `{"client": 1000, "address": " "}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "client not allowed",
+			body:       `{"client": 2000, "address": "test address"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid client",
+			body:       `{"client": 0, "address": "test address"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "address too long",
+			body:       `{"client": 1000, "address": "test address"}`,
+			opts:       []ServiceOption{WithMaxAddressLen(4)},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "address rejected by validator",
+			body: `{"client": 1000, "address": "test address"}`,
+			opts: []ServiceOption{WithAddressValidator(AddressValidatorFunc(func(string) error {
+				return errors.New("address is not served")
+			}))},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, _ := newTestHandler(t, append([]ServiceOption{WithAllowedClients(1000)}, tt.opts...)...)
+
+			rec := serve(h, http.MethodPost, "/parcels", tt.body)
+			require.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			parcel := decodeParcel(t, rec)
+			require.NotZero(t, parcel.Number)
+			require.Equal(t, int64(1000), parcel.Client)
+			require.Equal(t, "test address", parcel.Address)
+			require.Equal(t, ParcelStatusRegistered, parcel.Status)
+			require.Equal(t, "op-1", parcel.RegisteredBy)
+		})
+	}
+}
+
+func TestHandlerGet(t *testing.T) {
+	t.Parallel()
+
+	h, store := newTestHandler(t)
+	number := seedParcels(t, store, 1000, 1)[0]
+
+	rec := serve(h, http.MethodGet, parcelPath(number, ""), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, number, decodeParcel(t, rec).Number)
+
+	rec = serve(h, http.MethodGet, parcelPath(number+1, ""), "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(h, http.MethodGet, "/parcels/abc", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandlerClientParcels(t *testing.T) {
+	t.Parallel()
+
+	h, store := newTestHandler(t)
+	numbers := seedParcels(t, store, 1000, 2)
+
+	rec := serve(h, http.MethodGet, "/clients/1000/parcels", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var parcels []Parcel
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &parcels))
+	require.Equal(t, numbers, parcelNumbers(parcels))
+
+	rec = serve(h, http.MethodGet, "/clients/1001/parcels", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestHandlerChangeAddress(t *testing.T) {
+	t.Parallel()
+
+	h, store := newTestHandler(t)
+	number := seedParcels(t, store, 1000, 1)[0]
+
+	rec := serve(h, http.MethodPatch, parcelPath(number, "/address"), `{"address": "new address"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "new address", decodeParcel(t, rec).Address)
+
+	rec = serve(h, http.MethodPatch, parcelPath(number, "/address"), `{"address": ""}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(h, http.MethodPatch, parcelPath(number+1, "/address"), `{"address": "new address"}`)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandlerNextStatus(t *testing.T) {
+	t.Parallel()
+
+	h, store := newTestHandler(t)
+	number := seedParcels(t, store, 1000, 1)[0]
+
+	rec := serve(h, http.MethodPost, parcelPath(number, "/next-status"), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, ParcelStatusSent, decodeParcel(t, rec).Status)
+
+	rec = serve(h, http.MethodPost, parcelPath(number+1, "/next-status"), "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandlerDelete(t *testing.T) {
+	t.Parallel()
+
+	h, store := newTestHandler(t)
+	numbers := seedParcels(t, store, 1000, 2)
+	require.NoError(t, store.SetStatus(context.Background(), int(numbers[1]), ParcelStatusSent, 1))
+
+	rec := serve(h, http.MethodDelete, parcelPath(numbers[0], ""), "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve(h, http.MethodDelete, parcelPath(numbers[0], ""), "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(h, http.MethodDelete, parcelPath(numbers[1], ""), "")
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestHandlerRegisterRateLimited(t *testing.T) {
+	t.Parallel()
+
+	service := NewParcelService(NewParcelStore(openTestDB(t)), WithOutput(io.Discard))
+	h := Handler(service, WithRegistrar(NewRateLimitedRegistrar(service, 0, 1)))
+
+	body := `{"client": 1000, "address": "test address", "registered_by": "op-1"}`
+
+	rec := serve(h, http.MethodPost, "/parcels", body)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Equal(t, "op-1", decodeParcel(t, rec).RegisteredBy)
+
+	rec = serve(h, http.MethodPost, "/parcels", body)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+
+	// Other clients have their own buckets.
+	rec = serve(h, http.MethodPost, "/parcels", `{"client": 2000, "address": "test address"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
+
+func TestWriteServiceError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "not found", err: ErrParcelNotFound, wantStatus: http.StatusNotFound},
+		{name: "empty address", err: ErrEmptyAddress, wantStatus: http.StatusBadRequest},
+		{name: "address too long", err: ErrAddressTooLong, wantStatus: http.StatusBadRequest},
+		{
+			name:       "address rejected",
+			err:        fmt.Errorf("%w: %w", ErrAddressRejected, errors.New("address is not served")),
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "invalid dimensions", err: ErrInvalidDimensions, wantStatus: http.StatusBadRequest},
+		{name: "invalid priority", err: ErrInvalidPriority, wantStatus: http.StatusBadRequest},
+		{name: "rate limited", err: ErrRateLimited, wantStatus: http.StatusTooManyRequests},
+		{name: "version conflict", err: ErrVersionConflict, wantStatus: http.StatusConflict},
+		{name: "client not allowed", err: ErrClientNotAllowed, wantStatus: http.StatusForbidden},
+		{name: "unknown", err: errors.New("disk full"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var logs bytes.Buffer
+			h := parcelHandler{service: NewParcelService(NewMemoryStore(), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))}
+
+			rec := httptest.NewRecorder()
+			h.writeServiceError(rec, httptest.NewRequest(http.MethodPost, "/parcels", nil), fmt.Errorf("parcelstore.Add: %w", tt.err))
+			require.Equal(t, tt.wantStatus, rec.Code)
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var body errorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			if tt.wantStatus == http.StatusInternalServerError {
+				// The cause is logged, not sent to the client.
+				require.Equal(t, "Internal Server Error", body.Error)
+				require.Contains(t, logs.String(), "disk full")
+			} else {
+				require.Contains(t, body.Error, tt.err.Error())
+				require.Empty(t, logs.String())
+			}
+		})
+	}
+}
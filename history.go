@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// StatusChange is a single entry of a parcel's status history.
+type StatusChange struct {
+	// Number is the number of the parcel whose status changed.
+	Number int64 `json:"number"`
+	// OldStatus is the status the parcel had before the change.
+	OldStatus ParcelStatus `json:"old_status"`
+	// NewStatus is the status the parcel has after the change.
+	NewStatus ParcelStatus `json:"new_status"`
+	// ChangedAt is the moment of the change.
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// recordStatusChange appends an entry to the status history of the
+// given parcel. It is meant to be called inside the transaction that
+// changes the status.
+func (s ParcelStore) recordStatusChange(ctx context.Context, number int, oldStatus, newStatus ParcelStatus, changedAt time.Time) error {
+	_, err := s.executor().ExecContext(ctx, s.dialect.Rebind("INSERT INTO "+s.table.statusHistory()+" (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)"),
+		number, oldStatus, newStatus, changedAt)
+	return err
+}
+
+// GetStatusHistory retrieves the status changes of a parcel, oldest
+// first.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel.
+//
+// Returns:
+// - A slice of StatusChange entries; empty if the status never changed.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetStatusHistory(ctx context.Context, number int) (_ []StatusChange, err error) {
+	ctx, span := s.startSpan(ctx, "GetStatusHistory", attrNumber(number))
+	defer s.observe(span, "GetStatusHistory", &err)
+
+	return s.statusHistory(ctx, number)
+}
+
+// statusHistory does the work of GetStatusHistory without a span or
+// stats of its own.
+func (s ParcelStore) statusHistory(ctx context.Context, number int) (_ []StatusChange, err error) {
+	rows, err := s.forReads().executor().QueryContext(ctx, s.dialect.Rebind("SELECT number, old_status, new_status, changed_at FROM "+s.table.statusHistory()+" WHERE number = ? ORDER BY id"), number)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var changes []StatusChange
+	for rows.Next() {
+		var change StatusChange
+
+		err = rows.Scan(&change.Number, &change.OldStatus, &change.NewStatus, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// StatusDurations reports how long a parcel has spent in each status,
+// from its creation through every recorded status change. Time in the
+// current status is counted up to now. A status the parcel entered
+// more than once, e.g. after RevertStatus, gets the sum of its stays.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the queries.
+// - number: the unique number of the parcel.
+//
+// Returns:
+//   - The time spent in each status the parcel has been in; statuses it
+//     never had are missing.
+//   - ErrParcelNotFound if the parcel does not exist, or any error of
+//     the queries.
+func (s ParcelStore) StatusDurations(ctx context.Context, number int) (_ map[ParcelStatus]time.Duration, err error) {
+	ctx, span := s.startSpan(ctx, "StatusDurations", attrNumber(number))
+	defer s.observe(span, "StatusDurations", &err)
+
+	parcel, err := s.get(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.statusHistory(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	status, since := parcel.Status, parcel.CreatedAt
+	if len(history) > 0 {
+		status = history[0].OldStatus
+	}
+
+	durations := make(map[ParcelStatus]time.Duration)
+	for _, change := range history {
+		durations[change.OldStatus] += max(change.ChangedAt.Sub(since), 0)
+		status, since = change.NewStatus, change.ChangedAt
+	}
+
+	durations[status] += max(time.Since(since), 0)
+
+	return durations, nil
+}
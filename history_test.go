@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStatusHistory(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+
+	numbers := seedParcels(t, store, 1000, 2)
+	number := int(numbers[0])
+
+	require.NoError(t, store.SetStatus(ctx, number, ParcelStatusSent, 1))
+	change, advanced, err := store.AdvanceStatus(ctx, number)
+	require.NoError(t, err)
+	require.True(t, advanced)
+	require.Equal(t, ParcelStatusDelivered, change.NewStatus)
+
+	history, err := store.GetStatusHistory(ctx, number)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	require.Equal(t, int64(number), history[0].Number)
+	require.Equal(t, ParcelStatusRegistered, history[0].OldStatus)
+	require.Equal(t, ParcelStatusSent, history[0].NewStatus)
+
+	require.Equal(t, int64(number), history[1].Number)
+	require.Equal(t, ParcelStatusSent, history[1].OldStatus)
+	require.Equal(t, ParcelStatusDelivered, history[1].NewStatus)
+	require.False(t, history[1].ChangedAt.Before(history[0].ChangedAt))
+
+	untouched, err := store.GetStatusHistory(ctx, int(numbers[1]))
+	require.NoError(t, err)
+	require.Empty(t, untouched)
+}
+
+func TestStatusDurations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+
+	createdAt := time.Now().UTC().Add(-24 * time.Hour).Truncate(time.Second)
+	parcel := addParcel(t, store, 1000, createdAt)
+	number := int(parcel.Number)
+
+	// Sent after 2h, reverted after 1h, sent again after 1h and
+	// delivered 6h later.
+	changes := []struct {
+		old, new ParcelStatus
+		after    time.Duration
+	}{
+		{ParcelStatusRegistered, ParcelStatusSent, 2 * time.Hour},
+		{ParcelStatusSent, ParcelStatusRegistered, 3 * time.Hour},
+		{ParcelStatusRegistered, ParcelStatusSent, 4 * time.Hour},
+		{ParcelStatusSent, ParcelStatusDelivered, 10 * time.Hour},
+	}
+	for _, change := range changes {
+		require.NoError(t, store.recordStatusChange(ctx, number, change.old, change.new, createdAt.Add(change.after)))
+	}
+	_, err := db.ExecContext(ctx, "UPDATE parcel SET status = ? WHERE number = ?", ParcelStatusDelivered, number)
+	require.NoError(t, err)
+
+	before := time.Since(createdAt.Add(10 * time.Hour))
+	durations, err := store.StatusDurations(ctx, number)
+	after := time.Since(createdAt.Add(10 * time.Hour))
+	require.NoError(t, err)
+
+	require.Len(t, durations, 3)
+	require.Equal(t, 3*time.Hour, durations[ParcelStatusRegistered])
+	require.Equal(t, 7*time.Hour, durations[ParcelStatusSent])
+	require.GreaterOrEqual(t, durations[ParcelStatusDelivered], before)
+	require.LessOrEqual(t, durations[ParcelStatusDelivered], after)
+}
+
+func TestStatusDurationsWithoutHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	createdAt := time.Now().UTC().Add(-time.Hour)
+	parcel := addParcel(t, store, 1000, createdAt)
+
+	durations, err := store.StatusDurations(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	require.Len(t, durations, 1)
+	require.InDelta(t, time.Hour, durations[ParcelStatusRegistered], float64(time.Minute))
+
+	_, err = store.StatusDurations(ctx, 999)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler is a slog.Handler that drops every record. It is the
+// default handler of the ParcelService logger.
+type discardHandler struct{}
+
+// Enabled reports false for every level, so records are never built.
+func (discardHandler) Enabled(context.Context, slog.Level) bool { return false }
+
+// Handle drops the record.
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+
+// WithAttrs returns the handler itself.
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+// WithGroup returns the handler itself.
+func (h discardHandler) WithGroup(string) slog.Handler { return h }
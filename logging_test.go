@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler is a slog.Handler that keeps every record it gets.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+// attrs returns the attributes of r keyed by name.
+func attrs(r slog.Record) map[string]any {
+	values := make(map[string]any, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		values[a.Key] = a.Value.Any()
+		return true
+	})
+	return values
+}
+
+func TestServiceLogging(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	handler := &recordingHandler{}
+	service := NewParcelService(NewMemoryStore(), WithOutput(io.Discard), WithLogger(slog.New(handler)))
+
+	parcel, err := service.RegisterBy(ctx, 1000, "test address", "op-1")
+	require.NoError(t, err)
+	require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+
+	require.Len(t, handler.records, 2)
+
+	require.Equal(t, "parcel.registered", handler.records[0].Message)
+	require.Equal(t, slog.LevelInfo, handler.records[0].Level)
+	require.Equal(t, map[string]any{
+		"number":        parcel.Number,
+		"client":        int64(1000),
+		"status":        "registered",
+		"registered_by": "op-1",
+	}, attrs(handler.records[0]))
+
+	require.Equal(t, "parcel.status_changed", handler.records[1].Message)
+	require.Equal(t, map[string]any{
+		"number": parcel.Number,
+		"status": "sent",
+	}, attrs(handler.records[1]))
+}
+
+func TestServiceLoggingDefault(t *testing.T) {
+	t.Parallel()
+
+	service := NewParcelService(NewMemoryStore(), WithOutput(io.Discard))
+	require.False(t, service.logger.Enabled(context.Background(), slog.LevelError))
+
+	_, err := service.Register(context.Background(), 1000, "test address")
+	require.NoError(t, err)
+}
@@ -1,131 +1,200 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 	_ "modernc.org/sqlite"
 )
 
-func setDefault(key, value string) {
-	if os.Getenv(key) == "" {
-		os.Setenv(key, value)
-	}
+// sqlDriverNames maps the DB_DRIVER values whose database/sql driver is
+// registered under another name to that name. The pgx driver serves
+// both "postgres" and "pgx".
+var sqlDriverNames = map[string]string{
+	"postgres": "pgx",
 }
 
-func openDB(driver, dns string) (*sql.DB, func(), error) {
-	db, err := sql.Open(driver, dns)
-	if err != nil {
-		return nil, nil, err
+// sqlDriverName returns the name of the database/sql driver opened for
+// the DB_DRIVER value driver.
+func sqlDriverName(driver string) string {
+	if name, ok := sqlDriverNames[driver]; ok {
+		return name
 	}
 
-	closeFunc := func() {
-		_ = db.Close()
-	}
-
-	return db, closeFunc, nil
+	return driver
 }
 
-func main() {
-	err := godotenv.Load()
-	if err != nil {
-		fmt.Println(err)
-		return
+// errDSNMismatch is returned by openDB when the DSN obviously belongs
+// to a different driver than the one configured.
+var errDSNMismatch = errors.New("DSN does not match the database driver")
+
+// validateDSN performs a cheap sanity check that the DSN has the shape
+// expected by the driver. It only rejects obvious mismatches, such as a
+// bare file name for postgres or a network URL for sqlite. MySQL DSNs
+// must set parseTime=true, as timestamps cannot be scanned otherwise.
+func validateDSN(driver, dsn string) error {
+	isURL := strings.Contains(dsn, "://")
+	isKeyValue := strings.Contains(dsn, "=") && !isURL
+
+	switch driver {
+	case "postgres", "pgx":
+		if !isURL && !isKeyValue {
+			return fmt.Errorf("%w: %s expects a URL or key=value DSN", errDSNMismatch, driver)
+		}
+	case "sqlite":
+		if isURL && !strings.HasPrefix(dsn, "file:") {
+			return fmt.Errorf("%w: %s expects a file path", errDSNMismatch, driver)
+		}
+	case "mysql":
+		if !strings.Contains(dsn, "parseTime=true") {
+			return fmt.Errorf("%w: %s expects parseTime=true", errDSNMismatch, driver)
+		}
 	}
 
-	setDefault("DB_DRIVER", "postgres")
-	setDefault("DB_DNS", "example.db")
+	return nil
+}
+
+const (
+	// pingTimeout bounds the connectivity check performed by openDB.
+	pingTimeout = 5 * time.Second
+	// closeTimeout bounds how long main waits for in-flight queries
+	// before closing the database.
+	closeTimeout = 10 * time.Second
+	// drainInterval is how often closeDB checks for connections in use.
+	drainInterval = 10 * time.Millisecond
+	// notifyTimeout bounds each request of the status webhook.
+	notifyTimeout = 5 * time.Second
+)
 
-	databaseDriver := os.Getenv("DB_DRIVER")
-	databaseDNS := os.Getenv("DB_DNS")
+// openDB opens the database described by cfg, applies its connection
+// pool limits and pings it to verify connectivity. The returned
+// function closes the database with closeDB.
+func openDB(ctx context.Context, cfg Config) (*sql.DB, func(context.Context) error, error) {
+	if err := validateDSN(cfg.Driver, cfg.DSN); err != nil {
+		return nil, nil, err
+	}
 
-	db, closeFunc, err := openDB(databaseDriver, databaseDNS)
+	db, err := sql.Open(sqlDriverName(cfg.Driver), cfg.DSN)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, nil, err
 	}
-	defer closeFunc()
 
-	store := NewParcelStore(db)
-	service := NewParcelService(store)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
-	// регистрация посылки
-	client := 1
-	address := "Псков, д. Пушкина, ул. Колотушкина, д. 5"
-	p, err := service.Register(int64(client), address)
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
 
-	if err != nil {
-		fmt.Println(err)
-		return
+	if err = db.PingContext(pingCtx); err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("ping database: %w", err)
 	}
 
-	// изменение адреса
-	newAddress := "Саратов, д. Верхние Зори, ул. Козлова, д. 25"
-	err = service.ChangeAddress(int(p.Number), newAddress)
-
-	if err != nil {
-		fmt.Println(err)
-		return
+	closeFunc := func(ctx context.Context) error {
+		return closeDB(ctx, db)
 	}
 
-	// изменение статуса
-	err = service.NextStatus(int(p.Number))
+	return db, closeFunc, nil
+}
 
-	if err != nil {
-		fmt.Println(err)
-		return
+// closeDB waits until no connection of db is in use, or until ctx is
+// done, and then closes db.
+//
+// Parameters:
+// - ctx: the context bounding the wait for in-flight queries.
+// - db: the database to close.
+//
+// Returns:
+// - An error, if the connections did not drain before ctx was done or
+// closing the database failed.
+func closeDB(ctx context.Context, db *sql.DB) error {
+	drainErr := waitDrained(ctx, db)
+
+	if err := db.Close(); err != nil {
+		return errors.Join(drainErr, fmt.Errorf("close database: %w", err))
 	}
 
-	// вывод посылок клиента
-	err = service.PrintClientParcels(client)
+	return drainErr
+}
 
-	if err != nil {
-		fmt.Println(err)
-		return
+// waitDrained polls db until none of its connections is in use.
+func waitDrained(ctx context.Context, db *sql.DB) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for db.Stats().InUse > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for connections to drain: %w", ctx.Err())
+		case <-ticker.C:
+		}
 	}
 
-	// попытка удаления отправленной посылки
-	err = service.Delete(int(p.Number))
+	return nil
+}
 
-	if err != nil {
-		fmt.Println(err)
-		return
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+}
 
-	// вывод посылок клиента
-	// предыдущая посылка не должна удалиться, т.к. её статус НЕ «зарегистрирована»
-	err = service.PrintClientParcels(client)
+// run loads the configuration, opens the database and runs the
+// subcommand named on the command line. Errors closing the store or
+// the database are joined to the returned error.
+func run() (err error) {
+	if err = godotenv.Load(); err != nil {
+		return err
+	}
 
+	cfg, err := NewConfigFromEnv()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
-	// регистрация новой посылки
-	p, err = service.Register(int64(client), address)
+	ctx := context.Background()
 
+	db, closeFunc, err := openDB(ctx, cfg)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
 
-	// удаление новой посылки
-	err = service.Delete(int(p.Number))
+		err = errors.Join(err, closeFunc(closeCtx))
+	}()
 
+	store, err := NewParcelStoreForDriver(db, cfg.Driver)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
-	// вывод посылок клиента
-	// здесь не должно быть последней посылки, т.к. она должна была успешно удалиться
-	err = service.PrintClientParcels(client)
+	if err = CreateSchema(ctx, db, store.dialect, store.table); err != nil {
+		return err
+	}
 
-	if err != nil {
-		fmt.Println(err)
-		return
+	defer func() {
+		err = errors.Join(err, store.Close())
+	}()
+
+	opts := []ServiceOption{WithOutputFormat(cfg.OutputFormat)}
+	if cfg.NotifyURL != "" {
+		opts = append(opts, WithNotifier(NewHTTPNotifier(cfg.NotifyURL, &http.Client{Timeout: notifyTimeout})))
 	}
 
+	service := NewParcelService(store, opts...)
+
+	return runCommand(ctx, service, os.Args[1:])
 }
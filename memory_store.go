@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateExternalRef is returned by MemoryStore.Add for a parcel
+// whose external reference is already used by another parcel.
+var ErrDuplicateExternalRef = errors.New("external reference already exists")
+
+// MemoryStore is an in-memory ParcelRepository for tests and demos.
+//
+// It follows the same rules as ParcelStore: numbers are assigned
+// sequentially starting at 1, statuses are validated, external
+// references are unique, and only registered parcels can be deleted.
+// It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	parcels map[int64]Parcel
+	history map[int64][]StatusChange
+	// addresses holds the address changes made with a reason.
+	addresses map[int64][]AddressChange
+	last      int64
+}
+
+var _ ParcelRepository = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		parcels:   make(map[int64]Parcel),
+		history:   make(map[int64][]StatusChange),
+		addresses: make(map[int64][]AddressChange),
+	}
+}
+
+// Add stores a copy of p and assigns it the next parcel number.
+func (m *MemoryStore) Add(ctx context.Context, p *Parcel) error {
+	return m.AddMany(ctx, []*Parcel{p})
+}
+
+// AddMany stores copies of the parcels and assigns them consecutive
+// numbers. Like ParcelStore.AddMany it is atomic: if any parcel is
+// invalid or ctx is done, none are stored.
+func (m *MemoryStore) AddMany(ctx context.Context, parcels []*Parcel) error {
+	return m.addMany(ctx, parcels, nil)
+}
+
+// AddWithinLimit stores the parcels like AddMany unless their client
+// would then have more than limit parcels that are not delivered.
+func (m *MemoryStore) AddWithinLimit(ctx context.Context, parcels []*Parcel, limit int) error {
+	return m.addMany(ctx, parcels, func() error {
+		if len(parcels) == 0 || parcels[0] == nil {
+			return nil
+		}
+
+		client := parcels[0].Client
+
+		active := 0
+		for _, p := range m.parcels {
+			if p.Client == client && p.Status != ParcelStatusDelivered {
+				active++
+			}
+		}
+
+		if active+len(parcels) > limit {
+			return fmt.Errorf("%w: client %d has %d of %d", ErrClientParcelLimitExceeded, client, active, limit)
+		}
+
+		return nil
+	})
+}
+
+// addMany stores the parcels for AddMany after check, if it is not nil,
+// accepts them. check runs with m.mu held.
+func (m *MemoryStore) addMany(ctx context.Context, parcels []*Parcel, check func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range parcels {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	refs := make(map[string]struct{})
+	for _, p := range parcels {
+		if p.ExternalRef == "" {
+			continue
+		}
+
+		_, taken := refs[p.ExternalRef]
+		if !taken {
+			_, taken = m.byExternalRef(p.ExternalRef)
+		}
+
+		if taken {
+			return fmt.Errorf("%w: %q", ErrDuplicateExternalRef, p.ExternalRef)
+		}
+
+		refs[p.ExternalRef] = struct{}{}
+	}
+
+	now := time.Now().UTC()
+
+	for _, p := range parcels {
+		m.last++
+		p.Number = m.last
+		p.UpdatedAt = now
+		p.Version = 1
+		p.TrackingCode = TrackingCode(p.Number)
+
+		m.parcels[p.Number] = detach(*p)
+	}
+
+	return nil
+}
+
+// Get returns the parcel with the given number, or ErrParcelNotFound if
+// there is none.
+func (m *MemoryStore) Get(_ context.Context, number int) (Parcel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.parcels[int64(number)]
+	if !ok {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	return detach(p), nil
+}
+
+// GetByExternalRef returns the parcel with the given external
+// reference, or ErrParcelNotFound if there is none.
+func (m *MemoryStore) GetByExternalRef(_ context.Context, ref string) (Parcel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.byExternalRef(ref)
+	if !ok {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	return p, nil
+}
+
+// byExternalRef finds the parcel with the given external reference.
+// The caller must hold m.mu.
+func (m *MemoryStore) byExternalRef(ref string) (Parcel, bool) {
+	for _, p := range m.parcels {
+		if p.ExternalRef == ref {
+			return detach(p), true
+		}
+	}
+
+	return Parcel{}, false
+}
+
+// GetByClient returns the parcels of the given client ordered by number.
+func (m *MemoryStore) GetByClient(_ context.Context, client int) ([]Parcel, error) {
+	return m.filter(func(p Parcel) bool {
+		return p.Client == int64(client)
+	}), nil
+}
+
+// GetByClientAndStatus returns the parcels of the given client in the
+// given status, ordered by number.
+func (m *MemoryStore) GetByClientAndStatus(_ context.Context, client int, status ParcelStatus) ([]Parcel, error) {
+	if !status.IsValid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return m.filter(func(p Parcel) bool {
+		return p.Client == int64(client) && p.Status == status
+	}), nil
+}
+
+// GetLatestByClient returns the most recently created parcel of the
+// given client, or ErrParcelNotFound if it has none.
+func (m *MemoryStore) GetLatestByClient(_ context.Context, client int) (Parcel, error) {
+	parcels := m.filter(func(p Parcel) bool {
+		return p.Client == int64(client)
+	})
+	if len(parcels) == 0 {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	latest := parcels[0]
+	for _, p := range parcels[1:] {
+		if !p.CreatedAt.Before(latest.CreatedAt) {
+			latest = p
+		}
+	}
+
+	return latest, nil
+}
+
+// CountByStatus returns the number of parcels of the given client in
+// each known status, including zero counts.
+func (m *MemoryStore) CountByStatus(_ context.Context, client int) (map[ParcelStatus]int, error) {
+	counts := make(map[ParcelStatus]int, len(knownStatuses))
+	for _, status := range knownStatuses {
+		counts[status] = 0
+	}
+
+	for _, p := range m.filter(func(p Parcel) bool {
+		return p.Client == int64(client)
+	}) {
+		counts[p.Status]++
+	}
+
+	return counts, nil
+}
+
+// GetStatusHistory returns the status changes of the given parcel,
+// oldest first.
+func (m *MemoryStore) GetStatusHistory(_ context.Context, number int) ([]StatusChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]StatusChange(nil), m.history[int64(number)]...), nil
+}
+
+// GetCreatedBetween returns the parcels in the given status created in
+// the interval [from, to), ordered by number.
+func (m *MemoryStore) GetCreatedBetween(_ context.Context, status ParcelStatus, from, to time.Time) ([]Parcel, error) {
+	if !status.IsValid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return m.filter(func(p Parcel) bool {
+		return p.Status == status && !p.CreatedAt.Before(from) && p.CreatedAt.Before(to)
+	}), nil
+}
+
+// SetStatus changes the status of the given parcel if it is still at
+// the expected version.
+func (m *MemoryStore) SetStatus(_ context.Context, number int, status ParcelStatus, version int) error {
+	if !status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return m.update(number, version, func(p *Parcel) {
+		m.changeStatus(p, status, time.Now().UTC())
+	})
+}
+
+// AdvanceStatus moves the given parcel to the next status of its
+// lifecycle and reports the change and whether it moved.
+func (m *MemoryStore) AdvanceStatus(_ context.Context, number int) (StatusChange, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.parcels[int64(number)]
+	if !ok {
+		return StatusChange{}, false, ErrParcelNotFound
+	}
+
+	if !p.Status.IsValid() {
+		return StatusChange{}, false, fmt.Errorf("%w: parcel %d has status %q", ErrInvalidStatus, number, p.Status)
+	}
+
+	change := StatusChange{Number: p.Number, OldStatus: p.Status, NewStatus: p.Status}
+
+	next, ok := p.Status.Next()
+	if !ok {
+		return change, false, nil
+	}
+
+	now := time.Now().UTC()
+
+	m.changeStatus(&p, next, now)
+	p.UpdatedAt = now
+	p.Version++
+	m.parcels[p.Number] = p
+
+	change.NewStatus, change.ChangedAt = next, now
+
+	return change, true, nil
+}
+
+// SetAddress changes the address of the given parcel if it is still at
+// the expected version.
+func (m *MemoryStore) SetAddress(_ context.Context, number int, address string, version int) error {
+	return m.update(number, version, func(p *Parcel) {
+		p.Address = address
+	})
+}
+
+// SetAddressWithReason changes the address of the given parcel like
+// SetAddress and records the change with its reason.
+func (m *MemoryStore) SetAddressWithReason(_ context.Context, number int, address, reason string, version int) error {
+	return m.update(number, version, func(p *Parcel) {
+		m.addresses[p.Number] = append(m.addresses[p.Number], AddressChange{
+			Number:     p.Number,
+			OldAddress: p.Address,
+			NewAddress: address,
+			Reason:     reason,
+			ChangedAt:  time.Now().UTC(),
+		})
+		p.Address = address
+	})
+}
+
+// GetAddressHistory returns the address changes of the given parcel
+// recorded by SetAddressWithReason, oldest first.
+func (m *MemoryStore) GetAddressHistory(_ context.Context, number int) ([]AddressChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]AddressChange(nil), m.addresses[int64(number)]...), nil
+}
+
+// Delete removes the given parcel if it is still registered.
+func (m *MemoryStore) Delete(_ context.Context, number int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.parcels[int64(number)]
+	if !ok || p.Status != ParcelStatusRegistered {
+		return ErrParcelNotFound
+	}
+
+	delete(m.parcels, p.Number)
+
+	return nil
+}
+
+// filter returns the parcels matching keep, ordered by number.
+func (m *MemoryStore) filter(keep func(Parcel) bool) []Parcel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var parcels []Parcel
+	for _, p := range m.parcels {
+		if keep(p) {
+			parcels = append(parcels, detach(p))
+		}
+	}
+
+	sort.Slice(parcels, func(i, j int) bool {
+		return parcels[i].Number < parcels[j].Number
+	})
+
+	return parcels
+}
+
+// detach returns a copy of p with its own metadata map, so the parcels
+// passed in and handed out cannot change the stored ones through it.
+// Empty metadata becomes nil, as read back from ParcelStore.
+func detach(p Parcel) Parcel {
+	if len(p.Metadata) == 0 {
+		p.Metadata = nil
+	} else {
+		p.Metadata = maps.Clone(p.Metadata)
+	}
+
+	return p
+}
+
+// changeStatus moves p to status at now like moveTo and records the
+// change in the status history. The caller must hold m.mu.
+func (m *MemoryStore) changeStatus(p *Parcel, status ParcelStatus, now time.Time) {
+	m.history[p.Number] = append(m.history[p.Number], StatusChange{
+		Number:    p.Number,
+		OldStatus: p.Status,
+		NewStatus: status,
+		ChangedAt: now,
+	})
+
+	moveTo(p, status, now)
+}
+
+// moveTo sets the status of p and stamps or clears SentAt and
+// DeliveredAt at now, like ParcelStore does when the status changes.
+func moveTo(p *Parcel, status ParcelStatus, now time.Time) {
+	timestamps := map[string]*sql.NullTime{
+		"sent_at":      &p.SentAt,
+		"delivered_at": &p.DeliveredAt,
+	}
+
+	stamp, clear := timestampChanges(p.Status, status)
+	p.Status = status
+
+	for _, column := range clear {
+		*timestamps[column] = sql.NullTime{}
+	}
+
+	if stamp != "" {
+		*timestamps[stamp] = sql.NullTime{Time: now, Valid: true}
+	}
+}
+
+// update applies change to the given parcel and bumps its UpdatedAt and
+// Version. It returns ErrParcelNotFound if there is no such parcel and
+// ErrVersionConflict if it is not at the expected version.
+func (m *MemoryStore) update(number, version int, change func(*Parcel)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.parcels[int64(number)]
+	if !ok {
+		return ErrParcelNotFound
+	}
+
+	if p.Version != version {
+		return ErrVersionConflict
+	}
+
+	change(&p)
+	p.UpdatedAt = time.Now().UTC()
+	p.Version++
+	m.parcels[p.Number] = p
+
+	return nil
+}
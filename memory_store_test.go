@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// repositories returns a fresh instance of every ParcelRepository
+// implementation, keyed by name, so scenarios can check they behave the
+// same way.
+func repositories(t *testing.T) map[string]ParcelRepository {
+	t.Helper()
+
+	return map[string]ParcelRepository{
+		"memory": NewMemoryStore(),
+		"sqlite": NewParcelStore(openTestDB(t)),
+	}
+}
+
+// addParcel stores a registered parcel of client and returns it.
+func addParcel(t *testing.T, repo ParcelRepository, client int64, createdAt time.Time) Parcel {
+	t.Helper()
+
+	parcel := Parcel{Client: client, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt}
+	require.NoError(t, repo.Add(context.Background(), &parcel))
+
+	return parcel
+}
+
+func TestRepositoryParity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo ParcelRepository)
+	}{
+		{
+			name: "add assigns sequential numbers",
+			run: func(t *testing.T, repo ParcelRepository) {
+				first := addParcel(t, repo, 1000, createdAt)
+				second := addParcel(t, repo, 1000, createdAt)
+				require.Equal(t, int64(1), first.Number)
+				require.Equal(t, int64(2), second.Number)
+
+				got, err := repo.Get(ctx, int(first.Number))
+				require.NoError(t, err)
+				require.Equal(t, first.Client, got.Client)
+				require.Equal(t, first.Address, got.Address)
+				require.Equal(t, first.Status, got.Status)
+				require.True(t, first.CreatedAt.Equal(got.CreatedAt))
+			},
+		},
+		{
+			name: "add rejects invalid status",
+			run: func(t *testing.T, repo ParcelRepository) {
+				err := repo.Add(ctx, &Parcel{Client: 1000, Status: "lost"})
+				require.ErrorIs(t, err, ErrInvalidStatus)
+			},
+		},
+		{
+			name: "get missing parcel",
+			run: func(t *testing.T, repo ParcelRepository) {
+				got, err := repo.Get(ctx, 999)
+				require.ErrorIs(t, err, ErrParcelNotFound)
+				require.Equal(t, Parcel{}, got)
+			},
+		},
+		{
+			name: "external refs are unique",
+			run: func(t *testing.T, repo ParcelRepository) {
+				parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt, ExternalRef: "order-1"}
+				require.NoError(t, repo.Add(ctx, &parcel))
+				addParcel(t, repo, 1000, createdAt)
+				addParcel(t, repo, 1000, createdAt)
+
+				got, err := repo.GetByExternalRef(ctx, "order-1")
+				require.NoError(t, err)
+				require.Equal(t, parcel.Number, got.Number)
+				require.Equal(t, "order-1", got.ExternalRef)
+
+				got, err = repo.GetByExternalRef(ctx, "order-2")
+				require.ErrorIs(t, err, ErrParcelNotFound)
+				require.Equal(t, Parcel{}, got)
+
+				duplicate := Parcel{Client: 1001, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt, ExternalRef: "order-1"}
+				require.Error(t, repo.Add(ctx, &duplicate))
+			},
+		},
+		{
+			name: "get by client in number order",
+			run: func(t *testing.T, repo ParcelRepository) {
+				first := addParcel(t, repo, 1000, createdAt)
+				addParcel(t, repo, 1001, createdAt)
+				third := addParcel(t, repo, 1000, createdAt)
+
+				parcels, err := repo.GetByClient(ctx, 1000)
+				require.NoError(t, err)
+				require.Equal(t, []int64{first.Number, third.Number}, parcelNumbers(parcels))
+			},
+		},
+		{
+			name: "get created between",
+			run: func(t *testing.T, repo ParcelRepository) {
+				inside := addParcel(t, repo, 1000, createdAt)
+				addParcel(t, repo, 1000, createdAt.Add(24*time.Hour))
+
+				parcels, err := repo.GetCreatedBetween(ctx, ParcelStatusRegistered, createdAt, createdAt.Add(time.Hour))
+				require.NoError(t, err)
+				require.Equal(t, []int64{inside.Number}, parcelNumbers(parcels))
+			},
+		},
+		{
+			name: "set status and address",
+			run: func(t *testing.T, repo ParcelRepository) {
+				parcel := addParcel(t, repo, 1000, createdAt)
+
+				require.NoError(t, repo.SetStatus(ctx, int(parcel.Number), ParcelStatusSent, 1))
+				require.NoError(t, repo.SetAddress(ctx, int(parcel.Number), "new address", 2))
+
+				got, err := repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.Equal(t, ParcelStatusSent, got.Status)
+				require.Equal(t, "new address", got.Address)
+
+				require.ErrorIs(t, repo.SetStatus(ctx, int(parcel.Number), "lost", 3), ErrInvalidStatus)
+				require.ErrorIs(t, repo.SetStatus(ctx, 999, ParcelStatusSent, 1), ErrParcelNotFound)
+				require.ErrorIs(t, repo.SetAddress(ctx, 999, "new address", 1), ErrParcelNotFound)
+			},
+		},
+		{
+			name: "stale version loses the race",
+			run: func(t *testing.T, repo ParcelRepository) {
+				parcel := addParcel(t, repo, 1000, createdAt)
+
+				first, err := repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				second, err := repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.Equal(t, 1, first.Version)
+
+				require.NoError(t, repo.SetAddress(ctx, int(parcel.Number), "first address", first.Version))
+				require.ErrorIs(t, repo.SetAddress(ctx, int(parcel.Number), "second address", second.Version), ErrVersionConflict)
+				require.ErrorIs(t, repo.SetStatus(ctx, int(parcel.Number), ParcelStatusSent, second.Version), ErrVersionConflict)
+
+				got, err := repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.Equal(t, "first address", got.Address)
+				require.Equal(t, ParcelStatusRegistered, got.Status)
+				require.Equal(t, 2, got.Version)
+
+				_, _, err = repo.AdvanceStatus(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.ErrorIs(t, repo.SetStatus(ctx, int(parcel.Number), ParcelStatusDelivered, got.Version), ErrVersionConflict)
+				require.NoError(t, repo.SetStatus(ctx, int(parcel.Number), ParcelStatusDelivered, got.Version+1))
+			},
+		},
+		{
+			name: "advance status through the lifecycle",
+			run: func(t *testing.T, repo ParcelRepository) {
+				parcel := addParcel(t, repo, 1000, createdAt)
+
+				for _, want := range []ParcelStatus{ParcelStatusSent, ParcelStatusDelivered} {
+					change, advanced, err := repo.AdvanceStatus(ctx, int(parcel.Number))
+					require.NoError(t, err)
+					require.True(t, advanced)
+					require.Equal(t, want, change.NewStatus)
+				}
+
+				change, advanced, err := repo.AdvanceStatus(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.False(t, advanced)
+				require.Equal(t, ParcelStatusDelivered, change.OldStatus)
+				require.Equal(t, ParcelStatusDelivered, change.NewStatus)
+
+				change, advanced, err = repo.AdvanceStatus(ctx, 999)
+				require.ErrorIs(t, err, ErrParcelNotFound)
+				require.False(t, advanced)
+				require.Empty(t, change.NewStatus)
+			},
+		},
+		{
+			name: "delete only registered parcels",
+			run: func(t *testing.T, repo ParcelRepository) {
+				registered := addParcel(t, repo, 1000, createdAt)
+				sent := addParcel(t, repo, 1000, createdAt)
+				require.NoError(t, repo.SetStatus(ctx, int(sent.Number), ParcelStatusSent, 1))
+
+				require.NoError(t, repo.Delete(ctx, int(registered.Number)))
+				require.ErrorIs(t, repo.Delete(ctx, int(registered.Number)), ErrParcelNotFound)
+				require.ErrorIs(t, repo.Delete(ctx, int(sent.Number)), ErrParcelNotFound)
+
+				parcels, err := repo.GetByClient(ctx, 1000)
+				require.NoError(t, err)
+				require.Equal(t, []int64{sent.Number}, parcelNumbers(parcels))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for name, repo := range repositories(t) {
+				t.Run(name, func(t *testing.T) {
+					tt.run(t, repo)
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// SetMetadata sets the metadata value of the parcel under key,
+// creating the metadata map if the parcel has none.
+func (p *Parcel) SetMetadata(key, value string) {
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]string)
+	}
+
+	p.Metadata[key] = value
+}
+
+// GetMetadata returns the metadata value of the parcel under key and
+// reports whether it is set.
+func (p Parcel) GetMetadata(key string) (string, bool) {
+	value, ok := p.Metadata[key]
+
+	return value, ok
+}
+
+// encodeMetadata converts parcel metadata to the value of the metadata
+// column: a JSON object, or NULL for nil or empty metadata.
+func encodeMetadata(metadata map[string]string) (sql.NullString, error) {
+	if len(metadata) == 0 {
+		return sql.NullString{}, nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("encode metadata: %w", err)
+	}
+
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeMetadata converts the value of the metadata column back to
+// parcel metadata. NULL and empty values give nil metadata.
+func decodeMetadata(column sql.NullString) (map[string]string, error) {
+	if !column.Valid || column.String == "" {
+		return nil, nil
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(column.String), &metadata); err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+
+	return metadata, nil
+}
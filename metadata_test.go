@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParcelMetadataAccessors(t *testing.T) {
+	t.Parallel()
+
+	var p Parcel
+
+	_, ok := p.GetMetadata("fragile")
+	require.False(t, ok)
+
+	p.SetMetadata("fragile", "true")
+	p.SetMetadata("insurance", "1500")
+	p.SetMetadata("fragile", "false")
+
+	value, ok := p.GetMetadata("fragile")
+	require.True(t, ok)
+	require.Equal(t, "false", value)
+	require.Equal(t, map[string]string{"fragile": "false", "insurance": "1500"}, p.Metadata)
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "populated",
+			metadata: map[string]string{"fragile": "true", "insurance": "1500", "note": `"quoted" {braces}`},
+			want:     map[string]string{"fragile": "true", "insurance": "1500", "note": `"quoted" {braces}`},
+		},
+		{
+			name:     "empty",
+			metadata: map[string]string{},
+		},
+		{
+			name: "nil",
+		},
+	}
+
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+
+			for i, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					client := int64(1000 + i)
+					parcel := Parcel{Client: client, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt, Metadata: tt.metadata}
+					require.NoError(t, repo.Add(ctx, &parcel))
+
+					got, err := repo.Get(ctx, int(parcel.Number))
+					require.NoError(t, err)
+					require.Equal(t, tt.want, got.Metadata)
+
+					parcels, err := repo.GetByClient(ctx, int(client))
+					require.NoError(t, err)
+					require.Len(t, parcels, 1)
+					require.Equal(t, tt.want, parcels[0].Metadata)
+				})
+			}
+
+			t.Run("stored copy", func(t *testing.T) {
+				metadata := map[string]string{"fragile": "true"}
+				parcel := Parcel{Client: 2000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt, Metadata: metadata}
+				require.NoError(t, repo.Add(ctx, &parcel))
+
+				metadata["fragile"] = "false"
+
+				got, err := repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.Equal(t, map[string]string{"fragile": "true"}, got.Metadata)
+
+				// Changing the metadata of read parcels must not change
+				// the stored one either.
+				got.Metadata["fragile"] = "false"
+
+				parcels, err := repo.GetByClient(ctx, 2000)
+				require.NoError(t, err)
+				require.Len(t, parcels, 1)
+				parcels[0].SetMetadata("fragile", "false")
+
+				got, err = repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.Equal(t, map[string]string{"fragile": "true"}, got.Metadata)
+			})
+		})
+	}
+}
+
+func TestMetadataInvalidJSON(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	values := parcelValues(Parcel{Number: 101, Client: 1000, Status: ParcelStatusRegistered, Address: "test address"})
+	values[len(values)-1] = "{not json"
+	dbMock.ExpectQuery(regexp.QuoteMeta(selectParcelQuery(TableName{}))).
+		WithArgs(101).
+		WillReturnRows(parcelRows().AddRow(values...))
+
+	_, err = NewParcelStore(db, WithPreparedStatements(false)).Get(context.Background(), 101)
+	require.ErrorContains(t, err, "parcel 101: decode metadata")
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestParcelJSONMetadata(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantJSON string
+	}{
+		{
+			name:     "populated",
+			metadata: map[string]string{"fragile": "true", "insurance": "1500"},
+			wantJSON: `{"number":101,"client":102,"status":"registered","address":"test address","created_at":"2023-11-20T10:00:00Z",` +
+				`"metadata":{"fragile":"true","insurance":"1500"}}`,
+		},
+		{
+			name:     "nil",
+			wantJSON: `{"number":101,"client":102,"status":"registered","address":"test address","created_at":"2023-11-20T10:00:00Z"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			parcel := Parcel{
+				Number:    101,
+				Client:    102,
+				Status:    ParcelStatusRegistered,
+				Address:   "test address",
+				CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC),
+				Metadata:  tt.metadata,
+			}
+
+			data, err := json.Marshal(parcel)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.wantJSON, string(data))
+
+			var got Parcel
+			require.NoError(t, json.Unmarshal(data, &got))
+			require.Equal(t, parcel, got)
+		})
+	}
+}
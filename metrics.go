@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsStore is a ParcelRepository decorator that exports Prometheus
+// metrics for every operation of the wrapped repository.
+//
+// Two collectors are exported, both labeled by the method name:
+//   - parcel_store_operation_duration_seconds, a histogram of durations.
+//   - parcel_store_operation_errors_total, a counter of failed calls.
+type MetricsStore struct {
+	next     ParcelRepository
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+var _ ParcelRepository = MetricsStore{}
+
+// NewMetricsStore wraps next and registers its collectors with reg.
+//
+// Parameters:
+// - next: the repository to instrument.
+// - reg: the registerer the collectors are registered with.
+//
+// Returns:
+// - The instrumented repository.
+// - An error, if a collector cannot be registered.
+func NewMetricsStore(next ParcelRepository, reg prometheus.Registerer) (MetricsStore, error) {
+	store := MetricsStore{
+		next: next,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "parcel",
+			Subsystem: "store",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of parcel store operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "parcel",
+			Subsystem: "store",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed parcel store operations.",
+		}, []string{"method"}),
+	}
+
+	for _, collector := range []prometheus.Collector{store.duration, store.errors} {
+		if err := reg.Register(collector); err != nil {
+			return MetricsStore{}, err
+		}
+	}
+
+	return store, nil
+}
+
+// observe records the duration of the call to method started at start
+// and counts it as failed if *err is not nil. It is meant to be
+// deferred.
+func (s MetricsStore) observe(method string, start time.Time, err *error) {
+	s.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		s.errors.WithLabelValues(method).Inc()
+	}
+}
+
+// Add stores a new parcel.
+func (s MetricsStore) Add(ctx context.Context, p *Parcel) (err error) {
+	defer s.observe("Add", time.Now(), &err)
+
+	return s.next.Add(ctx, p)
+}
+
+// AddMany stores several new parcels.
+func (s MetricsStore) AddMany(ctx context.Context, parcels []*Parcel) (err error) {
+	defer s.observe("AddMany", time.Now(), &err)
+
+	return s.next.AddMany(ctx, parcels)
+}
+
+// AddWithinLimit stores several new parcels of one client if they fit
+// its active parcel limit.
+func (s MetricsStore) AddWithinLimit(ctx context.Context, parcels []*Parcel, limit int) (err error) {
+	defer s.observe("AddWithinLimit", time.Now(), &err)
+
+	return s.next.AddWithinLimit(ctx, parcels, limit)
+}
+
+// Get returns the parcel with the given number.
+func (s MetricsStore) Get(ctx context.Context, number int) (_ Parcel, err error) {
+	defer s.observe("Get", time.Now(), &err)
+
+	return s.next.Get(ctx, number)
+}
+
+// GetByExternalRef returns the parcel with the given external reference.
+func (s MetricsStore) GetByExternalRef(ctx context.Context, ref string) (_ Parcel, err error) {
+	defer s.observe("GetByExternalRef", time.Now(), &err)
+
+	return s.next.GetByExternalRef(ctx, ref)
+}
+
+// GetByClient returns all parcels of the given client.
+func (s MetricsStore) GetByClient(ctx context.Context, client int) (_ []Parcel, err error) {
+	defer s.observe("GetByClient", time.Now(), &err)
+
+	return s.next.GetByClient(ctx, client)
+}
+
+// GetByClientAndStatus returns the parcels of the given client in the
+// given status.
+func (s MetricsStore) GetByClientAndStatus(ctx context.Context, client int, status ParcelStatus) (_ []Parcel, err error) {
+	defer s.observe("GetByClientAndStatus", time.Now(), &err)
+
+	return s.next.GetByClientAndStatus(ctx, client, status)
+}
+
+// GetLatestByClient returns the most recently created parcel of the
+// given client.
+func (s MetricsStore) GetLatestByClient(ctx context.Context, client int) (_ Parcel, err error) {
+	defer s.observe("GetLatestByClient", time.Now(), &err)
+
+	return s.next.GetLatestByClient(ctx, client)
+}
+
+// CountByStatus returns the number of parcels of the given client in
+// each status.
+func (s MetricsStore) CountByStatus(ctx context.Context, client int) (_ map[ParcelStatus]int, err error) {
+	defer s.observe("CountByStatus", time.Now(), &err)
+
+	return s.next.CountByStatus(ctx, client)
+}
+
+// GetStatusHistory returns the status changes of the given parcel.
+func (s MetricsStore) GetStatusHistory(ctx context.Context, number int) (_ []StatusChange, err error) {
+	defer s.observe("GetStatusHistory", time.Now(), &err)
+
+	return s.next.GetStatusHistory(ctx, number)
+}
+
+// GetCreatedBetween returns the parcels in the given status created in
+// the interval [from, to).
+func (s MetricsStore) GetCreatedBetween(ctx context.Context, status ParcelStatus, from, to time.Time) (_ []Parcel, err error) {
+	defer s.observe("GetCreatedBetween", time.Now(), &err)
+
+	return s.next.GetCreatedBetween(ctx, status, from, to)
+}
+
+// SetStatus changes the status of the given parcel.
+func (s MetricsStore) SetStatus(ctx context.Context, number int, status ParcelStatus, version int) (err error) {
+	defer s.observe("SetStatus", time.Now(), &err)
+
+	return s.next.SetStatus(ctx, number, status, version)
+}
+
+// AdvanceStatus moves the given parcel to its next status.
+func (s MetricsStore) AdvanceStatus(ctx context.Context, number int) (_ StatusChange, _ bool, err error) {
+	defer s.observe("AdvanceStatus", time.Now(), &err)
+
+	return s.next.AdvanceStatus(ctx, number)
+}
+
+// SetAddress changes the address of the given parcel.
+func (s MetricsStore) SetAddress(ctx context.Context, number int, address string, version int) (err error) {
+	defer s.observe("SetAddress", time.Now(), &err)
+
+	return s.next.SetAddress(ctx, number, address, version)
+}
+
+// SetAddressWithReason changes the address of the given parcel and
+// records the reason.
+func (s MetricsStore) SetAddressWithReason(ctx context.Context, number int, address, reason string, version int) (err error) {
+	defer s.observe("SetAddressWithReason", time.Now(), &err)
+
+	return s.next.SetAddressWithReason(ctx, number, address, reason, version)
+}
+
+// GetAddressHistory returns the address changes of the given parcel.
+func (s MetricsStore) GetAddressHistory(ctx context.Context, number int) (_ []AddressChange, err error) {
+	defer s.observe("GetAddressHistory", time.Now(), &err)
+
+	return s.next.GetAddressHistory(ctx, number)
+}
+
+// Delete removes the given parcel if it is still registered.
+func (s MetricsStore) Delete(ctx context.Context, number int) (err error) {
+	defer s.observe("Delete", time.Now(), &err)
+
+	return s.next.Delete(ctx, number)
+}
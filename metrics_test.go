@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape gathers the metric family called name from reg and returns it
+// keyed by the value of its method label.
+func scrape(t *testing.T, reg prometheus.Gatherer, name string) map[string]*dto.Metric {
+	t.Helper()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	metrics := make(map[string]*dto.Metric)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" {
+					metrics[label.GetValue()] = metric
+				}
+			}
+		}
+	}
+
+	return metrics
+}
+
+func TestMetricsStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+
+	store, err := NewMetricsStore(NewMemoryStore(), reg)
+	require.NoError(t, err)
+
+	parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+	require.NoError(t, store.Add(ctx, &parcel))
+
+	_, err = store.Get(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	_, err = store.Get(ctx, int(parcel.Number))
+	require.NoError(t, err)
+
+	require.ErrorIs(t, store.SetStatus(ctx, 999, ParcelStatusSent, 1), ErrParcelNotFound)
+	require.ErrorIs(t, store.Delete(ctx, 999), ErrParcelNotFound)
+
+	durations := scrape(t, reg, "parcel_store_operation_duration_seconds")
+	require.Len(t, durations, 4)
+	require.Equal(t, uint64(1), durations["Add"].GetHistogram().GetSampleCount())
+	require.Equal(t, uint64(2), durations["Get"].GetHistogram().GetSampleCount())
+	require.Equal(t, uint64(1), durations["SetStatus"].GetHistogram().GetSampleCount())
+	require.Equal(t, uint64(1), durations["Delete"].GetHistogram().GetSampleCount())
+
+	errs := scrape(t, reg, "parcel_store_operation_errors_total")
+	require.Len(t, errs, 2)
+	require.Equal(t, float64(1), errs["SetStatus"].GetCounter().GetValue())
+	require.Equal(t, float64(1), errs["Delete"].GetCounter().GetValue())
+}
+
+func TestNewMetricsStoreDuplicate(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+
+	_, err := NewMetricsStore(NewMemoryStore(), reg)
+	require.NoError(t, err)
+
+	_, err = NewMetricsStore(NewMemoryStore(), reg)
+	require.Error(t, err)
+}
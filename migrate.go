@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// parcelColumnMigration is a column added to the parcel table after
+// its first release, which only had number, client, status, address
+// and created_at. mysql and postgres are the definitions used by ALTER
+// TABLE ... ADD COLUMN; they match the DDL in schema.go except that
+// updated_at is nullable, so it can be added to a table holding rows.
+type parcelColumnMigration struct {
+	name     string
+	mysql    string
+	postgres string
+}
+
+// parcelColumnMigrations lists the columns of the parcel table that
+// migrateParcelTable adds when they are missing, in table order.
+var parcelColumnMigrations = []parcelColumnMigration{
+	{name: "updated_at", mysql: "DATETIME(6)", postgres: "TIMESTAMPTZ"},
+	{name: "deleted_at", mysql: "DATETIME(6)", postgres: "TIMESTAMPTZ"},
+	{name: "registered_by", mysql: "VARCHAR(128) NOT NULL DEFAULT ''", postgres: "VARCHAR(128) NOT NULL DEFAULT ''"},
+	{name: "external_ref", mysql: "VARCHAR(128)", postgres: "VARCHAR(128)"},
+	{name: "version", mysql: "INTEGER NOT NULL DEFAULT 1", postgres: "INTEGER NOT NULL DEFAULT 1"},
+	{name: "weight_grams", mysql: "INTEGER NOT NULL DEFAULT 0", postgres: "INTEGER NOT NULL DEFAULT 0"},
+	{name: "length_mm", mysql: "INTEGER NOT NULL DEFAULT 0", postgres: "INTEGER NOT NULL DEFAULT 0"},
+	{name: "width_mm", mysql: "INTEGER NOT NULL DEFAULT 0", postgres: "INTEGER NOT NULL DEFAULT 0"},
+	{name: "height_mm", mysql: "INTEGER NOT NULL DEFAULT 0", postgres: "INTEGER NOT NULL DEFAULT 0"},
+	{name: "tracking_code", mysql: "VARCHAR(32)", postgres: "VARCHAR(32)"},
+	{name: "sent_at", mysql: "DATETIME(6)", postgres: "TIMESTAMPTZ"},
+	{name: "delivered_at", mysql: "DATETIME(6)", postgres: "TIMESTAMPTZ"},
+	{name: "priority", mysql: "INTEGER NOT NULL DEFAULT 0", postgres: "INTEGER NOT NULL DEFAULT 0"},
+	{name: "metadata", mysql: "TEXT", postgres: "TEXT"},
+}
+
+// sqliteCreatedAt converts created_at as stored by the first release,
+// an RFC 3339 string in UTC such as "2024-01-02T03:04:05Z", to the
+// format the SQLite driver writes time.Time values in, such as
+// "2024-01-02 03:04:05 +0000 UTC". Values already in that format are
+// left alone.
+const sqliteCreatedAt = "replace(replace(created_at, 'T', ' '), 'Z', ' +0000 UTC')"
+
+// migrateParcelTable brings a parcel table created by an earlier
+// version up to the columns of the current DDL, inside one
+// transaction. On Postgres and MySQL the missing columns are added
+// with ALTER TABLE, updated_at is backfilled from created_at and MySQL
+// also gets the unique keys on external_ref and tracking_code. SQLite
+// cannot add NOT NULL columns nor change column types, and the first
+// release declared created_at as text, so the table is rebuilt and
+// its rows copied instead. Parcels without a tracking code are given
+// one. A table that is up to date is left untouched.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the statements.
+// - db: the database holding the parcel table.
+// - dialect: the SQL flavour of db.
+// - table: the name of the parcel table.
+//
+// Returns:
+// - An error, if any occurs while inspecting or altering the table.
+func migrateParcelTable(ctx context.Context, db *sql.DB, dialect Dialect, table TableName) error {
+	columns, err := tableColumns(ctx, db, table.String())
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		existing[strings.ToLower(column)] = true
+	}
+
+	var missing []parcelColumnMigration
+	for _, column := range parcelColumnMigrations {
+		if !existing[column.name] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if dialect == DialectSQLite {
+		err = rebuildSQLiteParcelTable(ctx, tx, table, existing)
+	} else {
+		err = addParcelColumns(ctx, tx, dialect, table, missing)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !existing["tracking_code"] {
+		if err := backfillTrackingCodes(ctx, tx, dialect, table); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// tableColumns returns the names of the columns of table, read from
+// the result of a query matching no rows so it works in every dialect.
+func tableColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM "+table+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	return rows.Columns()
+}
+
+// addParcelColumns adds the missing columns to the parcel table on
+// Postgres or MySQL.
+func addParcelColumns(ctx context.Context, tx *sql.Tx, dialect Dialect, table TableName, missing []parcelColumnMigration) error {
+	for _, column := range missing {
+		definition := column.postgres
+		if dialect == DialectMySQL {
+			definition = column.mysql
+		}
+
+		if _, err := tx.ExecContext(ctx, "ALTER TABLE "+table.String()+" ADD COLUMN "+column.name+" "+definition); err != nil {
+			return fmt.Errorf("add column %s: %w", column.name, err)
+		}
+
+		switch {
+		case column.name == "updated_at":
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table.String()+" SET updated_at = created_at WHERE updated_at IS NULL"); err != nil {
+				return fmt.Errorf("backfill updated_at: %w", err)
+			}
+		case dialect == DialectMySQL && (column.name == "external_ref" || column.name == "tracking_code"):
+			if _, err := tx.ExecContext(ctx, "ALTER TABLE "+table.String()+" ADD UNIQUE KEY "+table.index(column.name)+" ("+column.name+")"); err != nil {
+				return fmt.Errorf("add unique key on %s: %w", column.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebuildSQLiteParcelTable recreates the parcel table with the current
+// DDL and copies the rows of the old one, which had the existing
+// columns. Missing columns get their defaults, except updated_at,
+// which is copied from created_at.
+func rebuildSQLiteParcelTable(ctx context.Context, tx *sql.Tx, table TableName, existing map[string]bool) error {
+	var target, source []string
+	for _, column := range strings.Split(parcelColumns, ", ") {
+		switch {
+		case column == "created_at":
+			target, source = append(target, column), append(source, sqliteCreatedAt)
+		case existing[column]:
+			target, source = append(target, column), append(source, column)
+		case column == "updated_at":
+			target, source = append(target, column), append(source, sqliteCreatedAt)
+		}
+	}
+
+	old := table.String() + "_migrating"
+	statements := []string{
+		"ALTER TABLE " + table.String() + " RENAME TO " + table.base() + "_migrating",
+		fmt.Sprintf(parcelTableDDL, table.String()),
+		"INSERT INTO " + table.String() + " (" + strings.Join(target, ", ") + ") SELECT " + strings.Join(source, ", ") + " FROM " + old,
+		"DROP TABLE " + old,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("rebuild %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+// backfillTrackingCodes sets the tracking code of every parcel that has
+// none, as parcels registered before tracking codes existed.
+func backfillTrackingCodes(ctx context.Context, tx *sql.Tx, dialect Dialect, table TableName) error {
+	rows, err := tx.QueryContext(ctx, "SELECT number FROM "+table.String()+" WHERE tracking_code IS NULL")
+	if err != nil {
+		return err
+	}
+
+	var numbers []int64
+	for rows.Next() {
+		var number int64
+		if err := rows.Scan(&number); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		numbers = append(numbers, number)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query := dialect.Rebind("UPDATE " + table.String() + " SET tracking_code = ? WHERE number = ?")
+	for _, number := range numbers {
+		if _, err := tx.ExecContext(ctx, query, TrackingCode(number), number); err != nil {
+			return fmt.Errorf("backfill tracking code of parcel %d: %w", number, err)
+		}
+	}
+
+	return nil
+}
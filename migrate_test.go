@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+// firstReleaseParcelTableDDL is the parcel table as created by the
+// first release, before any column was added.
+const firstReleaseParcelTableDDL = `CREATE TABLE "parcel" (
+	number     integer      constraint parcel_pk primary key autoincrement,
+	client     integer      not null,
+	status     VARCHAR(128) not null,
+	address    VARCHAR(512) not null,
+	created_at text         not null
+)`
+
+func TestCreateSchemaMigratesFirstRelease(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, firstReleaseParcelTableDDL)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO parcel (client, status, address, created_at) VALUES (7, 'sent', 'old address', '2024-01-02T03:04:05Z')")
+	require.NoError(t, err)
+
+	require.NoError(t, CreateSchema(ctx, db, DialectSQLite, TableName{}))
+	// The table is now up to date; a second run must not rebuild it.
+	require.NoError(t, CreateSchema(ctx, db, DialectSQLite, TableName{}))
+
+	store := NewParcelStore(db)
+	createdAt := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	old, err := store.Get(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), old.Client)
+	require.Equal(t, ParcelStatusSent, old.Status)
+	require.Equal(t, "old address", old.Address)
+	require.True(t, old.CreatedAt.Equal(createdAt), old.CreatedAt)
+	require.True(t, old.UpdatedAt.Equal(createdAt), old.UpdatedAt)
+	require.Equal(t, 1, old.Version)
+	require.Equal(t, TrackingCode(1), old.TrackingCode)
+
+	byCode, err := store.GetByTrackingCode(ctx, old.TrackingCode)
+	require.NoError(t, err)
+	require.Equal(t, old.Number, byCode.Number)
+
+	parcels, err := store.GetByDateRange(ctx, createdAt, createdAt.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+
+	parcel := Parcel{Client: 7, Status: ParcelStatusRegistered, Address: "new address", CreatedAt: time.Now().UTC()}
+	require.NoError(t, store.Add(ctx, &parcel))
+	require.Equal(t, int64(2), parcel.Number)
+}
+
+func TestMigrateParcelTableSQL(t *testing.T) {
+	t.Parallel()
+
+	firstRelease := []string{"number", "client", "status", "address", "created_at"}
+
+	tests := []struct {
+		name    string
+		dialect Dialect
+		mocks   func(dbMock sqlmock.Sqlmock)
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "up to date",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM parcel WHERE 1 = 0")).
+					WillReturnRows(sqlmock.NewRows(strings.Split(parcelColumns, ", ")))
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "postgres adds the missing columns",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM parcel WHERE 1 = 0")).
+					WillReturnRows(sqlmock.NewRows(firstRelease))
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD COLUMN updated_at TIMESTAMPTZ")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectExec(regexp.QuoteMeta("UPDATE parcel SET updated_at = created_at WHERE updated_at IS NULL")).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				for _, column := range parcelColumnMigrations[1:] {
+					dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD COLUMN " + column.name + " " + column.postgres)).
+						WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT number FROM parcel WHERE tracking_code IS NULL")).
+					WillReturnRows(sqlmock.NewRows([]string{"number"}).AddRow(1).AddRow(2))
+				for _, number := range []int64{1, 2} {
+					dbMock.ExpectExec(regexp.QuoteMeta("UPDATE parcel SET tracking_code = $1 WHERE number = $2")).
+						WithArgs(TrackingCode(number), number).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "mysql adds the unique keys",
+			dialect: DialectMySQL,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				columns := append(strings.Split(parcelColumns, ", ")[:14], "sent_at", "delivered_at")
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM parcel WHERE 1 = 0")).
+					WillReturnRows(sqlmock.NewRows(columns))
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD COLUMN tracking_code VARCHAR(32)")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD UNIQUE KEY parcel_tracking_code_idx (tracking_code)")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD COLUMN priority INTEGER NOT NULL DEFAULT 0")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD COLUMN metadata TEXT")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT number FROM parcel WHERE tracking_code IS NULL")).
+					WillReturnRows(sqlmock.NewRows([]string{"number"}))
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "alter error rolls back",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM parcel WHERE 1 = 0")).
+					WillReturnRows(sqlmock.NewRows(firstRelease))
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta("ALTER TABLE parcel ADD COLUMN updated_at TIMESTAMPTZ")).
+					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "add column updated_at: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			tt.wantErr(t, migrateParcelTable(context.Background(), db, tt.dialect, TableName{}))
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrNotificationRejected is returned by HTTPNotifier when the webhook
+// answers with a non-2xx status.
+var ErrNotificationRejected = errors.New("notification rejected")
+
+// previousStatusHeader carries the status a parcel moved from in the
+// requests sent by HTTPNotifier.
+const previousStatusHeader = "X-Parcel-Previous-Status"
+
+// Notifier tells downstream systems about parcel status changes.
+// ParcelService calls it from NextStatus once the new status is stored.
+type Notifier interface {
+	// StatusChanged is called after parcel moved from old to status.
+	// The parcel is read after the change, so its Status is status.
+	StatusChanged(ctx context.Context, parcel Parcel, old, status ParcelStatus) error
+}
+
+// NotifierFunc adapts an ordinary function to Notifier.
+type NotifierFunc func(ctx context.Context, parcel Parcel, old, status ParcelStatus) error
+
+// StatusChanged calls f(ctx, parcel, old, status).
+func (f NotifierFunc) StatusChanged(ctx context.Context, parcel Parcel, old, status ParcelStatus) error {
+	return f(ctx, parcel, old, status)
+}
+
+// HTTPNotifier is a Notifier that POSTs the parcel as JSON to a webhook.
+// The status the parcel moved from is sent in the
+// X-Parcel-Previous-Status header.
+type HTTPNotifier struct {
+	// url is the webhook the notifications are posted to.
+	url string
+	// client sends the requests.
+	client *http.Client
+}
+
+// NewHTTPNotifier creates a notifier posting to url with client. A nil
+// client uses http.DefaultClient.
+//
+// Parameters:
+// - url: the webhook receiving the notifications.
+// - client: the HTTP client sending them; set its Timeout to bound the wait.
+//
+// Returns:
+// - A new HTTPNotifier.
+func NewHTTPNotifier(url string, client *http.Client) HTTPNotifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return HTTPNotifier{url: url, client: client}
+}
+
+// StatusChanged posts the parcel JSON to the webhook.
+//
+// Returns:
+// - ErrNotificationRejected if the webhook answers with a non-2xx status.
+// - Any error encoding the parcel or sending the request.
+func (n HTTPNotifier) StatusChanged(ctx context.Context, parcel Parcel, old, _ ParcelStatus) error {
+	body, err := json.Marshal(parcel)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(previousStatusHeader, string(old))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s answered %s", ErrNotificationRejected, n.url, resp.Status)
+	}
+
+	return nil
+}
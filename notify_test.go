@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// statusChange is a call recorded by a fake Notifier.
+type statusChange struct {
+	parcel      Parcel
+	old, status ParcelStatus
+}
+
+func TestNextStatusNotifier(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		advances   int
+		notifyErr  error
+		wantCalls  []ParcelStatus
+		wantStatus ParcelStatus
+		wantLogged bool
+	}{
+		{
+			name:       "registered to sent",
+			advances:   1,
+			wantCalls:  []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent},
+			wantStatus: ParcelStatusSent,
+		},
+		{
+			name:       "sent to delivered",
+			advances:   2,
+			wantCalls:  []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusSent, ParcelStatusDelivered},
+			wantStatus: ParcelStatusDelivered,
+		},
+		{
+			name:       "already delivered",
+			advances:   3,
+			wantCalls:  []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusSent, ParcelStatusDelivered},
+			wantStatus: ParcelStatusDelivered,
+		},
+		{
+			name:       "failed notification keeps the change",
+			advances:   1,
+			notifyErr:  errors.New("webhook down"),
+			wantCalls:  []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent},
+			wantStatus: ParcelStatusSent,
+			wantLogged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := NewMemoryStore()
+			parcel := addParcel(t, store, 1000, createdAt)
+
+			var changes []statusChange
+			notifier := NotifierFunc(func(_ context.Context, parcel Parcel, old, status ParcelStatus) error {
+				changes = append(changes, statusChange{parcel: parcel, old: old, status: status})
+				return tt.notifyErr
+			})
+
+			handler := &recordingHandler{}
+			service := NewParcelService(store, WithOutput(io.Discard), WithLogger(slog.New(handler)), WithNotifier(notifier))
+
+			for range tt.advances {
+				require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+			}
+
+			var calls []ParcelStatus
+			for _, change := range changes {
+				require.Equal(t, parcel.Number, change.parcel.Number)
+				require.Equal(t, change.status, change.parcel.Status)
+				calls = append(calls, change.old, change.status)
+			}
+			require.Equal(t, tt.wantCalls, calls)
+
+			got, err := store.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, got.Status)
+
+			var logged bool
+			for _, record := range handler.records {
+				if record.Message == "parcel.notify_failed" {
+					logged = true
+					require.Equal(t, slog.LevelWarn, record.Level)
+					require.Equal(t, "webhook down", attrs(record)["error"])
+				}
+			}
+			require.Equal(t, tt.wantLogged, logged)
+		})
+	}
+}
+
+func TestNextStatusNotifierOutsideTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	parcel := addParcel(t, store, 1000, time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+
+	var hasDeadline bool
+	notifier := NotifierFunc(func(ctx context.Context, _ Parcel, _, _ ParcelStatus) error {
+		_, hasDeadline = ctx.Deadline()
+		return ctx.Err()
+	})
+
+	service := NewParcelService(store, WithOutput(io.Discard), WithOperationTimeout(time.Minute), WithNotifier(notifier))
+
+	require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+	require.False(t, hasDeadline)
+}
+
+func TestHTTPNotifier(t *testing.T) {
+	t.Parallel()
+
+	parcel := Parcel{
+		Number:    1,
+		Client:    1000,
+		Status:    ParcelStatusDelivered,
+		Address:   "test address",
+		CreatedAt: time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "accepted",
+			status:  http.StatusNoContent,
+			wantErr: require.NoError,
+		},
+		{
+			name:   "rejected",
+			status: http.StatusInternalServerError,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrNotificationRejected, i...)
+				require.ErrorContains(tt, err, "500", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				gotMethod, gotType, gotPrevious string
+				gotParcel                       Parcel
+			)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotMethod = r.Method
+				gotType = r.Header.Get("Content-Type")
+				gotPrevious = r.Header.Get(previousStatusHeader)
+				_ = json.NewDecoder(r.Body).Decode(&gotParcel)
+
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			notifier := NewHTTPNotifier(server.URL, server.Client())
+			err := notifier.StatusChanged(context.Background(), parcel, ParcelStatusSent, ParcelStatusDelivered)
+			tt.wantErr(t, err)
+
+			require.Equal(t, http.MethodPost, gotMethod)
+			require.Equal(t, "application/json", gotType)
+			require.Equal(t, string(ParcelStatusSent), gotPrevious)
+			require.Equal(t, parcel.Number, gotParcel.Number)
+			require.Equal(t, parcel.Status, gotParcel.Status)
+			require.True(t, parcel.CreatedAt.Equal(gotParcel.CreatedAt))
+		})
+	}
+}
+
+func TestHTTPNotifierUnreachable(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	err := NewHTTPNotifier(url, nil).StatusChanged(context.Background(), Parcel{Number: 1}, ParcelStatusRegistered, ParcelStatusSent)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotificationRejected)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// OutputFormat selects how ParcelService writes messages to its output.
+type OutputFormat string
+
+const (
+	// OutputFormatText writes human-readable messages. It is the default.
+	OutputFormatText OutputFormat = "text"
+	// OutputFormatJSON writes one JSON object per line and event.
+	OutputFormatJSON OutputFormat = "json"
+)
+
+// ErrInvalidOutputFormat is returned by ParseOutputFormat for unknown
+// formats.
+var ErrInvalidOutputFormat = fmt.Errorf("output format must be %q or %q", OutputFormatText, OutputFormatJSON)
+
+// ParseOutputFormat returns the output format named by s.
+//
+// Returns:
+// - The format, if s is "text" or "json".
+// - ErrInvalidOutputFormat otherwise.
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch format := OutputFormat(s); format {
+	case OutputFormatText, OutputFormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w, got %q", ErrInvalidOutputFormat, s)
+	}
+}
+
+// WithOutputFormat selects the format of the messages the service
+// writes to its output. Unknown formats keep the default,
+// OutputFormatText.
+func WithOutputFormat(format OutputFormat) ServiceOption {
+	return func(s *ParcelService) {
+		if _, err := ParseOutputFormat(string(format)); err == nil {
+			s.outputFormat = format
+		}
+	}
+}
+
+// outputEvent is a message written by the service in OutputFormatJSON.
+// Event names match the structured log events, e.g. "parcel.registered".
+type outputEvent struct {
+	// Event names what happened.
+	Event string `json:"event"`
+	// Number is the parcel the event is about, if any.
+	Number int64 `json:"number,omitempty"`
+	// Client is the client the event is about, if any.
+	Client int64 `json:"client,omitempty"`
+	// Status is the status the parcel moved to, if it changed.
+	Status ParcelStatus `json:"status,omitempty"`
+	// Parcel is the registered parcel.
+	Parcel *Parcel `json:"parcel,omitempty"`
+	// Parcels lists the parcels of Client; it is omitted if there are
+	// none.
+	Parcels []Parcel `json:"parcels,omitempty"`
+}
+
+// printEvent writes event to the service output: as a JSON line in
+// OutputFormatJSON, and as text otherwise.
+func (s ParcelService) printEvent(text string, event outputEvent) error {
+	if s.outputFormat == OutputFormatJSON {
+		return json.NewEncoder(s.out).Encode(event)
+	}
+
+	_, err := io.WriteString(s.out, text)
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    OutputFormat
+		wantErr require.ErrorAssertionFunc
+	}{
+		{name: "text", value: "text", want: OutputFormatText, wantErr: require.NoError},
+		{name: "json", value: "json", want: OutputFormatJSON, wantErr: require.NoError},
+		{
+			name:  "unknown",
+			value: "yaml",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidOutputFormat, i...)
+				require.ErrorContains(tt, err, `"yaml"`, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseOutputFormat(tt.value)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOutputFormatJSON(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	service := NewParcelService(NewMemoryStore(), WithOutput(&out), WithOutputFormat(OutputFormatJSON))
+
+	parcel, err := service.Register(ctx, 1000, "test address")
+	require.NoError(t, err)
+	require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+	require.NoError(t, service.RevertStatus(ctx, int(parcel.Number)))
+	require.NoError(t, service.PrintClientParcels(ctx, 1000))
+	require.NoError(t, runCommand(ctx, service, []string{"delete", "-number", "1"}))
+
+	var events []outputEvent
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		require.True(t, json.Valid(scanner.Bytes()), scanner.Text())
+
+		var event outputEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, events, 5)
+
+	require.Equal(t, "parcel.registered", events[0].Event)
+	require.Equal(t, parcel.Number, events[0].Number)
+	require.Equal(t, int64(1000), events[0].Client)
+	require.NotNil(t, events[0].Parcel)
+	require.Equal(t, "test address", events[0].Parcel.Address)
+
+	require.Equal(t, outputEvent{Event: "parcel.status_changed", Number: parcel.Number, Status: ParcelStatusSent}, events[1])
+	require.Equal(t, outputEvent{Event: "parcel.status_reverted", Number: parcel.Number, Status: ParcelStatusRegistered}, events[2])
+
+	require.Equal(t, "client.parcels", events[3].Event)
+	require.Equal(t, int64(1000), events[3].Client)
+	require.Len(t, events[3].Parcels, 1)
+	require.Equal(t, parcel.Number, events[3].Parcels[0].Number)
+
+	require.Equal(t, outputEvent{Event: "parcel.deleted", Number: parcel.Number}, events[4])
+}
+
+func TestOutputFormatDefault(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		format OutputFormat
+	}{
+		{name: "unset"},
+		{name: "text", format: OutputFormatText},
+		{name: "unknown", format: "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := []ServiceOption{}
+			if tt.format != "" {
+				opts = append(opts, WithOutputFormat(tt.format))
+			}
+
+			var out bytes.Buffer
+			service := NewParcelService(NewMemoryStore(), append(opts, WithOutput(&out))...)
+
+			_, err := service.Register(context.Background(), 1000, "test address")
+			require.NoError(t, err)
+			require.Contains(t, out.String(), "Новая посылка № 1 на адрес test address")
+			require.False(t, json.Valid(out.Bytes()))
+		})
+	}
+}
@@ -1,21 +1,98 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
 	"time"
-)
 
-const (
-	// ParcelStatusRegistered indicates that the parcel has been registered.
-	ParcelStatusRegistered = "registered"
-	// ParcelStatusSent indicates that the parcel has been sent.
-	ParcelStatusSent = "sent"
-	// ParcelStatusDelivered indicates that the parcel has been delivered.
-	ParcelStatusDelivered = "delivered"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrClientNotAllowed is returned by ParcelService.Register when the
+// client is not on the configured allowlist.
+var ErrClientNotAllowed = errors.New("client is not allowed to register parcels")
+
+// ErrInvalidClient is returned by ParcelService methods given a client
+// identifier that is not positive.
+var ErrInvalidClient = errors.New("invalid client")
+
+// ErrNilParcel is returned by Add when it is given a nil parcel.
+var ErrNilParcel = errors.New("parcel must not be nil")
+
+// ErrNilDestination is returned by ParcelStore.GetByClientInto when it
+// is given a nil slice pointer.
+var ErrNilDestination = errors.New("destination must not be nil")
+
+// ErrEmptyOperator is returned by ParcelStore.GetByOperator when no
+// operator is given.
+var ErrEmptyOperator = errors.New("operator must not be empty")
+
+// ErrInvalidReserveCount is returned by ParcelStore.ReserveNumbers when
+// the number of parcels to reserve is not positive.
+var ErrInvalidReserveCount = errors.New("number of parcels to reserve must be positive")
+
+// ErrClientParcelLimitExceeded is returned by ParcelService.Register
+// when the client already has the maximum number of active parcels.
+var ErrClientParcelLimitExceeded = errors.New("client has too many active parcels")
+
+// ErrParcelNumberConflict is returned by ParcelStore.InsertWithNumber
+// when the number was not reserved or is already taken by a parcel.
+var ErrParcelNumberConflict = errors.New("parcel number is not reserved or already in use")
+
+// ErrInvalidPage is returned when pagination parameters are out of range.
+var ErrInvalidPage = errors.New("invalid page")
+
+// ErrEmptySearchQuery is returned by ParcelStore.SearchByAddress for a
+// blank query.
+var ErrEmptySearchQuery = errors.New("search query must not be empty")
+
+// ErrInvalidDateRange is returned when the start of a date range is not
+// before its end.
+var ErrInvalidDateRange = errors.New("invalid date range")
+
+// ErrParcelNotFound is returned when an operation targets a parcel
+// that does not exist.
+var ErrParcelNotFound = errors.New("parcel not found")
+
+// ErrParcelNotDeletable is returned by ParcelService.Delete when the
+// parcel exists but has left the registered status.
+var ErrParcelNotDeletable = errors.New("only registered parcels can be deleted")
+
+// ErrParcelAlreadyDelivered is returned by ParcelService.ChangeAddress
+// for a parcel that has already been delivered.
+var ErrParcelAlreadyDelivered = errors.New("parcel is already delivered")
+
+// ErrStatusChanged is returned by ParcelStore.AdvanceStatus and
+// ParcelStore.ArchiveDelivered when a parcel's status was changed by
+// someone else during the transaction.
+var ErrStatusChanged = errors.New("parcel status changed concurrently")
+
+// ErrVersionConflict is returned by ParcelStore.SetStatus and
+// ParcelStore.SetAddress when the parcel was changed since the expected
+// version was read.
+var ErrVersionConflict = errors.New("parcel version conflict")
+
+// ErrInvalidDimensions is returned by ParcelStore.Add when a parcel's
+// weight or dimensions are negative.
+var ErrInvalidDimensions = errors.New("parcel weight and dimensions must not be negative")
+
+// ErrInvalidPriority is returned by ParcelStore.Add when a parcel's
+// priority is outside [MinParcelPriority, MaxParcelPriority].
+var ErrInvalidPriority = errors.New("invalid parcel priority")
+
+// ErrEmptyExternalRef is returned by ParcelService.RegisterIdempotent
+// when no external reference is given.
+var ErrEmptyExternalRef = errors.New("external reference must not be empty")
+
 // Parcel struct represents the information of a parcel.
 type Parcel struct {
 	// Number is a unique identifier for the parcel.
@@ -23,31 +100,528 @@ type Parcel struct {
 	// Client is the identifier of the client who ordered the parcel.
 	Client int64 `json:"client"`
 	// Status is the current status of the parcel.
-	Status string `json:"status"`
+	Status ParcelStatus `json:"status"`
 	// Address is the destination address of the parcel.
 	Address string `json:"address"`
 	// CreatedAt is the timestamp of when the parcel was created.
-	CreatedAt string `json:"created_at"`
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is the timestamp of the last change to the parcel.
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set when the parcel was soft-deleted with
+	// ParcelStore.SoftDelete. Soft-deleted parcels are hidden from reads.
+	DeletedAt sql.NullTime `json:"deleted_at"`
+	// RegisteredBy identifies the operator who registered the parcel.
+	// It is empty when the parcel was registered without one.
+	RegisteredBy string `json:"registered_by,omitempty"`
+	// ExternalRef is an optional reference assigned by the caller, such
+	// as an order ID. It is unique among parcels that have one, so
+	// repeated registrations with the same reference can be detected.
+	ExternalRef string `json:"external_ref,omitempty"`
+	// Version is incremented on every change to the parcel. Updates
+	// that expect a version fail with ErrVersionConflict when it has
+	// moved on, so concurrent edits cannot overwrite each other.
+	Version int `json:"version,omitempty"`
+	// WeightGrams is the weight of the parcel in grams.
+	WeightGrams int `json:"weight_grams,omitempty"`
+	// LengthMM, WidthMM and HeightMM are the dimensions of the parcel
+	// in millimetres. Zero means the dimension is unknown.
+	LengthMM int `json:"length_mm,omitempty"`
+	WidthMM  int `json:"width_mm,omitempty"`
+	HeightMM int `json:"height_mm,omitempty"`
+	// TrackingCode is the human-readable code of the parcel, derived
+	// from its number with TrackingCode when the parcel is added.
+	TrackingCode string `json:"tracking_code,omitempty"`
+	// SentAt is set when the parcel moves to ParcelStatusSent.
+	SentAt sql.NullTime `json:"sent_at"`
+	// DeliveredAt is set when the parcel moves to ParcelStatusDelivered.
+	DeliveredAt sql.NullTime `json:"delivered_at"`
+	// Priority ranks the parcel for handling, from MinParcelPriority to
+	// MaxParcelPriority; express shipments have a higher priority. It
+	// defaults to zero.
+	Priority int `json:"priority,omitempty"`
+	// Metadata holds custom key/value data of the client, such as a
+	// fragile flag, stored as a JSON object. Empty metadata is stored
+	// as NULL and read back as nil.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// The range of Parcel.Priority accepted when a parcel is added.
+const (
+	MinParcelPriority = 0
+	MaxParcelPriority = 9
+)
+
+// TimeInTransit returns how long the parcel took from being sent to
+// being delivered. The boolean is false until both timestamps are set.
+func (p Parcel) TimeInTransit() (time.Duration, bool) {
+	if !p.SentAt.Valid || !p.DeliveredAt.Valid {
+		return 0, false
+	}
+
+	return p.DeliveredAt.Time.Sub(p.SentAt.Time), true
+}
+
+// VolumeMM3 returns the volume of the parcel in cubic millimetres. It
+// is zero if any dimension is unknown.
+func (p Parcel) VolumeMM3() int64 {
+	return int64(p.LengthMM) * int64(p.WidthMM) * int64(p.HeightMM)
+}
+
+// validateDimensions returns ErrInvalidDimensions if the weight or any
+// dimension of p is negative.
+func (p Parcel) validateDimensions() error {
+	if p.WeightGrams < 0 || p.LengthMM < 0 || p.WidthMM < 0 || p.HeightMM < 0 {
+		return fmt.Errorf("%w: weight %dg, dimensions %dx%dx%dmm",
+			ErrInvalidDimensions, p.WeightGrams, p.LengthMM, p.WidthMM, p.HeightMM)
+	}
+
+	return nil
+}
+
+// validatePriority returns ErrInvalidPriority if the priority of p is
+// outside [MinParcelPriority, MaxParcelPriority].
+func (p Parcel) validatePriority() error {
+	if p.Priority < MinParcelPriority || p.Priority > MaxParcelPriority {
+		return fmt.Errorf("%w: %d is not within [%d, %d]", ErrInvalidPriority, p.Priority, MinParcelPriority, MaxParcelPriority)
+	}
+
+	return nil
+}
+
+// Validate checks that p can be stored: it must not be nil, and must
+// have a positive client, a non-blank address, a known status, and a
+// valid size and priority.
+//
+// Returns:
+//   - ErrNilParcel for a nil parcel.
+//   - Otherwise every failed check joined with errors.Join, such as
+//     ErrInvalidClient, ErrEmptyAddress or ErrInvalidStatus; nil if p is
+//     valid.
+func (p *Parcel) Validate() error {
+	if p == nil {
+		return ErrNilParcel
+	}
+
+	var errs []error
+
+	if p.Client <= 0 {
+		errs = append(errs, fmt.Errorf("%w: %d", ErrInvalidClient, p.Client))
+	}
+
+	if strings.TrimSpace(p.Address) == "" {
+		errs = append(errs, ErrEmptyAddress)
+	}
+
+	if !p.Status.IsValid() {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidStatus, p.Status))
+	}
+
+	errs = append(errs, p.validateDimensions(), p.validatePriority())
+
+	return errors.Join(errs...)
+}
+
+// parcelJSON is the wire representation of Parcel. Timestamps are
+// encoded as RFC 3339 strings with second precision.
+type parcelJSON struct {
+	Number       int64             `json:"number"`
+	Client       int64             `json:"client"`
+	Status       ParcelStatus      `json:"status"`
+	Address      string            `json:"address"`
+	CreatedAt    string            `json:"created_at"`
+	UpdatedAt    string            `json:"updated_at,omitempty"`
+	DeletedAt    string            `json:"deleted_at,omitempty"`
+	RegisteredBy string            `json:"registered_by,omitempty"`
+	ExternalRef  string            `json:"external_ref,omitempty"`
+	Version      int               `json:"version,omitempty"`
+	WeightGrams  int               `json:"weight_grams,omitempty"`
+	LengthMM     int               `json:"length_mm,omitempty"`
+	WidthMM      int               `json:"width_mm,omitempty"`
+	HeightMM     int               `json:"height_mm,omitempty"`
+	TrackingCode string            `json:"tracking_code,omitempty"`
+	SentAt       string            `json:"sent_at,omitempty"`
+	DeliveredAt  string            `json:"delivered_at,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// MarshalJSON encodes the parcel with its timestamps formatted as
+// RFC 3339. A zero UpdatedAt and unset DeletedAt, SentAt and
+// DeliveredAt are omitted.
+func (p Parcel) MarshalJSON() ([]byte, error) {
+	wire := parcelJSON{
+		Number:       p.Number,
+		Client:       p.Client,
+		Status:       p.Status,
+		Address:      p.Address,
+		CreatedAt:    p.CreatedAt.Format(time.RFC3339),
+		RegisteredBy: p.RegisteredBy,
+		ExternalRef:  p.ExternalRef,
+		Version:      p.Version,
+		WeightGrams:  p.WeightGrams,
+		LengthMM:     p.LengthMM,
+		WidthMM:      p.WidthMM,
+		HeightMM:     p.HeightMM,
+		TrackingCode: p.TrackingCode,
+		Priority:     p.Priority,
+		Metadata:     p.Metadata,
+	}
+
+	if !p.UpdatedAt.IsZero() {
+		wire.UpdatedAt = p.UpdatedAt.Format(time.RFC3339)
+	}
+
+	if p.DeletedAt.Valid {
+		wire.DeletedAt = p.DeletedAt.Time.Format(time.RFC3339)
+	}
+
+	if p.SentAt.Valid {
+		wire.SentAt = p.SentAt.Time.Format(time.RFC3339)
+	}
+
+	if p.DeliveredAt.Valid {
+		wire.DeliveredAt = p.DeliveredAt.Time.Format(time.RFC3339)
+	}
+
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes a parcel whose timestamps are RFC 3339 strings.
+func (p *Parcel) UnmarshalJSON(data []byte) error {
+	var wire parcelJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, wire.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	var updatedAt time.Time
+	if wire.UpdatedAt != "" {
+		updatedAt, err = time.Parse(time.RFC3339, wire.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	deletedAt, err := parseNullTime(wire.DeletedAt)
+	if err != nil {
+		return err
+	}
+
+	sentAt, err := parseNullTime(wire.SentAt)
+	if err != nil {
+		return err
+	}
+
+	deliveredAt, err := parseNullTime(wire.DeliveredAt)
+	if err != nil {
+		return err
+	}
+
+	*p = Parcel{
+		Number:       wire.Number,
+		Client:       wire.Client,
+		Status:       wire.Status,
+		Address:      wire.Address,
+		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+		DeletedAt:    deletedAt,
+		RegisteredBy: wire.RegisteredBy,
+		ExternalRef:  wire.ExternalRef,
+		Version:      wire.Version,
+		WeightGrams:  wire.WeightGrams,
+		LengthMM:     wire.LengthMM,
+		WidthMM:      wire.WidthMM,
+		HeightMM:     wire.HeightMM,
+		TrackingCode: wire.TrackingCode,
+		SentAt:       sentAt,
+		DeliveredAt:  deliveredAt,
+		Priority:     wire.Priority,
+		Metadata:     wire.Metadata,
+	}
+
+	return nil
+}
+
+// parseNullTime parses an optional RFC 3339 timestamp. The empty string
+// yields an unset sql.NullTime.
+func parseNullTime(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// ParcelRepository describes the storage operations ParcelService
+// relies on.
+//
+// ParcelStore is the database-backed implementation; tests and
+// alternative backends can provide their own.
+type ParcelRepository interface {
+	// Add stores a new parcel and assigns its number.
+	Add(ctx context.Context, p *Parcel) error
+	// AddMany stores several new parcels atomically and assigns their
+	// numbers.
+	AddMany(ctx context.Context, parcels []*Parcel) error
+	// AddWithinLimit stores new parcels of one client like AddMany if
+	// the client then has at most limit parcels that are not
+	// delivered, and returns ErrClientParcelLimitExceeded otherwise.
+	// The check and the insert are atomic.
+	AddWithinLimit(ctx context.Context, parcels []*Parcel, limit int) error
+	// Get returns the parcel with the given number, or
+	// ErrParcelNotFound if there is none.
+	Get(ctx context.Context, number int) (Parcel, error)
+	// GetByExternalRef returns the parcel with the given external
+	// reference, or ErrParcelNotFound if there is none.
+	GetByExternalRef(ctx context.Context, ref string) (Parcel, error)
+	// GetByClient returns all parcels of the given client, ordered by
+	// number.
+	GetByClient(ctx context.Context, client int) ([]Parcel, error)
+	// GetByClientAndStatus returns the parcels of the given client that
+	// are in the given status.
+	GetByClientAndStatus(ctx context.Context, client int, status ParcelStatus) ([]Parcel, error)
+	// GetLatestByClient returns the most recently created parcel of the
+	// given client, or ErrParcelNotFound if it has none.
+	GetLatestByClient(ctx context.Context, client int) (Parcel, error)
+	// CountByStatus returns the number of parcels of the given client
+	// in each known status, including zero counts.
+	CountByStatus(ctx context.Context, client int) (map[ParcelStatus]int, error)
+	// GetStatusHistory returns the status changes of the given parcel,
+	// oldest first.
+	GetStatusHistory(ctx context.Context, number int) ([]StatusChange, error)
+	// GetCreatedBetween returns the parcels in the given status created
+	// in the interval [from, to).
+	GetCreatedBetween(ctx context.Context, status ParcelStatus, from, to time.Time) ([]Parcel, error)
+	// SetStatus changes the status of the given parcel if it is still
+	// at the expected version.
+	SetStatus(ctx context.Context, number int, status ParcelStatus, version int) error
+	// AdvanceStatus atomically moves the given parcel to the next
+	// status of its lifecycle and reports the change and whether it
+	// moved. It returns ErrParcelNotFound for a missing parcel and
+	// ErrInvalidStatus for a parcel whose status is not part of the
+	// lifecycle.
+	AdvanceStatus(ctx context.Context, number int) (StatusChange, bool, error)
+	// SetAddress changes the address of the given parcel if it is still
+	// at the expected version.
+	SetAddress(ctx context.Context, number int, address string, version int) error
+	// SetAddressWithReason changes the address of the given parcel like
+	// SetAddress and records the change with its reason in the address
+	// history.
+	SetAddressWithReason(ctx context.Context, number int, address, reason string, version int) error
+	// GetAddressHistory returns the address changes of the given parcel
+	// recorded by SetAddressWithReason, oldest first.
+	GetAddressHistory(ctx context.Context, number int) ([]AddressChange, error)
+	// Delete removes the given parcel if it is still registered.
+	Delete(ctx context.Context, number int) error
 }
 
 // ParcelService provides operations for managing parcels.
 //
-// The ParcelService struct holds a reference to a ParcelStore,
+// The ParcelService struct holds a reference to a ParcelRepository,
 // which is responsible for persisting and retrieving parcel data.
 type ParcelService struct {
 	// store is the interface for the underlying data storage
 	// of parcels. It provides methods to create, read, update,
 	// and delete parcel records.
-	store ParcelStore
+	store ParcelRepository
+	// allowedClients is the set of clients permitted to register
+	// parcels. An empty set disables the check.
+	allowedClients map[int64]struct{}
+	// maxActiveParcelsPerClient caps the number of parcels a client may
+	// have that are not delivered yet. Zero means unlimited.
+	maxActiveParcelsPerClient int
+	// normalizeAddress rewrites addresses passed to Register and
+	// ChangeAddress before they are validated and stored.
+	normalizeAddress func(string) string
+	// addressValidator checks addresses passed to Register and
+	// ChangeAddress.
+	addressValidator AddressValidator
+	// maxAddressLen caps the number of characters of addresses passed
+	// to Register and ChangeAddress. Zero means unlimited.
+	maxAddressLen int
+	// out receives the messages the service prints about registered
+	// parcels, client listings and status changes.
+	out io.Writer
+	// outputFormat selects how messages are written to out.
+	outputFormat OutputFormat
+	// logger receives structured events about parcel changes.
+	logger *slog.Logger
+	// notifier is told about status changes made by NextStatus. It is
+	// nil if nobody is notified.
+	notifier Notifier
+	// operationTimeout bounds every operation whose context has no
+	// deadline. Zero disables it.
+	operationTimeout time.Duration
+	// clock provides the time stamped on registered parcels.
+	clock Clock
+	// deliveryPolicy estimates delivery times reported by Track.
+	deliveryPolicy DeliveryPolicy
+}
+
+// ServiceOption configures optional behaviour of a ParcelService.
+type ServiceOption func(*ParcelService)
+
+// WithAllowedClients restricts parcel registration to the given clients.
+//
+// Register returns ErrClientNotAllowed for any client that is not in
+// the list. Passing no clients leaves registration open to everyone.
+func WithAllowedClients(clients ...int64) ServiceOption {
+	return func(s *ParcelService) {
+		if len(clients) == 0 {
+			s.allowedClients = nil
+			return
+		}
+
+		s.allowedClients = make(map[int64]struct{}, len(clients))
+		for _, client := range clients {
+			s.allowedClients[client] = struct{}{}
+		}
+	}
+}
+
+// WithMaxActiveParcelsPerClient limits how many parcels that are not
+// delivered yet a client may have. Register returns
+// ErrClientParcelLimitExceeded once the client is at the limit. Zero,
+// the default, means unlimited.
+func WithMaxActiveParcelsPerClient(limit int) ServiceOption {
+	return func(s *ParcelService) {
+		s.maxActiveParcelsPerClient = limit
+	}
+}
+
+// WithAddressNormalizer replaces the default address normalization,
+// which trims the address and collapses runs of whitespace, so callers
+// can apply their own rules, such as title-casing the city. A nil
+// normalizer stores addresses exactly as given.
+func WithAddressNormalizer(normalize func(string) string) ServiceOption {
+	return func(s *ParcelService) {
+		if normalize == nil {
+			normalize = func(address string) string { return address }
+		}
+
+		s.normalizeAddress = normalize
+	}
+}
+
+// WithAddressValidator replaces the default address check, which only
+// rejects blank addresses. A nil validator keeps the default.
+func WithAddressValidator(validator AddressValidator) ServiceOption {
+	return func(s *ParcelService) {
+		if validator != nil {
+			s.addressValidator = validator
+		}
+	}
+}
+
+// WithMaxAddressLen limits addresses passed to Register and
+// ChangeAddress to limit characters after normalization; longer ones
+// are rejected with ErrAddressTooLong. The default is
+// DefaultMaxAddressLen. Zero or a negative limit disables the check.
+func WithMaxAddressLen(limit int) ServiceOption {
+	return func(s *ParcelService) {
+		s.maxAddressLen = max(limit, 0)
+	}
+}
+
+// WithOutput sends the messages printed by the service to w instead of
+// os.Stdout. A nil writer keeps the default.
+func WithOutput(w io.Writer) ServiceOption {
+	return func(s *ParcelService) {
+		if w != nil {
+			s.out = w
+		}
+	}
+}
+
+// WithLogger sends structured events about parcel changes, such as
+// "parcel.registered" and "parcel.status_changed", to logger. Without
+// it, or with a nil logger, events are discarded.
+func WithLogger(logger *slog.Logger) ServiceOption {
+	return func(s *ParcelService) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// WithNotifier makes NextStatus tell notifier about every status change
+// it stores. A nil notifier disables notifications.
+func WithNotifier(notifier Notifier) ServiceOption {
+	return func(s *ParcelService) {
+		s.notifier = notifier
+	}
+}
+
+// WithOperationTimeout bounds every service operation called with a
+// context that has no deadline, so a caller that forgets to set one
+// cannot wait on the store forever. Contexts that already carry a
+// deadline are left alone. A zero or negative timeout disables it.
+func WithOperationTimeout(timeout time.Duration) ServiceOption {
+	return func(s *ParcelService) {
+		s.operationTimeout = max(timeout, 0)
+	}
+}
+
+// withTimeout returns ctx bounded by the operation timeout if ctx has
+// no deadline of its own. The returned cancel function must be called
+// when the operation ends.
+func (s ParcelService) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.operationTimeout == 0 {
+		return ctx, func() {}
+	}
+
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.operationTimeout)
 }
 
 // NewParcelService creates a new instance of ParcelService.
 //
-// It takes a ParcelStore as a parameter, which is used to
+// It takes a ParcelRepository as a parameter, which is used to
 // interface with the underlying data storage for parcel records.
-// The function returns a ParcelService populated with the provided store.
-func NewParcelService(store ParcelStore) ParcelService {
-	return ParcelService{store: store}
+// Optional behaviour such as a client allowlist is configured
+// through opts. The function returns a ParcelService populated
+// with the provided store.
+func NewParcelService(store ParcelRepository, opts ...ServiceOption) ParcelService {
+	service := ParcelService{
+		store:            store,
+		normalizeAddress: collapseWhitespace,
+		addressValidator: nonEmptyAddress{},
+		maxAddressLen:    DefaultMaxAddressLen,
+		out:              os.Stdout,
+		outputFormat:     OutputFormatText,
+		logger:           slog.New(discardHandler{}),
+		clock:            realClock{},
+		deliveryPolicy:   DefaultDeliveryPolicy,
+	}
+	for _, opt := range opts {
+		opt(&service)
+	}
+
+	return service
+}
+
+// IsClientAllowed reports whether the client may register parcels.
+//
+// Every client is allowed when no allowlist has been configured.
+func (s ParcelService) IsClientAllowed(client int64) bool {
+	if len(s.allowedClients) == 0 {
+		return true
+	}
+
+	_, ok := s.allowedClients[client]
+	return ok
 }
 
 // Register registers a new parcel with the given client ID and address.
@@ -57,292 +631,2304 @@ func NewParcelService(store ParcelStore) ParcelService {
 // current time as the creation timestamp. The parcel is then
 // added to the store, and its unique identifier is retrieved.
 //
-// If the addition to the store fails, an error is returned along
-// with the partially created Parcel. If successful, the created
-// Parcel, now with its assigned number, is returned along with
-// a confirmation message logged to the standard output.
+// If an allowlist is configured and the client is not on it,
+// ErrClientNotAllowed is returned and nothing is stored. The same
+// applies when the address is rejected by the service's
+// AddressValidator, in which case its error is returned, and when the
+// client is at the limit set with WithMaxActiveParcelsPerClient, in
+// which case ErrClientParcelLimitExceeded is returned. The address is
+// normalized (see WithAddressNormalizer) before it is validated.
+//
+// If the addition to the store fails, an error is returned along
+// with the partially created Parcel. If successful, the created
+// Parcel, now with its assigned number, is returned along with
+// a confirmation message logged to the standard output.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store call.
+//   - client: An integer representing the client ID associated
+//     with the parcel.
+//   - address: A string containing the destination address of
+//     the parcel.
+//
+// Returns:
+//   - The created Parcel, which includes the assigned number and
+//     other details.
+//   - An error, if any occurred during the registration process.
+func (s ParcelService) Register(ctx context.Context, client int64, address string) (Parcel, error) {
+	return s.RegisterBy(ctx, client, address, "")
+}
+
+// RegisterBy registers a new parcel like Register and records the
+// operator who registered it, so support can later audit registrations
+// with ParcelStore.GetByOperator.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store call.
+//   - client: An integer representing the client ID associated
+//     with the parcel.
+//   - address: A string containing the destination address of
+//     the parcel.
+//   - operator: The identifier of the operator; may be empty.
+//
+// Returns:
+//   - The created Parcel, which includes the assigned number and
+//     other details.
+//   - An error, if any occurred during the registration process.
+func (s ParcelService) RegisterBy(ctx context.Context, client int64, address, operator string) (Parcel, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	parcel := Parcel{
+		Client:       client,
+		Status:       ParcelStatusRegistered,
+		Address:      address,
+		CreatedAt:    s.clock.Now().UTC(),
+		RegisteredBy: operator,
+	}
+
+	if err := s.register(ctx, &parcel); err != nil {
+		return Parcel{}, err
+	}
+
+	return parcel, nil
+}
+
+// RegisterIdempotent registers a new parcel like Register, identified
+// by an external reference chosen by the caller.
+//
+// If a parcel with the same reference already exists, it is returned
+// unchanged and no new parcel is created, so a registration request
+// that is sent again does not create a duplicate. This also holds when
+// two registrations with the same reference race: the unique index on
+// the reference rejects the second insert and the winner is returned.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store calls.
+//   - client: An integer representing the client ID associated
+//     with the parcel.
+//   - address: A string containing the destination address of
+//     the parcel.
+//   - ref: The external reference of the parcel; must not be empty.
+//
+// Returns:
+//   - The created Parcel, or the existing one with the same reference.
+//   - ErrEmptyExternalRef if ref is empty, or any error of the
+//     registration process.
+func (s ParcelService) RegisterIdempotent(ctx context.Context, client int64, address, ref string) (Parcel, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if ref == "" {
+		return Parcel{}, ErrEmptyExternalRef
+	}
+
+	existing, err := s.store.GetByExternalRef(ctx, ref)
+	if err == nil {
+		return existing, nil
+	}
+
+	if !errors.Is(err, ErrParcelNotFound) {
+		return Parcel{}, err
+	}
+
+	parcel := Parcel{
+		Client:      client,
+		Status:      ParcelStatusRegistered,
+		Address:     address,
+		CreatedAt:   s.clock.Now().UTC(),
+		ExternalRef: ref,
+	}
+
+	if err = s.register(ctx, &parcel); err != nil {
+		winner, getErr := s.store.GetByExternalRef(ctx, ref)
+		if getErr == nil {
+			return winner, nil
+		}
+
+		return Parcel{}, err
+	}
+
+	return parcel, nil
+}
+
+// register checks that parcel may be registered, stores it and reports
+// the registration.
+func (s ParcelService) register(ctx context.Context, parcel *Parcel) error {
+	if parcel.Client <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidClient, parcel.Client)
+	}
+
+	if !s.IsClientAllowed(parcel.Client) {
+		return ErrClientNotAllowed
+	}
+
+	parcel.Address = s.normalizeAddress(parcel.Address)
+
+	if err := s.checkAddress(parcel.Address); err != nil {
+		return err
+	}
+
+	var err error
+	if s.maxActiveParcelsPerClient > 0 {
+		err = s.store.AddWithinLimit(ctx, []*Parcel{parcel}, s.maxActiveParcelsPerClient)
+	} else {
+		err = s.store.Add(ctx, parcel)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.reportRegistered(ctx, parcel)
+
+	return nil
+}
+
+// RegisterBatch registers a parcel for each of the addresses, for bulk
+// imports.
+//
+// Every address is normalized and validated like in Register before
+// anything is stored, and the batch fails on the first invalid one.
+// The parcels are then stored in a single transaction, so either all
+// of them are registered or none. The active parcel limit (see
+// WithMaxActiveParcelsPerClient) counts the whole batch and is checked
+// in the same transaction.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store calls.
+//   - client: The client ID associated with the parcels.
+//   - addresses: The destination addresses, one per parcel.
+//
+// Returns:
+//   - The created parcels with their numbers, in the order of addresses.
+//   - ErrInvalidClient or ErrClientNotAllowed if the client may not
+//     register parcels.
+//   - ErrAddressTooLong or the validator's error, such as
+//     ErrEmptyAddress, wrapped with the index of the first invalid
+//     address.
+//   - An error, if any occurred while storing the parcels.
+func (s ParcelService) RegisterBatch(ctx context.Context, client int64, addresses []string) ([]Parcel, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if client <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidClient, client)
+	}
+
+	if !s.IsClientAllowed(client) {
+		return nil, ErrClientNotAllowed
+	}
+
+	normalized := make([]string, len(addresses))
+	for i, address := range addresses {
+		normalized[i] = s.normalizeAddress(address)
+
+		if err := s.checkAddress(normalized[i]); err != nil {
+			return nil, fmt.Errorf("address %d: %w", i, err)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return []Parcel{}, nil
+	}
+
+	createdAt := s.clock.Now().UTC()
+
+	batch := make([]*Parcel, len(normalized))
+	for i, address := range normalized {
+		batch[i] = &Parcel{
+			Client:    client,
+			Status:    ParcelStatusRegistered,
+			Address:   address,
+			CreatedAt: createdAt,
+		}
+	}
+
+	var err error
+	if s.maxActiveParcelsPerClient > 0 {
+		err = s.store.AddWithinLimit(ctx, batch, s.maxActiveParcelsPerClient)
+	} else {
+		err = s.store.AddMany(ctx, batch)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	parcels := make([]Parcel, len(batch))
+	for i, parcel := range batch {
+		s.reportRegistered(ctx, parcel)
+		parcels[i] = *parcel
+	}
+
+	return parcels, nil
+}
+
+// reportRegistered prints and logs the registration of parcel.
+func (s ParcelService) reportRegistered(ctx context.Context, parcel *Parcel) {
+	_ = s.printEvent(fmt.Sprintf("Новая посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s\n",
+		parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt.Format(time.RFC3339)),
+		outputEvent{Event: "parcel.registered", Number: parcel.Number, Client: parcel.Client, Parcel: parcel})
+
+	s.logger.InfoContext(ctx, "parcel.registered",
+		slog.Int64("number", parcel.Number),
+		slog.Int64("client", parcel.Client),
+		slog.String("status", string(parcel.Status)),
+		slog.String("registered_by", parcel.RegisteredBy))
+}
+
+// Get returns the parcel with the given number.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store call.
+// - number: An integer representing the unique identifier of the parcel.
+//
+// Returns:
+// - The parcel.
+// - ErrParcelNotFound if the parcel does not exist, or any store error.
+func (s ParcelService) Get(ctx context.Context, number int) (Parcel, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.store.Get(ctx, number)
+}
+
+// ClientParcels returns the parcels associated with a given client,
+// ordered by number.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store calls.
+//   - client: An integer representing the client's unique identifier.
+//   - statuses: The statuses of the parcels to return; all parcels are
+//     returned if none are given.
+//
+// Returns:
+//   - The client's parcels; empty if the client has none.
+//   - ErrInvalidStatus for an unknown status, or an error, if any
+//     occurred during the retrieval process.
+func (s ParcelService) ClientParcels(ctx context.Context, client int, statuses ...ParcelStatus) ([]Parcel, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if client <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidClient, client)
+	}
+
+	if len(statuses) == 0 {
+		return s.store.GetByClient(ctx, client)
+	}
+
+	var (
+		parcels []Parcel
+		seen    = make(map[ParcelStatus]bool, len(statuses))
+	)
+
+	for _, status := range statuses {
+		if seen[status] {
+			continue
+		}
+		seen[status] = true
+
+		matching, err := s.store.GetByClientAndStatus(ctx, client, status)
+		if err != nil {
+			return nil, err
+		}
+
+		parcels = append(parcels, matching...)
+	}
+
+	sort.Slice(parcels, func(i, j int) bool {
+		return parcels[i].Number < parcels[j].Number
+	})
+
+	return parcels, nil
+}
+
+// FormatClientParcels builds the listing of the parcels associated
+// with a given client.
+//
+// The listing starts with a header line naming the client, followed by
+// one line per parcel with its number, address, client ID, registration
+// date, and status, ordered by number.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store calls.
+//   - client: An integer representing the client's unique identifier.
+//   - statuses: The statuses of the parcels to list, as in
+//     ClientParcels; all parcels are listed if none are given.
+//
+// Returns:
+// - The formatted listing.
+// - An error, if any occurred during the retrieval process.
+func (s ParcelService) FormatClientParcels(ctx context.Context, client int, statuses ...ParcelStatus) (string, error) {
+	parcels, err := s.ClientParcels(ctx, client, statuses...)
+	if err != nil {
+		return "", err
+	}
+
+	return formatParcels(client, parcels), nil
+}
+
+// formatParcels builds the listing of FormatClientParcels from the
+// client's parcels.
+func formatParcels(client int, parcels []Parcel) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Посылки клиента %d:\n", client)
+	for _, parcel := range parcels {
+		fmt.Fprintf(&b, "Посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s, статус %s\n",
+			parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt.Format(time.RFC3339), parcel.Status)
+	}
+
+	return b.String()
+}
+
+// PrintClientParcels prints the details of the parcels associated with
+// a given client, optionally only those in the given statuses, such as
+// the parcels in transit.
+//
+// The listing is built like FormatClientParcels and written to the
+// service output (see WithOutput). In OutputFormatJSON a single
+// "client.parcels" event holding the parcels is written instead.
+// Nothing is written if the parcels cannot be retrieved.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store calls.
+//   - client: An integer representing the client's unique identifier.
+//   - statuses: The statuses of the parcels to print, as in
+//     ClientParcels; all parcels are printed if none are given.
+//
+// Returns:
+//   - An error, if any occurred during the retrieval process or while
+//     writing; otherwise, it returns nil.
+func (s ParcelService) PrintClientParcels(ctx context.Context, client int, statuses ...ParcelStatus) error {
+	parcels, err := s.ClientParcels(ctx, client, statuses...)
+	if err != nil {
+		return err
+	}
+
+	return s.printEvent(formatParcels(client, parcels), outputEvent{
+		Event:   "client.parcels",
+		Client:  int64(client),
+		Parcels: parcels,
+	})
+}
+
+// NextStatus updates the status of a parcel to its next logical state.
+//
+// The read of the current status and the write of the next one happen
+// atomically in the store's AdvanceStatus method, so concurrent calls
+// cannot advance a parcel twice. The next status is taken from the
+// parcel state machine (see ParcelStatus.Next): from registered to
+// sent, and from sent to delivered. If the parcel is already delivered,
+// it simply returns nil without making any updates.
+//
+// If the status is successfully updated, it prints the parcel number
+// and its new status, and tells the notifier set with WithNotifier
+// which status the parcel moved from and to. A failed notification is
+// logged as "parcel.notify_failed" and does not undo the change.
+//
+// The operation timeout (see WithOperationTimeout) covers the store
+// calls only. The notifier runs afterwards with ctx and is bounded by
+// its own timeout, such as that of the HTTP client of an HTTPNotifier,
+// so NextStatus can take that much longer.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store calls.
+// - number: An integer representing the unique identifier of the parcel.
+//
+// Returns:
+//   - ErrParcelNotFound if the parcel does not exist.
+//   - ErrInvalidStatus if the parcel's stored status is empty or unknown.
+//   - An error, if any occurred during retrieval or status update;
+//     otherwise, it returns nil.
+func (s ParcelService) NextStatus(ctx context.Context, number int) error {
+	change, advanced, err := s.advanceStatus(ctx, number)
+	if err != nil || !advanced {
+		return err
+	}
+
+	s.notifyStatusChanged(ctx, change)
+
+	return nil
+}
+
+// advanceStatus advances the parcel within the operation timeout and
+// reports the change like the store's AdvanceStatus. A stored change
+// is printed and logged.
+func (s ParcelService) advanceStatus(ctx context.Context, number int) (StatusChange, bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	change, advanced, err := s.store.AdvanceStatus(ctx, number)
+	if err != nil || !advanced {
+		return change, advanced, err
+	}
+
+	_ = s.printEvent(fmt.Sprintf("У посылки № %d новый статус: %s\n", number, change.NewStatus),
+		outputEvent{Event: "parcel.status_changed", Number: int64(number), Status: change.NewStatus})
+
+	s.logger.InfoContext(ctx, "parcel.status_changed",
+		slog.Int("number", number),
+		slog.String("status", string(change.NewStatus)))
+
+	return change, true, nil
+}
+
+// notifyStatusChanged tells the notifier, if any, about change. The
+// parcel passed to it is read after the change, within the operation
+// timeout. Failures are only logged.
+func (s ParcelService) notifyStatusChanged(ctx context.Context, change StatusChange) {
+	if s.notifier == nil {
+		return
+	}
+
+	getCtx, cancel := s.withTimeout(ctx)
+	parcel, err := s.store.Get(getCtx, int(change.Number))
+	cancel()
+
+	if err == nil {
+		err = s.notifier.StatusChanged(ctx, parcel, change.OldStatus, change.NewStatus)
+	}
+
+	if err != nil {
+		s.logger.WarnContext(ctx, "parcel.notify_failed",
+			slog.Int64("number", change.Number),
+			slog.String("status", string(change.NewStatus)),
+			slog.String("error", err.Error()))
+	}
+}
+
+// RevertStatus moves a parcel back to the previous status of its
+// lifecycle, undoing an accidental NextStatus: from delivered to sent,
+// and from sent to registered.
+//
+// The previous status is taken from the parcel state machine (see
+// ParcelStatus.Previous) and written with the store's SetStatus, so the
+// change is recorded in the status history and fails with
+// ErrVersionConflict if the parcel changed after it was read. If the
+// status is successfully reverted, it prints the parcel number and its
+// new status.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store calls.
+// - number: An integer representing the unique identifier of the parcel.
+//
+// Returns:
+// - ErrParcelNotFound if the parcel does not exist.
+// - ErrNoPreviousStatus if the parcel is still registered.
+// - An error, if any occurred during retrieval or status update.
+func (s ParcelService) RevertStatus(ctx context.Context, number int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	parcel, err := s.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	previous, ok := parcel.Status.Previous()
+	if !ok {
+		return fmt.Errorf("%w: parcel %d is %s", ErrNoPreviousStatus, number, parcel.Status)
+	}
+
+	if err = s.store.SetStatus(ctx, number, previous, parcel.Version); err != nil {
+		return err
+	}
+
+	_ = s.printEvent(fmt.Sprintf("У посылки № %d новый статус: %s\n", number, previous),
+		outputEvent{Event: "parcel.status_reverted", Number: int64(number), Status: previous})
+
+	s.logger.InfoContext(ctx, "parcel.status_reverted",
+		slog.Int("number", number),
+		slog.String("status", string(previous)))
+
+	return nil
+}
+
+// Manifest returns the shipping manifest for the given day: the parcels
+// a carrier should pick up on that date.
+//
+// A parcel is ready to ship on a date if it is still registered and was
+// created on that date in loc. Parcels that were already sent are left
+// out: they were handed off before, on the day recorded in their SentAt
+// and status history. Parcels are listed in number order for handoff.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store call.
+// - date: Any moment of the day the manifest is built for.
+// - loc: The location whose calendar defines the day; nil means UTC.
+//
+// Returns:
+// - The parcels to dispatch on that day, ordered by number.
+// - An error, if any occurred while reading the parcels.
+func (s ParcelService) Manifest(ctx context.Context, date time.Time, loc *time.Location) ([]Parcel, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	date = date.In(loc)
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1)
+
+	return s.store.GetCreatedBetween(ctx, ParcelStatusRegistered, from, to)
+}
+
+// ChangeAddress updates the delivery address of a parcel.
+//
+// This method changes the address of the parcel identified by its
+// unique number. The address is normalized (see WithAddressNormalizer)
+// and checked with the service's AddressValidator first. The parcel
+// is then fetched, and the change is refused if it has already been
+// delivered; registered and sent parcels can still be rerouted.
+// Finally, the store's SetAddress method is called to persist the new
+// address in the storage system.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the store call.
+//   - number: An integer representing the unique identifier of the parcel.
+//   - address: A string containing the new address to which the parcel
+//     should be sent.
+//
+// Returns:
+// - ErrParcelNotFound if the parcel does not exist.
+// - ErrParcelAlreadyDelivered if the parcel has been delivered.
+// - ErrVersionConflict if the parcel was changed concurrently.
+// - An error if the address is rejected or the update fails; otherwise, it returns nil.
+func (s ParcelService) ChangeAddress(ctx context.Context, number int, address string) error {
+	return s.changeAddress(ctx, number, address, func(ctx context.Context, address string, version int) error {
+		return s.store.SetAddress(ctx, number, address, version)
+	})
+}
+
+// changeAddress normalizes and checks the address, refuses the change
+// for a delivered parcel and persists the address with set, given the
+// version of the parcel it was fetched at.
+func (s ParcelService) changeAddress(ctx context.Context, number int, address string, set func(ctx context.Context, address string, version int) error) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	address = s.normalizeAddress(address)
+
+	if err := s.checkAddress(address); err != nil {
+		return err
+	}
+
+	parcel, err := s.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	if parcel.Status == ParcelStatusDelivered {
+		return ErrParcelAlreadyDelivered
+	}
+
+	return set(ctx, address, parcel.Version)
+}
+
+// Delete removes a parcel from the store.
+//
+// This method deletes the parcel identified by its unique number from
+// the storage system. It calls the store's Delete method to
+// perform the operation. When nothing was deleted, the parcel is looked
+// up to tell a missing parcel from one that can no longer be deleted.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store call.
+// - number: An integer representing the unique identifier of the parcel.
+//
+// Returns:
+// - ErrParcelNotFound if the parcel does not exist.
+// - ErrParcelNotDeletable if the parcel is no longer registered.
+// - An error if the deletion fails; otherwise, it returns nil.
+func (s ParcelService) Delete(ctx context.Context, number int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	err := s.store.Delete(ctx, number)
+	if !errors.Is(err, ErrParcelNotFound) {
+		return err
+	}
+
+	if _, getErr := s.Get(ctx, number); getErr != nil {
+		return getErr
+	}
+
+	return ErrParcelNotDeletable
+}
+
+// CanDelete reports whether Delete would remove a parcel, without
+// deleting it, so tooling can explain up front why a parcel cannot be
+// deleted. Only registered parcels can be deleted.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store call.
+// - number: An integer representing the unique identifier of the parcel.
+//
+// Returns:
+// - true if the parcel is registered.
+// - ErrParcelNotFound if the parcel does not exist, or any store error.
+func (s ParcelService) CanDelete(ctx context.Context, number int) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	parcel, err := s.Get(ctx, number)
+	if err != nil {
+		return false, err
+	}
+
+	return parcel.Status == ParcelStatusRegistered, nil
+}
+
+// parcelColumns lists the parcel table columns in the order scanParcel
+// expects them.
+const parcelColumns = "number, " + parcelDataColumns
+
+// parcelDataColumns lists every column of the parcel table except
+// number, in the order of parcelColumns.
+const parcelDataColumns = "client, status, address, created_at, updated_at, deleted_at, registered_by, external_ref, version, weight_grams, length_mm, width_mm, height_mm, tracking_code, sent_at, delivered_at, priority, metadata"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanParcel reads a row selected with parcelColumns into p.
+//
+// Columns other than number and client are read as nullable, so a row
+// written by another tool with a NULL where the schema expects a value
+// is read with the zero value instead of failing the whole query. A
+// scan error names the parcel number when it was read before the
+// failing column.
+func scanParcel(row rowScanner, p *Parcel) error {
+	var (
+		status, address, registeredBy, externalRef, trackingCode, metadata sql.NullString
+		createdAt, updatedAt                                               sql.NullTime
+		version, weight, length, width, height, priority                   sql.NullInt64
+	)
+
+	err := row.Scan(&p.Number, &p.Client, &status, &address, &createdAt, &updatedAt, &p.DeletedAt, &registeredBy, &externalRef, &version,
+		&weight, &length, &width, &height, &trackingCode, &p.SentAt, &p.DeliveredAt, &priority, &metadata)
+	if err != nil {
+		if p.Number != 0 {
+			return fmt.Errorf("parcel %d: %w", p.Number, err)
+		}
+
+		return err
+	}
+
+	p.Metadata, err = decodeMetadata(metadata)
+	if err != nil {
+		return fmt.Errorf("parcel %d: %w", p.Number, err)
+	}
+
+	p.Status = ParcelStatus(status.String)
+	p.Address = address.String
+	p.CreatedAt = createdAt.Time
+	p.UpdatedAt = updatedAt.Time
+	p.RegisteredBy = registeredBy.String
+	p.ExternalRef = externalRef.String
+	p.Version = int(version.Int64)
+	p.WeightGrams = int(weight.Int64)
+	p.LengthMM = int(length.Int64)
+	p.WidthMM = int(width.Int64)
+	p.HeightMM = int(height.Int64)
+	p.TrackingCode = trackingCode.String
+	p.Priority = int(priority.Int64)
+
+	return nil
+}
+
+// nullString converts an optional string to a column value, storing
+// the empty string as NULL so it is exempt from unique indexes.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// ParcelStore is a struct that represents the storage layer for parcels.
+//
+// It encapsulates a connection to the database, allowing for operations
+// related to parcel records to be conducted. The `db` field is a pointer
+// to an sql.DB instance, which is used to interact with the underlying
+// database for adding, retrieving, and managing parcel data.
+type ParcelStore struct {
+	// db is a pointer to the SQL database connection.
+	db *sql.DB
+	// dialect rewrites query placeholders for the underlying driver.
+	dialect Dialect
+	// stats counts calls and failures per operation. It is shared
+	// between copies of the store.
+	stats *operationStats
+	// tx is the transaction the store is bound to by WithTx, if any.
+	tx *sql.Tx
+	// tracer starts a span around every store operation. A nil tracer
+	// behaves as a no-op one.
+	tracer trace.Tracer
+	// stmts caches the prepared statements of Get and Add. It is nil
+	// if prepared statements are disabled.
+	stmts *statementCache
+	// conn provides the connection pool instead of db when it is set
+	// by WithReconnectingDB.
+	conn *ReconnectingDB
+	// table is the parcel table the queries run against, set by
+	// WithTableName.
+	table TableName
+	// reader is the database read-only queries run against when it is
+	// set by WithReader.
+	reader *sql.DB
+}
+
+// dbExecutor is the query interface shared by *sql.DB and *sql.Tx.
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// executor returns the transaction the store is bound to, or the
+// database connection pool otherwise.
+func (s ParcelStore) executor() dbExecutor {
+	if s.tx != nil {
+		return s.tx
+	}
+
+	return s.pool()
+}
+
+// WithTx runs fn with a store bound to a single transaction, so several
+// store operations can be composed atomically.
+//
+// The transaction is committed if fn returns nil and rolled back
+// otherwise. If the store is already bound to a transaction, fn joins
+// it and the outermost WithTx decides whether to commit.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the transaction.
+// - fn: the operations to run; it must only use the store it is given.
+//
+// Returns:
+// - The error returned by fn, or any error beginning or committing.
+func (s ParcelStore) WithTx(ctx context.Context, fn func(ParcelStore) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.pool().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	txStore := s
+	txStore.tx = tx
+
+	if err = fn(txStore); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// StoreOption configures optional behaviour of a ParcelStore.
+type StoreOption func(*ParcelStore)
+
+// WithDialect makes the store emit SQL for the given dialect.
+// Stores default to DialectSQLite.
+func WithDialect(dialect Dialect) StoreOption {
+	return func(s *ParcelStore) {
+		s.dialect = dialect
+	}
+}
+
+var _ ParcelRepository = ParcelStore{}
+
+// NewParcelStore creates a new ParcelStore instance.
+//
+// This function initializes a new ParcelStore using the provided
+// database connection. It returns a ParcelStore that can be used
+// for operations on parcels.
+//
+// Parameters:
+//   - db: A pointer to an sql.DB instance, representing the database
+//     connection to be used by the ParcelStore.
+//   - opts: Optional settings such as the SQL dialect or the table name.
+//
+// The queries of Get and Add are prepared on first use unless disabled
+// with WithPreparedStatements; call Close to release them.
+//
+// Returns:
+// - A new instance of ParcelStore.
+func NewParcelStore(db *sql.DB, opts ...StoreOption) ParcelStore {
+	store := ParcelStore{db: db, stats: newOperationStats(), stmts: &statementCache{}}
+	for _, opt := range opts {
+		opt(&store)
+	}
+
+	return store
+}
+
+// NewParcelStoreForDriver creates a ParcelStore speaking the dialect of
+// the named database driver, so callers do not have to pick it with
+// WithDialect.
+//
+// Parameters:
+//   - db: the database connection opened with the driver.
+//   - driver: the database/sql driver name, such as "sqlite",
+//     "postgres" or "mysql".
+//   - opts: Optional settings applied after the dialect is selected.
+//
+// Returns:
+// - A new instance of ParcelStore.
+// - ErrUnknownDriver if the driver's dialect is not known.
+func NewParcelStoreForDriver(db *sql.DB, driver string, opts ...StoreOption) (ParcelStore, error) {
+	dialect, err := dialectForDriver(driver)
+	if err != nil {
+		return ParcelStore{}, err
+	}
+
+	return NewParcelStore(db, append([]StoreOption{WithDialect(dialect)}, opts...)...), nil
+}
+
+// OperationStats returns the number of calls and errors recorded for
+// each store operation since the store was created.
+//
+// The map is keyed by method name (e.g. "Add", "Get") and is a copy
+// that the caller may modify freely.
+func (s ParcelStore) OperationStats() map[string]OpStat {
+	return s.stats.snapshot()
+}
+
+// observe finishes a store operation: a non-nil *err is wrapped with
+// the operation name, so messages read "parcelstore.Get: ...", the
+// outcome is recorded in the operation stats, and span is ended and
+// marked as failed on error. Every exported store method starts span
+// with startSpan and defers observe with a pointer to its named error
+// result.
+func (s ParcelStore) observe(span trace.Span, op string, err *error) {
+	if *err != nil {
+		*err = fmt.Errorf("parcelstore.%s: %w", op, *err)
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+
+	s.stats.record(op, *err)
+	span.End()
+}
+
+// Ping verifies that the database backing the store is reachable.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the check.
+//
+// Returns:
+// - An error wrapping the driver error if the database cannot be reached.
+func (s ParcelStore) Ping(ctx context.Context) (err error) {
+	ctx, span := s.startSpan(ctx, "Ping")
+	defer s.observe(span, "Ping", &err)
+
+	if err = s.pool().PingContext(ctx); err != nil {
+		return fmt.Errorf("parcel store unreachable: %w", err)
+	}
+
+	return nil
+}
+
+// Add inserts a new parcel into the database and returns the newly created parcel's ID.
+//
+// The ID is read with RETURNING on dialects whose drivers do not
+// support LastInsertId, such as Postgres. The parcel's tracking code is
+// derived from the ID and stored in the same transaction.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - p: the Parcel object containing the details of the parcel to be added.
+//
+// Returns:
+// - The ID of the last inserted Parcel.
+// - An error, if the status is invalid or the insert operation fails.
+func (s ParcelStore) Add(ctx context.Context, p *Parcel) (err error) {
+	ctx, span := s.startSpan(ctx, "Add")
+	defer s.observe(span, "Add", &err)
+
+	if err = s.add(ctx, p); err != nil {
+		return err
+	}
+
+	span.SetAttributes(attrNumber(int(p.Number)), attrStatus(p.Status))
+
+	return nil
+}
+
+// add does the work of Add without a span or stats of its own, so other
+// store methods can insert parcels as part of their operation.
+func (s ParcelStore) add(ctx context.Context, p *Parcel) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	metadata, err := encodeMetadata(p.Metadata)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+
+	s.prepareStatements(ctx)
+
+	var number int64
+
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		var err error
+
+		number, err = tx.insertReturningNumber(ctx, insertParcelQuery(s.table),
+			p.Client, p.Status, p.Address, p.CreatedAt, updatedAt, p.RegisteredBy, nullString(p.ExternalRef), p.WeightGrams, p.LengthMM, p.WidthMM, p.HeightMM, p.Priority, metadata)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET tracking_code = ? WHERE number = ?"), TrackingCode(number), number)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	p.Number = number
+	p.UpdatedAt = updatedAt
+	p.Version = 1
+	p.TrackingCode = TrackingCode(number)
+
+	return nil
+}
+
+// AddMany inserts several parcels in a single transaction, assigning
+// each its number.
+//
+// The batch is atomic: if any parcel fails to insert, none of them are
+// stored and the Number of every parcel is left at zero. The context is
+// checked before each insert, so cancelling it aborts a long batch
+// between rows.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the transaction.
+// - parcels: the parcels to insert; nil elements are rejected.
+//
+// Returns:
+// - An error, if any parcel is invalid or an insert fails.
+// - ctx.Err() if the context is done before every parcel is inserted.
+func (s ParcelStore) AddMany(ctx context.Context, parcels []*Parcel) (err error) {
+	ctx, span := s.startSpan(ctx, "AddMany")
+	defer s.observe(span, "AddMany", &err)
+
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		return tx.addAll(ctx, parcels)
+	})
+	if err != nil {
+		clearNumbers(parcels)
+		return err
+	}
+
+	return nil
+}
+
+// AddWithinLimit inserts new parcels of one client like AddMany, unless
+// the client would then have more than limit parcels that are not
+// delivered. The active parcels are counted in the transaction that
+// inserts the new ones.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the transaction.
+// - parcels: the parcels to insert, all of the same client.
+// - limit: the maximum number of active parcels of the client.
+//
+// Returns:
+//   - ErrClientParcelLimitExceeded if the parcels do not fit the limit;
+//     nothing is inserted then.
+//   - An error, if any parcel is invalid or an insert fails.
+func (s ParcelStore) AddWithinLimit(ctx context.Context, parcels []*Parcel, limit int) (err error) {
+	ctx, span := s.startSpan(ctx, "AddWithinLimit")
+	defer s.observe(span, "AddWithinLimit", &err)
+
+	if len(parcels) == 0 {
+		return nil
+	}
+
+	if parcels[0] == nil {
+		return ErrNilParcel
+	}
+
+	client := parcels[0].Client
+
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		var active int
+
+		err := tx.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT COUNT(*) FROM "+s.table.String()+
+			" WHERE client = ? AND status <> ? AND deleted_at IS NULL"), client, ParcelStatusDelivered).Scan(&active)
+		if err != nil {
+			return err
+		}
+
+		if active+len(parcels) > limit {
+			return fmt.Errorf("%w: client %d has %d of %d", ErrClientParcelLimitExceeded, client, active, limit)
+		}
+
+		return tx.addAll(ctx, parcels)
+	})
+	if err != nil {
+		clearNumbers(parcels)
+		return err
+	}
+
+	return nil
+}
+
+// addAll inserts the parcels one by one, checking ctx before each.
+func (s ParcelStore) addAll(ctx context.Context, parcels []*Parcel) error {
+	for _, p := range parcels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := s.add(ctx, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearNumbers resets the numbers of parcels whose batch was rolled
+// back.
+func clearNumbers(parcels []*Parcel) {
+	for _, p := range parcels {
+		if p != nil {
+			p.Number = 0
+		}
+	}
+}
+
+// insertReturningNumber runs an INSERT into the parcel table and returns
+// the generated parcel number. The number is read with RETURNING on
+// dialects whose drivers do not support LastInsertId.
+func (s ParcelStore) insertReturningNumber(ctx context.Context, query string, args ...any) (int64, error) {
+	if s.dialect.usesReturning() {
+		var number int64
+
+		err := s.queryRow(ctx, s.dialect.Rebind(query+" RETURNING number"), args...).Scan(&number)
+		if err != nil {
+			return 0, err
+		}
+
+		return number, nil
+	}
+
+	result, err := s.exec(ctx, s.dialect.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Get retrieves a parcel from the database by its number.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel to retrieve.
+//
+// Returns:
+// - The Parcel object corresponding to the given number.
+// - ErrParcelNotFound if there is no such parcel or it was soft-deleted,
+// or any error of the query.
+func (s ParcelStore) Get(ctx context.Context, number int) (_ Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "Get", attrNumber(number))
+	defer s.observe(span, "Get", &err)
+
+	return s.get(ctx, number)
+}
+
+// get does the work of Get without a span or stats of its own.
+func (s ParcelStore) get(ctx context.Context, number int) (Parcel, error) {
+	reader := s.forReads()
+	reader.prepareStatements(ctx)
+
+	row := reader.queryRow(ctx, s.dialect.Rebind(selectParcelQuery(s.table)), number)
+
+	gottenParcel := Parcel{}
+
+	err := scanParcel(row, &gottenParcel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return gottenParcel, nil
+}
+
+// Exists reports whether a parcel with the given number exists, without
+// reading its columns. Like Get, it does not see soft-deleted parcels.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel.
+//
+// Returns:
+// - Whether the parcel exists; false with a nil error if it does not.
+// - An error, if any occurs during the query.
+func (s ParcelStore) Exists(ctx context.Context, number int) (_ bool, err error) {
+	ctx, span := s.startSpan(ctx, "Exists", attrNumber(number))
+	defer s.observe(span, "Exists", &err)
+
+	var exists bool
+
+	err = s.forReads().executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT EXISTS(SELECT 1 FROM "+s.table.String()+" WHERE number = ? AND deleted_at IS NULL)"), number).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// GetByExternalRef retrieves the parcel with the given external
+// reference.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - ref: the external reference of the parcel to retrieve.
+//
+// Returns:
+// - The parcel with the reference.
+// - ErrParcelNotFound if no parcel has the reference, or any error of
+// the query.
+func (s ParcelStore) GetByExternalRef(ctx context.Context, ref string) (_ Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByExternalRef")
+	defer s.observe(span, "GetByExternalRef", &err)
+
+	row := s.forReads().executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE external_ref = ? AND deleted_at IS NULL"), ref)
+
+	var parcel Parcel
+
+	err = scanParcel(row, &parcel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return parcel, nil
+}
+
+// GetByNumbers retrieves the parcels with the given numbers in a single
+// query, ordered by number. Numbers without a matching parcel are
+// skipped.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - numbers: the numbers of the parcels to retrieve.
+//
+// Returns:
+// - The parcels found, ordered by number; empty for no numbers.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetByNumbers(ctx context.Context, numbers []int64) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByNumbers")
+	defer s.observe(span, "GetByNumbers", &err)
+
+	if len(numbers) == 0 {
+		return []Parcel{}, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(numbers)-1) + "?"
+
+	args := make([]any, len(numbers))
+	for i, number := range numbers {
+		args[i] = number
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE number IN ("+placeholders+") AND deleted_at IS NULL ORDER BY number", args...)
+}
+
+// GetByClient retrieves a list of parcels associated with a specific
+// client, ordered by number.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+//
+// Returns:
+// - A slice of Parcel objects corresponding to the given client.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetByClient(ctx context.Context, client int) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClient", attrClient(client))
+	defer s.observe(span, "GetByClient", &err)
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL ORDER BY number", client)
+}
+
+// GetByClientInto retrieves the parcels of a client like GetByClient,
+// but appends them to the slice dst points to after truncating it, so
+// the caller's backing array is reused when its capacity allows. Sizing
+// dst with CountByClient first avoids any reallocation:
+//
+//	count, err := store.CountByClient(ctx, client)
+//	...
+//	parcels := make([]Parcel, 0, count)
+//	err = store.GetByClientInto(ctx, client, &parcels)
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+// - dst: the slice receiving the client's parcels ordered by number.
+//
+// Returns:
+//   - ErrNilDestination if dst is nil, or any error of the query; *dst
+//     is left empty on error.
+func (s ParcelStore) GetByClientInto(ctx context.Context, client int, dst *[]Parcel) (err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientInto", attrClient(client))
+	defer s.observe(span, "GetByClientInto", &err)
+
+	if dst == nil {
+		return ErrNilDestination
+	}
+
+	parcels := (*dst)[:0]
+
+	err = s.forReads().eachParcel(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL ORDER BY number", func(p Parcel) error {
+		parcels = append(parcels, p)
+		return nil
+	}, client)
+	if err != nil {
+		*dst = parcels[:0]
+		return err
+	}
+
+	*dst = parcels
+
+	return nil
+}
+
+// GetByClientByPriority retrieves the parcels of a client with the
+// highest priority first, and the oldest first among parcels of the
+// same priority, so express shipments surface at the top.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+//
+// Returns:
+// - The client's parcels in priority order.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetByClientByPriority(ctx context.Context, client int) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientByPriority", attrClient(client))
+	defer s.observe(span, "GetByClientByPriority", &err)
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL ORDER BY priority DESC, created_at ASC, number ASC", client)
+}
+
+// GetLatestByClient retrieves the most recently created parcel of a
+// client. Parcels created at the same time are told apart by number.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcel is to be retrieved.
+//
+// Returns:
+// - The latest parcel of the client.
+// - ErrParcelNotFound if the client has no parcels, or any error of the query.
+func (s ParcelStore) GetLatestByClient(ctx context.Context, client int) (_ Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetLatestByClient", attrClient(client))
+	defer s.observe(span, "GetLatestByClient", &err)
+
+	row := s.forReads().executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL "+
+		"ORDER BY created_at DESC, number DESC LIMIT 1"), client)
+
+	latest := Parcel{}
+
+	err = scanParcel(row, &latest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return latest, nil
+}
+
+// EachByClient calls fn with every parcel of a client, ordered by
+// number, while reading them from the database. Unlike GetByClient it
+// never holds more than one parcel in memory.
+//
+// Iteration stops at the first error returned by fn; the rows are
+// closed and that error is returned.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are visited.
+// - fn: the function called with each parcel.
+//
+// Returns:
+// - The error returned by fn, or any error of the query.
+func (s ParcelStore) EachByClient(ctx context.Context, client int, fn func(Parcel) error) (err error) {
+	ctx, span := s.startSpan(ctx, "EachByClient", attrClient(client))
+	defer s.observe(span, "EachByClient", &err)
+
+	return s.forReads().eachParcel(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL ORDER BY number", fn, client)
+}
+
+// GetByClientIncludingDeleted retrieves all parcels of a client,
+// including those that were soft-deleted.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+//
+// Returns:
+// - A slice of Parcel objects; soft-deleted ones have DeletedAt set.
+// - An error, if any occurs during the retrieval operation.
+func (s ParcelStore) GetByClientIncludingDeleted(ctx context.Context, client int) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientIncludingDeleted", attrClient(client))
+	defer s.observe(span, "GetByClientIncludingDeleted", &err)
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ?", client)
+}
+
+// GetByClientAndStatus retrieves the parcels of a client that are in the
+// given status.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+// - status: the status to filter by; must be a known ParcelStatus.
+//
+// Returns:
+//   - A slice of matching Parcel objects.
+//   - An error wrapping ErrInvalidStatus for an unknown status, or any
+//     error from the query. An invalid status never reaches the database.
+func (s ParcelStore) GetByClientAndStatus(ctx context.Context, client int, status ParcelStatus) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientAndStatus", attrClient(client), attrStatus(status))
+	defer s.observe(span, "GetByClientAndStatus", &err)
+
+	if !status.IsValid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND status = ? AND deleted_at IS NULL", client, status)
+}
+
+// GetByOperator retrieves the parcels registered by the given operator.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - operator: the identifier of the operator; must not be empty.
+//
+// Returns:
+// - A slice of Parcel objects registered by the operator.
+// - An error, if the operator is empty or the query fails.
+func (s ParcelStore) GetByOperator(ctx context.Context, operator string) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByOperator")
+	defer s.observe(span, "GetByOperator", &err)
+
+	if operator == "" {
+		return nil, ErrEmptyOperator
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE registered_by = ? AND deleted_at IS NULL", operator)
+}
+
+// GetCreatedBetween retrieves the parcels in the given status that were
+// created in the half-open interval [from, to), ordered by number.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - status: the status to filter by; must be a known ParcelStatus.
+// - from: the inclusive start of the creation interval.
+// - to: the exclusive end of the creation interval.
+//
+// Returns:
+// - A slice of matching Parcel objects.
+// - An error wrapping ErrInvalidStatus for an unknown status, or any error from the query.
+func (s ParcelStore) GetCreatedBetween(ctx context.Context, status ParcelStatus, from, to time.Time) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetCreatedBetween", attrStatus(status))
+	defer s.observe(span, "GetCreatedBetween", &err)
+
+	if !status.IsValid() {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE status = ? AND created_at >= ? AND created_at < ? AND deleted_at IS NULL ORDER BY number",
+		status, from.UTC(), to.UTC())
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character
+// itself, so user input matches literally. The escape character is "!"
+// rather than a backslash, which MySQL treats as an escape inside
+// string literals.
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+// SearchByAddress retrieves the parcels whose address contains the given
+// substring, ignoring case, ordered by number.
+//
+// The substring is matched literally: % and _ in it are not treated as
+// wildcards. Case folding is done by the database's LOWER function, so
+// for SQLite it only covers ASCII letters.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - substring: the text to look for; must not be blank.
+//
+// Returns:
+// - A slice of matching Parcel objects.
+// - ErrEmptySearchQuery for a blank substring, or any error from the query.
+func (s ParcelStore) SearchByAddress(ctx context.Context, substring string) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "SearchByAddress")
+	defer s.observe(span, "SearchByAddress", &err)
+
+	if strings.TrimSpace(substring) == "" {
+		return nil, ErrEmptySearchQuery
+	}
+
+	pattern := "%" + likeEscaper.Replace(substring) + "%"
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE LOWER(address) LIKE LOWER(?) ESCAPE '!' AND deleted_at IS NULL ORDER BY number", pattern)
+}
+
+// GetByDateRange retrieves the parcels created in the half-open interval
+// [from, to), ordered by creation time.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - from: the inclusive start of the interval.
+// - to: the exclusive end of the interval; must be after from.
+//
+// Returns:
+// - A slice of matching Parcel objects.
+// - An error wrapping ErrInvalidDateRange if from is not before to, or any error from the query.
+func (s ParcelStore) GetByDateRange(ctx context.Context, from, to time.Time) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByDateRange")
+	defer s.observe(span, "GetByDateRange", &err)
+
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: %s is not before %s", ErrInvalidDateRange, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE created_at >= ? AND created_at < ? AND deleted_at IS NULL ORDER BY created_at, number",
+		from.UTC(), to.UTC())
+}
+
+// GetByClientPaged retrieves one page of a client's parcels ordered by
+// parcel number.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+// - limit: the maximum number of parcels to return; must be positive.
+// - offset: the number of parcels to skip; must not be negative.
+//
+// Returns:
+//   - The parcels on the requested page; empty when the page is past
+//     the end.
+//   - An error wrapping ErrInvalidPage for bad limit or offset values,
+//     or any error from the query.
+func (s ParcelStore) GetByClientPaged(ctx context.Context, client, limit, offset int) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientPaged", attrClient(client))
+	defer s.observe(span, "GetByClientPaged", &err)
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be positive, got %d", ErrInvalidPage, limit)
+	}
+
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: offset must not be negative, got %d", ErrInvalidPage, offset)
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL ORDER BY number LIMIT ? OFFSET ?", client, limit, offset)
+}
+
+// GetByClientAfter retrieves one page of a client's parcels ordered by
+// parcel number, starting after a cursor. Unlike GetByClientPaged it
+// does not skip rows, so deep pages cost as little as the first one,
+// and parcels added or deleted between calls do not shift the pages.
+//
+// To walk all parcels, pass 0 as afterNumber for the first page, then
+// the number of the last parcel of each page to get the next one. A
+// page shorter than limit is the last.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are to be retrieved.
+// - afterNumber: the cursor; only parcels with a greater number are returned.
+// - limit: the maximum number of parcels to return; must be positive.
+//
+// Returns:
+//   - The parcels on the page; empty when there are none after the
+//     cursor.
+//   - An error wrapping ErrInvalidPage for bad limit or cursor values,
+//     or any error from the query.
+func (s ParcelStore) GetByClientAfter(ctx context.Context, client int, afterNumber int64, limit int) (_ []Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByClientAfter", attrClient(client))
+	defer s.observe(span, "GetByClientAfter", &err)
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be positive, got %d", ErrInvalidPage, limit)
+	}
+
+	if afterNumber < 0 {
+		return nil, fmt.Errorf("%w: cursor must not be negative, got %d", ErrInvalidPage, afterNumber)
+	}
+
+	return s.forReads().queryParcels(ctx, "SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE client = ? AND number > ? AND deleted_at IS NULL ORDER BY number LIMIT ?", client, afterNumber, limit)
+}
+
+// CountByClient returns the total number of parcels of a client, e.g.
+// to render pagination controls alongside GetByClientPaged.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are counted.
+//
+// Returns:
+// - The number of parcels; 0 when the client has none.
+// - An error, if any occurs during the query.
+func (s ParcelStore) CountByClient(ctx context.Context, client int) (_ int, err error) {
+	ctx, span := s.startSpan(ctx, "CountByClient", attrClient(client))
+	defer s.observe(span, "CountByClient", &err)
+
+	var count int
+
+	err = s.forReads().executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT COUNT(*) FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL"), client).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountByStatus returns the number of parcels of a client in each
+// status.
+//
+// Every known status is present in the result, with zero if the client
+// has no parcels in it.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are counted.
+//
+// Returns:
+// - The parcel count per status.
+// - An error, if any occurs during the query.
+func (s ParcelStore) CountByStatus(ctx context.Context, client int) (_ map[ParcelStatus]int, err error) {
+	ctx, span := s.startSpan(ctx, "CountByStatus", attrClient(client))
+	defer s.observe(span, "CountByStatus", &err)
+
+	rows, err := s.forReads().executor().QueryContext(ctx, s.dialect.Rebind("SELECT status, COUNT(*) FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL GROUP BY status"), client)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	counts := make(map[ParcelStatus]int, len(knownStatuses))
+	for _, status := range knownStatuses {
+		counts[status] = 0
+	}
+
+	for rows.Next() {
+		var (
+			status ParcelStatus
+			count  int
+		)
+
+		if err = rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+
+		counts[status] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// FindDuplicateAddresses reports the addresses that appear on more than
+// one parcel of a client, which usually means a shipment was registered
+// twice.
+//
+// Addresses are compared exactly as stored; ParcelService normalizes
+// them before they are stored (see WithAddressNormalizer).
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - client: the unique identifier of the client whose parcels are checked.
+//
+// Returns:
+//   - The parcel numbers, in ascending order, keyed by each duplicated
+//     address; empty if there are no duplicates.
+//   - An error, if any occurs during the query.
+func (s ParcelStore) FindDuplicateAddresses(ctx context.Context, client int) (_ map[string][]int64, err error) {
+	ctx, span := s.startSpan(ctx, "FindDuplicateAddresses", attrClient(client))
+	defer s.observe(span, "FindDuplicateAddresses", &err)
+
+	rows, err := s.forReads().executor().QueryContext(ctx, s.dialect.Rebind("SELECT number, address FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL AND address IN "+
+		"(SELECT address FROM "+s.table.String()+" WHERE client = ? AND deleted_at IS NULL GROUP BY address HAVING COUNT(*) > 1) ORDER BY number"), client, client)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	duplicates := make(map[string][]int64)
+	for rows.Next() {
+		var (
+			number  int64
+			address string
+		)
+
+		if err = rows.Scan(&number, &address); err != nil {
+			return nil, err
+		}
+
+		duplicates[address] = append(duplicates[address], number)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return duplicates, nil
+}
+
+// queryParcels runs a query selecting parcelColumns and scans every
+// returned row into a Parcel.
+func (s ParcelStore) queryParcels(ctx context.Context, query string, args ...any) ([]Parcel, error) {
+	var parcels []Parcel
+
+	err := s.eachParcel(ctx, query, func(p Parcel) error {
+		parcels = append(parcels, p)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parcels, nil
+}
+
+// eachParcel runs a query selecting parcelColumns and calls fn with
+// every returned row as it is read. It stops at the first error
+// returned by fn and returns that error.
+func (s ParcelStore) eachParcel(ctx context.Context, query string, fn func(Parcel) error, args ...any) error {
+	rows, err := s.executor().QueryContext(ctx, s.dialect.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var parcel Parcel
+
+		if err = scanParcel(rows, &parcel); err != nil {
+			return err
+		}
+
+		if err = fn(parcel); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// SetStatus updates the status of a parcel identified by its number,
+// provided the parcel is still at the expected version.
+//
+// The change increments the version and is recorded in the parcel's
+// status history within the same transaction. Moving forward to sent
+// or delivered stamps SentAt or DeliveredAt; moving backward clears the
+// timestamps of the statuses left behind.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel to be updated.
+// - status: the new status to set for the parcel.
+// - version: the version of the parcel the change is based on.
+//
+// Returns:
+// - ErrParcelNotFound if no parcel has the given number.
+// - ErrVersionConflict if the parcel is no longer at version.
+// - An error, if the status is invalid or the update operation fails.
+func (s ParcelStore) SetStatus(ctx context.Context, number int, status ParcelStatus, version int) (err error) {
+	ctx, span := s.startSpan(ctx, "SetStatus", attrNumber(number), attrStatus(status))
+	defer s.observe(span, "SetStatus", &err)
+
+	if !status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	return s.WithTx(ctx, func(tx ParcelStore) error {
+		var current ParcelStatus
+
+		err := tx.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT status FROM "+s.table.String()+" WHERE number = ?"), number).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrParcelNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+
+		set, args := statusSet(current, status, now)
+
+		result, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET "+set+", version = version + 1 WHERE number = ? AND version = ?"),
+			append(args, number, version)...)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.requireVersion(ctx, result, number); err != nil {
+			return err
+		}
+
+		return tx.recordStatusChange(ctx, number, current, status, now)
+	})
+}
+
+// statusSet returns the SET clause moving a parcel from status from to
+// status to at now, together with its arguments. The status timestamp
+// columns are stamped or cleared as described by timestampChanges.
+func statusSet(from, to ParcelStatus, now time.Time) (string, []any) {
+	set, args := "status = ?, updated_at = ?", []any{to, now}
+
+	stamp, clear := timestampChanges(from, to)
+	if stamp != "" {
+		set += ", " + stamp + " = ?"
+		args = append(args, now)
+	}
+
+	for _, column := range clear {
+		set += ", " + column + " = NULL"
+	}
+
+	return set, args
+}
+
+// SetStatusMany moves every parcel in numbers to status in a single
+// UPDATE, for batch operations such as a warehouse scan marking parcels
+// as sent.
+//
+// The update runs in a transaction together with the status history
+// entries of the changed parcels. Parcels already in status are left
+// untouched and missing numbers are ignored. The status timestamps are
+// kept consistent with SetStatus: SentAt or DeliveredAt is stamped if
+// not set yet, and the timestamps of later statuses are cleared.
+// Versions are incremented but not checked.
 //
 // Parameters:
-//   - client: An integer representing the client ID associated
-//     with the parcel.
-//   - address: A string containing the destination address of
-//     the parcel.
+// - ctx: the context controlling cancellation of the transaction.
+// - numbers: the numbers of the parcels to update.
+// - status: the new status to set for the parcels.
 //
 // Returns:
-//   - The created Parcel, which includes the assigned number and
-//     other details.
-//   - An error, if any occurred during the registration process.
-func (s ParcelService) Register(client int64, address string) (Parcel, error) {
-	parcel := Parcel{
-		Client:    client,
-		Status:    ParcelStatusRegistered,
-		Address:   address,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+// - The number of parcels moved to status; 0 for no numbers.
+// - An error, if the status is invalid or the transaction fails.
+func (s ParcelStore) SetStatusMany(ctx context.Context, numbers []int64, status ParcelStatus) (changed int64, err error) {
+	ctx, span := s.startSpan(ctx, "SetStatusMany", attrStatus(status))
+	defer s.observe(span, "SetStatusMany", &err)
+
+	if !status.IsValid() {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+
+	if len(numbers) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(numbers)-1) + "?"
+
+	args := make([]any, 0, len(numbers)+1)
+	for _, number := range numbers {
+		args = append(args, number)
+	}
+	args = append(args, status)
+
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		current, err := tx.currentStatuses(ctx, "SELECT number, status FROM "+s.table.String()+" WHERE number IN ("+placeholders+") AND status <> ?", args...)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+
+		set := "status = ?, updated_at = ?"
+		setArgs := []any{status, now}
+
+		if column := status.timestampColumn(); column != "" {
+			set += ", " + column + " = COALESCE(" + column + ", ?)"
+			setArgs = append(setArgs, now)
+		}
+
+		for _, column := range laterTimestampColumns(status) {
+			set += ", " + column + " = NULL"
+		}
+
+		result, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET "+set+", version = version + 1 WHERE number IN ("+placeholders+") AND status <> ?"),
+			append(setArgs, args...)...)
+		if err != nil {
+			return err
+		}
+
+		if changed, err = result.RowsAffected(); err != nil {
+			return err
+		}
+
+		for _, number := range numbers {
+			old, ok := current[number]
+			if !ok {
+				continue
+			}
+			delete(current, number)
+
+			if err = tx.recordStatusChange(ctx, int(number), old, status, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	err := s.store.Add(&parcel)
+	return changed, nil
+}
+
+// currentStatuses runs a query selecting parcel numbers and statuses
+// and returns the statuses keyed by number.
+func (s ParcelStore) currentStatuses(ctx context.Context, query string, args ...any) (map[int64]ParcelStatus, error) {
+	rows, err := s.executor().QueryContext(ctx, s.dialect.Rebind(query), args...)
 	if err != nil {
-		return Parcel{}, err
+		return nil, err
 	}
+	defer func() {
+		_ = rows.Close()
+	}()
 
-	fmt.Printf("Новая посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s\n",
-		parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt)
+	statuses := make(map[int64]ParcelStatus)
+	for rows.Next() {
+		var (
+			number int64
+			status ParcelStatus
+		)
 
-	return parcel, nil
+		if err = rows.Scan(&number, &status); err != nil {
+			return nil, err
+		}
+
+		statuses[number] = status
+	}
+
+	return statuses, rows.Err()
 }
 
-// PrintClientParcels prints the details of all parcels associated with a given client.
+// AdvanceStatus moves a parcel to the next status of its lifecycle.
 //
-// It retrieves the parcels for the specified client by their ID using the
-// ParcelStore's GetByClient method. If an error occurs during retrieval,
-// it returns the error. Upon successfully fetching the parcels, it prints
-// each parcel's details, including the parcel number, address, client ID,
-// registration date, and status.
+// The current status is re-read and the next one written inside a
+// single transaction. The update is conditional on the status read, so
+// a concurrent change results in ErrStatusChanged instead of a lost
+// update or a double advance. The change is recorded in the parcel's
+// status history and stamps SentAt or DeliveredAt like SetStatus. Any
+// error rolls the transaction back.
 //
 // Parameters:
-// - client: An integer representing the client's unique identifier.
+// - ctx: the context controlling cancellation of the transaction.
+// - number: the unique number of the parcel to advance.
 //
 // Returns:
-//   - An error, if any occurred during the retrieval process; otherwise,
-//     it returns nil.
-func (s ParcelService) PrintClientParcels(client int) error {
-	parcels, err := s.store.GetByClient(client)
+//   - The change, with the status read and the status the parcel was
+//     moved to.
+//   - false if the parcel is already in a terminal status; nothing is
+//     written in that case and the change has the current status as
+//     both OldStatus and NewStatus.
+//   - ErrParcelNotFound if the parcel does not exist.
+//   - ErrInvalidStatus if the stored status is empty or unknown.
+//   - An error, if any occurs during the transaction.
+func (s ParcelStore) AdvanceStatus(ctx context.Context, number int) (change StatusChange, advanced bool, err error) {
+	ctx, span := s.startSpan(ctx, "AdvanceStatus", attrNumber(number))
+	defer s.observe(span, "AdvanceStatus", &err)
+
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		var current ParcelStatus
+
+		err := tx.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT status FROM "+s.table.String()+" WHERE number = ?"), number).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrParcelNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !current.IsValid() {
+			return fmt.Errorf("%w: parcel %d has status %q", ErrInvalidStatus, number, current)
+		}
+
+		change = StatusChange{Number: int64(number), OldStatus: current, NewStatus: current}
+
+		next, ok := current.Next()
+		if !ok {
+			return nil
+		}
+
+		now := time.Now().UTC()
+
+		set, args := statusSet(current, next, now)
+
+		result, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET "+set+", version = version + 1 WHERE number = ? AND status = ?"),
+			append(args, number, current)...)
+		if err != nil {
+			return err
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if updated == 0 {
+			return ErrStatusChanged
+		}
+
+		if err = tx.recordStatusChange(ctx, number, current, next, now); err != nil {
+			return err
+		}
+
+		change.NewStatus, change.ChangedAt, advanced = next, now, true
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return StatusChange{}, false, err
 	}
 
-	fmt.Printf("Посылки клиента %d:\n", client)
-	for _, parcel := range parcels {
-		fmt.Printf("Посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s, статус %s\n",
-			parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt, parcel.Status)
-	}
+	span.SetAttributes(attrStatus(change.NewStatus))
 
-	return nil
+	return change, advanced, nil
 }
 
-// NextStatus updates the status of a parcel to its next logical state.
-//
-// It retrieves the parcel using the provided parcel number through the
-// ParcelStore's Get method. If an error occurs during retrieval, it
-// returns the error. Based on the current status of the parcel, it
-// determines the next status in the sequence: from registered to sent,
-// and from sent to delivered. If the parcel is already delivered,
-// it simply returns nil without making any updates.
-//
-// If the status is successfully updated, it prints the parcel number
-// and its new status. The new status is set using the ParcelStore's
-// SetStatus method.
+// SetAddress updates the address of a parcel identified by its number,
+// provided the parcel is still at the expected version. The change
+// increments the version.
 //
 // Parameters:
-// - number: An integer representing the unique identifier of the parcel.
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel to be updated.
+// - address: the new address to set for the parcel.
+// - version: the version of the parcel the change is based on.
 //
 // Returns:
-//   - An error, if any occurred during retrieval or status update;
-//     otherwise, it returns nil.
-func (s ParcelService) NextStatus(number int) error {
-	parcel, err := s.store.Get(number)
+// - ErrParcelNotFound if no parcel has the given number.
+// - ErrVersionConflict if the parcel is no longer at version.
+// - An error, if any occurs during the update operation.
+func (s ParcelStore) SetAddress(ctx context.Context, number int, address string, version int) (err error) {
+	ctx, span := s.startSpan(ctx, "SetAddress", attrNumber(number))
+	defer s.observe(span, "SetAddress", &err)
+
+	result, err := s.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND version = ?"),
+		address, time.Now().UTC(), number, version)
 	if err != nil {
 		return err
 	}
 
-	var nextStatus string
-	switch parcel.Status {
-	case ParcelStatusRegistered:
-		nextStatus = ParcelStatusSent
-	case ParcelStatusSent:
-		nextStatus = ParcelStatusDelivered
-	case ParcelStatusDelivered:
-		return nil
-	}
-
-	fmt.Printf("У посылки № %d новый статус: %s\n", number, nextStatus)
-
-	return s.store.SetStatus(number, nextStatus)
+	return s.requireVersion(ctx, result, number)
 }
 
-// ChangeAddress updates the delivery address of a parcel.
-//
-// This method changes the address of the parcel identified by its
-// unique number. It calls the ParcelStore's SetAddress method to
-// persist the new address in the storage system.
+// Delete removes a parcel from the database identified by its number.
+// The parcel will only be deleted if its status is 'registered'.
 //
 // Parameters:
-//   - number: An integer representing the unique identifier of the parcel.
-//   - address: A string containing the new address to which the parcel
-//     should be sent.
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel to be deleted.
 //
 // Returns:
-// - An error if the address update fails; otherwise, it returns nil.
-func (s ParcelService) ChangeAddress(number int, address string) error {
-	return s.store.SetAddress(number, address)
+// - ErrParcelNotFound if no registered parcel has the given number.
+// - An error, if any occurs during the deletion operation.
+func (s ParcelStore) Delete(ctx context.Context, number int) (err error) {
+	ctx, span := s.startSpan(ctx, "Delete", attrNumber(number))
+	defer s.observe(span, "Delete", &err)
+
+	result, err := s.executor().ExecContext(ctx, s.dialect.Rebind("DELETE FROM "+s.table.String()+" WHERE number = ? AND status = ?"), number, ParcelStatusRegistered)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
 }
 
-// Delete removes a parcel from the store.
-//
-// This method deletes the parcel identified by its unique number from
-// the storage system. It calls the ParcelStore's Delete method to
-// perform the operation.
+// DeleteManyRegistered removes the registered parcels among numbers in
+// a single DELETE, e.g. to clean up abandoned registrations. Like
+// Delete, it never removes a parcel that has been sent or delivered;
+// such numbers, and missing ones, are skipped silently.
 //
 // Parameters:
-// - number: An integer representing the unique identifier of the parcel.
+// - ctx: the context controlling cancellation of the query.
+// - numbers: the numbers of the parcels to delete.
 //
 // Returns:
-// - An error if the deletion fails; otherwise, it returns nil.
-func (s ParcelService) Delete(number int) error {
-	return s.store.Delete(number)
-}
+// - The number of registered parcels deleted; 0 for no numbers.
+// - An error, if any occurs during the deletion operation.
+func (s ParcelStore) DeleteManyRegistered(ctx context.Context, numbers []int64) (deleted int64, err error) {
+	ctx, span := s.startSpan(ctx, "DeleteManyRegistered")
+	defer s.observe(span, "DeleteManyRegistered", &err)
 
-// ParcelStore is a struct that represents the storage layer for parcels.
-//
-// It encapsulates a connection to the database, allowing for operations
-// related to parcel records to be conducted. The `db` field is a pointer
-// to an sql.DB instance, which is used to interact with the underlying
-// database for adding, retrieving, and managing parcel data.
-type ParcelStore struct {
-	// db is a pointer to the SQL database connection.
-	db *sql.DB
+	if len(numbers) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(numbers)-1) + "?"
+
+	args := make([]any, 0, len(numbers)+1)
+	for _, number := range numbers {
+		args = append(args, number)
+	}
+	args = append(args, ParcelStatusRegistered)
+
+	result, err := s.executor().ExecContext(ctx, s.dialect.Rebind("DELETE FROM "+s.table.String()+" WHERE number IN ("+placeholders+") AND status = ?"), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
 
-// NewParcelStore creates a new ParcelStore instance.
-//
-// This function initializes a new ParcelStore using the provided
-// database connection. It returns a ParcelStore that can be used
-// for operations on parcels.
+// SoftDelete hides a parcel from reads by setting its deleted_at
+// timestamp instead of removing the row. It can be undone with Restore.
 //
 // Parameters:
-//   - db: A pointer to an sql.DB instance, representing the database
-//     connection to be used by the ParcelStore.
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel to be soft-deleted.
 //
 // Returns:
-// - A new instance of ParcelStore.
-func NewParcelStore(db *sql.DB) ParcelStore {
-	return ParcelStore{db: db}
+// - ErrParcelNotFound if no parcel that is not already deleted has the given number.
+// - An error, if any occurs during the update operation.
+func (s ParcelStore) SoftDelete(ctx context.Context, number int) (err error) {
+	ctx, span := s.startSpan(ctx, "SoftDelete", attrNumber(number))
+	defer s.observe(span, "SoftDelete", &err)
+
+	now := time.Now().UTC()
+
+	result, err := s.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET deleted_at = ?, updated_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL"), now, now, number)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
 }
 
-// Add inserts a new parcel into the database and returns the newly created parcel's ID.
+// Restore makes a soft-deleted parcel visible to reads again.
 //
 // Parameters:
-// - p: the Parcel object containing the details of the parcel to be added.
+// - ctx: the context controlling cancellation of the query.
+// - number: the unique number of the parcel to be restored.
 //
 // Returns:
-// - The ID of the last inserted Parcel.
-// - An error, if any occurs during the insert operation.
-func (s ParcelStore) Add(p *Parcel) error {
-	if p == nil {
-		return errors.New("gotten pointer is equal to nil")
-	}
+// - ErrParcelNotFound if no soft-deleted parcel has the given number.
+// - An error, if any occurs during the update operation.
+func (s ParcelStore) Restore(ctx context.Context, number int) (err error) {
+	ctx, span := s.startSpan(ctx, "Restore", attrNumber(number))
+	defer s.observe(span, "Restore", &err)
 
-	result, err := s.db.Exec("INSERT INTO parcel (client, status, address, created_at) VALUES (?, ?, ?, ?)", p.Client, p.Status, p.Address, p.CreatedAt)
+	result, err := s.executor().ExecContext(ctx, s.dialect.Rebind("UPDATE "+s.table.String()+" SET deleted_at = NULL, updated_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NOT NULL"), time.Now().UTC(), number)
 	if err != nil {
 		return err
 	}
 
-	lastParcelID, err := result.LastInsertId()
+	return requireAffected(result)
+}
+
+// requireAffected returns ErrParcelNotFound if the statement behind
+// result did not change any row.
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
-	p.Number = lastParcelID
+	if affected == 0 {
+		return ErrParcelNotFound
+	}
 
 	return nil
 }
 
-// Get retrieves a parcel from the database by its number.
-//
-// Parameters:
-// - number: the unique number of the parcel to retrieve.
-//
-// Returns:
-// - The Parcel object corresponding to the given number.
-// - An error, if any occurs during the retrieval operation.
-func (s ParcelStore) Get(number int) (Parcel, error) {
-	row := s.db.QueryRow("SELECT number, client, status, address, created_at FROM parcel WHERE id = ?", number)
-
-	gottenParcel := Parcel{}
+// requireVersion checks the result of an update conditional on the
+// parcel version. If no row changed, it tells a missing parcel
+// (ErrParcelNotFound) from a stale version (ErrVersionConflict).
+func (s ParcelStore) requireVersion(ctx context.Context, result sql.Result, number int) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
 
-	err := row.Scan(&gottenParcel.Number, &gottenParcel.Client, &gottenParcel.Status, &gottenParcel.Address, &gottenParcel.CreatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		return Parcel{}, nil
+	if affected > 0 {
+		return nil
 	}
 
+	var exists int
+
+	err = s.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT COUNT(*) FROM "+s.table.String()+" WHERE number = ?"), number).Scan(&exists)
 	if err != nil {
-		return Parcel{}, err
+		return err
 	}
 
-	return gottenParcel, nil
+	if exists == 0 {
+		return ErrParcelNotFound
+	}
+
+	return ErrVersionConflict
 }
 
-// GetByClient retrieves a list of parcels associated with a specific client.
+// parcelStatusReserved marks the placeholder rows ReserveNumbers inserts
+// to draw numbers from the parcel sequence. Such rows never outlive the
+// reserving transaction.
+const parcelStatusReserved ParcelStatus = "reserved"
+
+// ReserveNumbers atomically allocates n parcel numbers for offline
+// registration.
+//
+// Numbers are drawn from the same sequence Add uses by inserting and
+// then removing placeholder rows inside a single transaction, so they
+// are never handed out again. The reserved numbers are recorded in the
+// parcel_reservation table until a parcel is inserted with them.
 //
 // Parameters:
-// - client: the unique identifier of the client whose parcels are to be retrieved.
+// - ctx: the context controlling cancellation of the transaction.
+// - n: how many numbers to reserve; must be positive.
 //
 // Returns:
-// - A slice of Parcel objects corresponding to the given client.
-// - An error, if any occurs during the retrieval operation.
-func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
-	rows, err := s.db.Query("SELECT number, client, status, address, created_at FROM percel WHERE client = ?", client)
-	if err != nil {
-		return nil, err
+// - The reserved numbers in ascending order.
+// - An error, if n is not positive or the transaction fails.
+func (s ParcelStore) ReserveNumbers(ctx context.Context, n int) (numbers []int64, err error) {
+	ctx, span := s.startSpan(ctx, "ReserveNumbers")
+	defer s.observe(span, "ReserveNumbers", &err)
+
+	if n <= 0 {
+		return nil, ErrInvalidReserveCount
 	}
-	defer func() {
-		_ = rows.Close()
-	}()
 
-	var parcels []Parcel
-	for rows.Next() {
-		var newParcel Parcel
+	err = s.WithTx(ctx, func(tx ParcelStore) error {
+		query := "INSERT INTO " + s.table.String() + " (client, status, address, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
+		now := time.Now().UTC()
 
-		err = rows.Scan(&newParcel.Number, &newParcel.Client, &newParcel.Status, &newParcel.Address, &newParcel.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
+		numbers = make([]int64, 0, n)
+		for i := 0; i < n; i++ {
+			number, err := tx.insertReturningNumber(ctx, query, 0, parcelStatusReserved, "", now, now)
+			if err != nil {
+				return err
+			}
 
-		parcels = append(parcels, newParcel)
-	}
+			_, err = tx.executor().ExecContext(ctx, s.dialect.Rebind("INSERT INTO "+s.table.reservation()+" (number, reserved_at) VALUES (?, ?)"), number, now)
+			if err != nil {
+				return err
+			}
 
-	if err = rows.Err(); err != nil {
+			numbers = append(numbers, number)
+		}
+
+		_, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("DELETE FROM "+s.table.String()+" WHERE status = ?"), parcelStatusReserved)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return parcels, nil
+	return numbers, nil
 }
 
-// SetStatus updates the status of a parcel identified by its number.
-//
-// Parameters:
-// - number: the unique number of the parcel to be updated.
-// - status: the new status to set for the parcel.
+// InsertWithNumber stores an offline-registered parcel under the number
+// that was previously handed out by ReserveNumbers.
 //
-// Returns:
-// - An error, if any occurs during the update operation.
-func (s ParcelStore) SetStatus(number int, status string) error {
-	_, err := s.db.Exec("UPDATE parcel SET status = ? WHERE number = ?", status, number)
-	return err
-}
-
-// SetAddress updates the address of a parcel identified by its number.
+// The parcel keeps its own Number and CreatedAt. The reservation is
+// consumed in the same transaction as the insert, so a number can only
+// be used once.
 //
 // Parameters:
-// - number: the unique number of the parcel to be updated.
-// - address: the new address to set for the parcel.
+// - ctx: the context controlling cancellation of the transaction.
+// - p: the parcel to insert, carrying its pre-assigned number.
 //
 // Returns:
-// - An error, if any occurs during the update operation.
-func (s ParcelStore) SetAddress(number int, address string) error {
-	_, err := s.db.Exec("UPDATE parcel SET address = ? WHERE number = ?", address, number)
-	return err
-}
+//   - An error wrapping ErrParcelNumberConflict if the number was not
+//     reserved or is already used, ErrInvalidStatus for an unknown
+//     status, or any error from the transaction.
+func (s ParcelStore) InsertWithNumber(ctx context.Context, p Parcel) (err error) {
+	ctx, span := s.startSpan(ctx, "InsertWithNumber", attrNumber(int(p.Number)), attrStatus(p.Status))
+	defer s.observe(span, "InsertWithNumber", &err)
 
-// Delete removes a parcel from the database identified by its number.
-// The parcel will only be deleted if its status is 'registered'.
-//
-// Parameters:
-// - number: the unique number of the parcel to be deleted.
-//
-// Returns:
-// - An error, if any occurs during the deletion operation.
-func (s ParcelStore) Delete(number int) error {
-	_, err := s.db.Exec("DELETE FROM parcel WHERE number = ? AND status = registered", number)
-	return err
+	if !p.Status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, p.Status)
+	}
+
+	if err = p.validateDimensions(); err != nil {
+		return err
+	}
+
+	if err = p.validatePriority(); err != nil {
+		return err
+	}
+
+	metadata, err := encodeMetadata(p.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return s.WithTx(ctx, func(tx ParcelStore) error {
+		var used int
+
+		err := tx.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT COUNT(*) FROM "+s.table.String()+" WHERE number = ?"), p.Number).Scan(&used)
+		if err != nil {
+			return err
+		}
+
+		if used > 0 {
+			return fmt.Errorf("%w: number %d is already used", ErrParcelNumberConflict, p.Number)
+		}
+
+		result, err := tx.executor().ExecContext(ctx, s.dialect.Rebind("DELETE FROM "+s.table.reservation()+" WHERE number = ?"), p.Number)
+		if err != nil {
+			return err
+		}
+
+		reserved, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if reserved == 0 {
+			return fmt.Errorf("%w: number %d was not reserved", ErrParcelNumberConflict, p.Number)
+		}
+
+		_, err = tx.executor().ExecContext(ctx, s.dialect.Rebind("INSERT INTO "+s.table.String()+" (number, client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, tracking_code, priority, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			p.Number, p.Client, p.Status, p.Address, p.CreatedAt, time.Now().UTC(), p.RegisteredBy, nullString(p.ExternalRef), p.WeightGrams, p.LengthMM, p.WidthMM, p.HeightMM,
+			TrackingCode(p.Number), p.Priority, metadata)
+		return err
+	})
 }
@@ -1,16 +1,52 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// parcelRows returns sqlmock rows with the columns the store selects,
+// filled from the given parcels.
+func parcelRows(parcels ...Parcel) *sqlmock.Rows {
+	rows := sqlmock.NewRows(strings.Split(parcelColumns, ", "))
+	for _, p := range parcels {
+		rows.AddRow(parcelValues(p)...)
+	}
+	return rows
+}
+
+// parcelValues returns the column values of p in parcelColumns order.
+func parcelValues(p Parcel) []driver.Value {
+	var externalRef, trackingCode, metadata driver.Value
+	if p.ExternalRef != "" {
+		externalRef = p.ExternalRef
+	}
+	if p.TrackingCode != "" {
+		trackingCode = p.TrackingCode
+	}
+	if encoded, _ := encodeMetadata(p.Metadata); encoded.Valid {
+		metadata = encoded.String
+	}
+
+	return []driver.Value{p.Number, p.Client, p.Status, p.Address, p.CreatedAt, p.UpdatedAt, p.DeletedAt, p.RegisteredBy, externalRef, int64(p.Version),
+		int64(p.WeightGrams), int64(p.LengthMM), int64(p.WidthMM), int64(p.HeightMM), trackingCode, p.SentAt, p.DeliveredAt, int64(p.Priority), metadata}
+}
+
 func TestAdd(t *testing.T) {
 	t.Parallel()
 
@@ -19,11 +55,11 @@ func TestAdd(t *testing.T) {
 	}
 
 	var (
-		number    int64  = 101
-		client    int64  = 102
-		address   string = "test address"
-		status    string = ParcelStatusRegistered
-		createdAt string = "test time"
+		number    int64        = 101
+		client    int64        = 102
+		address   string       = "test address"
+		status    ParcelStatus = ParcelStatusRegistered
+		createdAt              = time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
 	)
 
 	tests := []struct {
@@ -36,10 +72,16 @@ func TestAdd(t *testing.T) {
 		{
 			name: "success",
 			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
 				dbMock.
 					ExpectExec("INSERT INTO parcel").
-					WithArgs(client, status, address, createdAt).
+					WithArgs(client, status, address, createdAt, sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
 					WillReturnResult(sqlmock.NewResult(number, 1))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET tracking_code = ? WHERE number = ?")).
+					WithArgs(TrackingCode(number), number).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
 			},
 			args: args{
 				parcel: &Parcel{
@@ -58,16 +100,19 @@ func TestAdd(t *testing.T) {
 				assert.Equal(t, address, parcel.Address, i...)
 				assert.Equal(t, status, parcel.Status, i...)
 				assert.Equal(t, createdAt, parcel.CreatedAt, i...)
+				assert.Equal(t, TrackingCode(number), parcel.TrackingCode, i...)
 			},
 			wantErr: require.NoError,
 		},
 		{
 			name: "database error",
 			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
 				dbMock.
 					ExpectExec("INSERT INTO parcel").
-					WithArgs(client, status, address, createdAt).
+					WithArgs(client, status, address, createdAt, sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
 					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
 			},
 			args: args{
 				parcel: &Parcel{
@@ -88,7 +133,7 @@ func TestAdd(t *testing.T) {
 				require.Equal(t, createdAt, parcel.CreatedAt, i...)
 			},
 			wantErr: func(t require.TestingT, err error, i ...interface{}) {
-				require.EqualError(t, err, "database error", i...)
+				require.EqualError(t, err, "parcelstore.Add: database error", i...)
 			},
 		},
 		{
@@ -99,7 +144,28 @@ func TestAdd(t *testing.T) {
 			},
 			wantParcel: require.Nil,
 			wantErr: func(t require.TestingT, err error, i ...interface{}) {
-				require.EqualError(t, err, "gotten pointer is equal to nil", i...)
+				require.ErrorIs(t, err, ErrNilParcel, i...)
+				require.EqualError(t, err, "parcelstore.Add: parcel must not be nil", i...)
+			},
+		},
+		{
+			name:  "invalid status",
+			mocks: func(dbMock sqlmock.Sqlmock) {},
+			args: args{
+				parcel: &Parcel{
+					Client:    client,
+					Address:   address,
+					Status:    "Delivered",
+					CreatedAt: createdAt,
+				},
+			},
+			wantParcel: func(tt require.TestingT, got interface{}, i ...interface{}) {
+				parcel, ok := got.(*Parcel)
+				require.True(tt, ok)
+				require.Equal(tt, int64(0), parcel.Number, i...)
+			},
+			wantErr: func(t require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(t, err, ErrInvalidStatus, i...)
 			},
 		},
 	}
@@ -114,7 +180,7 @@ func TestAdd(t *testing.T) {
 			store := NewParcelStore(db)
 			tt.mocks(dbMock)
 
-			err = store.Add(tt.args.parcel)
+			err = store.Add(context.Background(), tt.args.parcel)
 			tt.wantErr(t, err)
 			tt.wantParcel(t, tt.args.parcel)
 
@@ -127,11 +193,11 @@ func TestGet(t *testing.T) {
 	t.Parallel()
 
 	var (
-		number    int    = 101
-		client    string = "Test Client"
-		address   string = "Test Address"
-		status    string = "Registered"
-		createdAt string = "2023-11-20T10:00:00Z"
+		number    int          = 101
+		client    int64        = 102
+		address   string       = "Test Address"
+		status    ParcelStatus = ParcelStatusRegistered
+		createdAt              = time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
 	)
 
 	tests := []struct {
@@ -144,9 +210,8 @@ func TestGet(t *testing.T) {
 		{
 			name: "success",
 			mocks: func(dbMock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"number", "client", "status", "address", "created_at"}).
-					AddRow(number, client, status, address, createdAt)
-				dbMock.ExpectQuery("SELECT number, client, status, address, created_at FROM parcel WHERE id = ?").
+				rows := parcelRows(Parcel{Number: int64(number), Client: client, Status: status, Address: address, CreatedAt: createdAt})
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE number = ? AND deleted_at IS NULL")).
 					WithArgs(number).
 					WillReturnRows(rows)
 			},
@@ -154,7 +219,7 @@ func TestGet(t *testing.T) {
 			wantParcel: func(tt require.TestingT, got interface{}, i ...interface{}) {
 				parcel, ok := got.(Parcel)
 				require.True(t, ok)
-				assert.Equal(t, number, parcel.Number)
+				assert.Equal(t, int64(number), parcel.Number)
 				assert.Equal(t, client, parcel.Client)
 				assert.Equal(t, address, parcel.Address)
 				assert.Equal(t, status, parcel.Status)
@@ -165,7 +230,7 @@ func TestGet(t *testing.T) {
 		{
 			name: "no rows",
 			mocks: func(dbMock sqlmock.Sqlmock) {
-				dbMock.ExpectQuery("SELECT number, client, status, address, created_at FROM parcel WHERE id = ?").
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE number = ? AND deleted_at IS NULL")).
 					WithArgs(number).
 					WillReturnError(sql.ErrNoRows)
 			},
@@ -175,12 +240,14 @@ func TestGet(t *testing.T) {
 				require.True(t, ok)
 				require.Equal(t, Parcel{}, parcel)
 			},
-			wantErr: require.NoError,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
 		},
 		{
 			name: "database error",
 			mocks: func(dbMock sqlmock.Sqlmock) {
-				dbMock.ExpectQuery("SELECT number, client, status, address, created_at FROM parcel WHERE id = ?").
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE number = ? AND deleted_at IS NULL")).
 					WithArgs(number).
 					WillReturnError(errors.New("database error"))
 			},
@@ -191,7 +258,7 @@ func TestGet(t *testing.T) {
 				require.Equal(t, Parcel{}, parcel)
 			},
 			wantErr: func(t require.TestingT, err error, i ...interface{}) {
-				require.EqualError(t, err, "database error")
+				require.EqualError(t, err, "parcelstore.Get: database error")
 			},
 		},
 	}
@@ -206,7 +273,7 @@ func TestGet(t *testing.T) {
 			store := ParcelStore{db: db}
 			tt.mocks(dbMock)
 
-			parcel, err := store.Get(tt.number)
+			parcel, err := store.Get(context.Background(), tt.number)
 			tt.wantErr(t, err)
 			tt.wantParcel(t, parcel)
 
@@ -215,6 +282,91 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestExists(t *testing.T) {
+	t.Parallel()
+
+	const selectExists = "SELECT EXISTS(SELECT 1 FROM parcel WHERE number = ? AND deleted_at IS NULL)"
+
+	tests := []struct {
+		name       string
+		mocks      func(dbMock sqlmock.Sqlmock)
+		wantExists bool
+		wantErr    require.ErrorAssertionFunc
+	}{
+		{
+			name: "existing parcel",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectExists)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			wantExists: true,
+			wantErr:    require.NoError,
+		},
+		{
+			name: "missing parcel",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectExists)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+			wantExists: false,
+			wantErr:    require.NoError,
+		},
+		{
+			name: "database error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectExists)).
+					WithArgs(101).
+					WillReturnError(errors.New("database error"))
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.Exists: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			exists, err := NewParcelStore(db).Exists(context.Background(), 101)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantExists, exists)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestExistsSQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	existing := addParcel(t, store, 1000, createdAt)
+	deleted := addParcel(t, store, 1000, createdAt)
+	require.NoError(t, store.SoftDelete(ctx, int(deleted.Number)))
+
+	exists, err := store.Exists(ctx, int(existing.Number))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	for _, number := range []int64{deleted.Number, 999} {
+		exists, err = store.Exists(ctx, int(number))
+		require.NoError(t, err)
+		require.False(t, exists)
+	}
+}
+
 func TestGetByClient(t *testing.T) {
 	t.Parallel()
 
@@ -235,10 +387,11 @@ func TestGetByClient(t *testing.T) {
 				client: 102,
 			},
 			mocks: func(dbMock sqlmock.Sqlmock, client int) {
-				rows := sqlmock.NewRows([]string{"number", "client", "status", "address", "created_at"}).
-					AddRow(101, 102, "Registered", "Address 1", "2023-11-20T10:00:00Z").
-					AddRow(102, 102, "Delivered", "Address 2", "2023-11-21T11:00:00Z")
-				dbMock.ExpectQuery("SELECT number, client, status, address, created_at FROM percel WHERE client = ?").
+				rows := parcelRows(
+					Parcel{Number: 101, Client: 102, Status: ParcelStatusRegistered, Address: "Address 1", CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)},
+					Parcel{Number: 102, Client: 102, Status: ParcelStatusDelivered, Address: "Address 2", CreatedAt: time.Date(2023, 11, 21, 11, 0, 0, 0, time.UTC)},
+				)
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number")).
 					WithArgs(client).
 					WillReturnRows(rows)
 			},
@@ -246,28 +399,72 @@ func TestGetByClient(t *testing.T) {
 				parcels, ok := got.([]Parcel)
 				require.True(tt, ok)
 				assert.Len(tt, parcels, 2)
-				assert.Equal(tt, 101, parcels[0].Number)
-				assert.Equal(tt, 102, parcels[0].Client)
-				assert.Equal(tt, "Registered", parcels[0].Status)
+				assert.Equal(tt, int64(101), parcels[0].Number)
+				assert.Equal(tt, int64(102), parcels[0].Client)
+				assert.Equal(tt, ParcelStatusRegistered, parcels[0].Status)
 				assert.Equal(tt, "Address 1", parcels[0].Address)
-				assert.Equal(tt, "2023-11-20T10:00:00Z", parcels[0].CreatedAt)
+				assert.Equal(tt, time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC), parcels[0].CreatedAt)
 
-				assert.Equal(tt, 102, parcels[1].Number)
-				assert.Equal(tt, 102, parcels[1].Client)
-				assert.Equal(tt, "Delivered", parcels[1].Status)
+				assert.Equal(tt, int64(102), parcels[1].Number)
+				assert.Equal(tt, int64(102), parcels[1].Client)
+				assert.Equal(tt, ParcelStatusDelivered, parcels[1].Status)
 				assert.Equal(tt, "Address 2", parcels[1].Address)
-				assert.Equal(tt, "2023-11-21T11:00:00Z", parcels[1].CreatedAt)
+				assert.Equal(tt, time.Date(2023, 11, 21, 11, 0, 0, 0, time.UTC), parcels[1].CreatedAt)
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "null address",
+			args: args{
+				client: 102,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, client int) {
+				values := parcelValues(Parcel{Number: 101, Client: 102, Status: ParcelStatusRegistered, CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)})
+				values[3] = nil
+				rows := parcelRows().AddRow(values...)
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number")).
+					WithArgs(client).
+					WillReturnRows(rows)
+			},
+			wantParcels: func(tt require.TestingT, got interface{}, i ...interface{}) {
+				parcels, ok := got.([]Parcel)
+				require.True(tt, ok)
+				require.Len(tt, parcels, 1)
+				assert.Equal(tt, int64(101), parcels[0].Number)
+				assert.Equal(tt, ParcelStatusRegistered, parcels[0].Status)
+				assert.Empty(tt, parcels[0].Address)
 			},
 			wantErr: require.NoError,
 		},
+		{
+			name: "scan error names the parcel",
+			args: args{
+				client: 102,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, client int) {
+				values := parcelValues(Parcel{Number: 101, Client: 102, Status: ParcelStatusRegistered, Address: "Address 1"})
+				values[4] = "not a time"
+				rows := parcelRows().AddRow(values...)
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number")).
+					WithArgs(client).
+					WillReturnRows(rows)
+			},
+			wantParcels: func(tt require.TestingT, got interface{}, i ...interface{}) {
+				require.Nil(tt, got)
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorContains(tt, err, "parcel 101: ")
+				require.ErrorContains(tt, err, "created_at")
+			},
+		},
 		{
 			name: "no records",
 			args: args{
 				client: 103,
 			},
 			mocks: func(dbMock sqlmock.Sqlmock, client int) {
-				rows := sqlmock.NewRows([]string{"number", "client", "status", "address", "created_at"})
-				dbMock.ExpectQuery("SELECT number, client, status, address, created_at FROM percel WHERE client = ?").
+				rows := parcelRows()
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number")).
 					WithArgs(client).
 					WillReturnRows(rows)
 			},
@@ -284,7 +481,7 @@ func TestGetByClient(t *testing.T) {
 				client: 104,
 			},
 			mocks: func(dbMock sqlmock.Sqlmock, client int) {
-				dbMock.ExpectQuery("SELECT number, client, status, address, created_at FROM percel WHERE client = ?").
+				dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number")).
 					WithArgs(client).
 					WillReturnError(errors.New("database error"))
 			},
@@ -292,7 +489,7 @@ func TestGetByClient(t *testing.T) {
 				require.Nil(tt, got)
 			},
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.EqualError(tt, err, "database error")
+				require.EqualError(tt, err, "parcelstore.GetByClient: database error")
 			},
 		},
 	}
@@ -307,7 +504,7 @@ func TestGetByClient(t *testing.T) {
 			store := ParcelStore{db: db}
 			tt.mocks(dbMock, tt.args.client)
 
-			parcels, err := store.GetByClient(tt.args.client)
+			parcels, err := store.GetByClient(context.Background(), tt.args.client)
 			tt.wantErr(t, err)
 			tt.wantParcels(t, parcels)
 
@@ -316,144 +513,273 @@ func TestGetByClient(t *testing.T) {
 	}
 }
 
-func TestSetStatus(t *testing.T) {
+func TestGetByClientOrder(t *testing.T) {
 	t.Parallel()
 
-	type args struct {
-		number int
-		status string
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	// Insert the parcels out of number order, with the highest number
+	// created first.
+	for i, number := range []int64{30, 10, 20, 40} {
+		_, err := db.ExecContext(ctx, "INSERT INTO parcel (number, client, status, address, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+			number, 1000, ParcelStatusRegistered, "test address", createdAt.Add(-time.Duration(i)*time.Hour), createdAt)
+		require.NoError(t, err)
+	}
+
+	parcels, err := store.GetByClient(ctx, 1000)
+	require.NoError(t, err)
+
+	numbers := make([]int64, 0, len(parcels))
+	for _, parcel := range parcels {
+		numbers = append(numbers, parcel.Number)
+	}
+	require.Equal(t, []int64{10, 20, 30, 40}, numbers)
+}
+
+func TestGetByClientInto(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	numbers := seedParcels(t, store, 1000, 5)
+	seedParcels(t, store, 2000, 2)
+
+	t.Run("nil slice", func(t *testing.T) {
+		var parcels []Parcel
+		require.NoError(t, store.GetByClientInto(ctx, 1000, &parcels))
+		require.Equal(t, numbers, parcelNumbers(parcels))
+	})
+
+	t.Run("preallocated with the count", func(t *testing.T) {
+		count, err := store.CountByClient(ctx, 1000)
+		require.NoError(t, err)
+
+		parcels := make([]Parcel, 0, count)
+		backing := &parcels[:1][0]
+
+		require.NoError(t, store.GetByClientInto(ctx, 1000, &parcels))
+		require.Equal(t, numbers, parcelNumbers(parcels))
+		require.Same(t, backing, &parcels[0], "the backing array must be reused")
+	})
+
+	t.Run("reused slice is truncated", func(t *testing.T) {
+		parcels := make([]Parcel, 10)
+		backing := &parcels[0]
+
+		require.NoError(t, store.GetByClientInto(ctx, 2000, &parcels))
+		require.Len(t, parcels, 2)
+		require.Equal(t, int64(2000), parcels[0].Client)
+		require.Same(t, backing, &parcels[0])
+
+		require.NoError(t, store.GetByClientInto(ctx, 3000, &parcels))
+		require.Empty(t, parcels)
+	})
+
+	t.Run("nil destination", func(t *testing.T) {
+		require.ErrorIs(t, store.GetByClientInto(ctx, 1000, nil), ErrNilDestination)
+	})
+}
+
+func TestGetByClientIntoError(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows := parcelRows(Parcel{Number: 101, Client: 1000, Status: ParcelStatusRegistered, Address: "test address"}).
+		RowError(0, errors.New("row error"))
+	dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number")).
+		WithArgs(1000).
+		WillReturnRows(rows)
+
+	parcels := []Parcel{{Number: 1}, {Number: 2}}
+	err = NewParcelStore(db).GetByClientInto(context.Background(), 1000, &parcels)
+	require.EqualError(t, err, "parcelstore.GetByClientInto: row error")
+	require.Empty(t, parcels)
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func BenchmarkGetByClient(b *testing.B) {
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(b))
+	seedParcels(b, store, 1000, 1000)
+
+	b.Run("GetByClient", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := store.GetByClient(ctx, 1000); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("GetByClientInto counted", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			count, err := store.CountByClient(ctx, 1000)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			parcels := make([]Parcel, 0, count)
+			if err = store.GetByClientInto(ctx, 1000, &parcels); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("GetByClientInto reused", func(b *testing.B) {
+		var parcels []Parcel
+
+		b.ReportAllocs()
+		for range b.N {
+			if err := store.GetByClientInto(ctx, 1000, &parcels); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestGetByClientByPriority(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	add := func(priority int, created time.Time) int64 {
+		parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: created, Priority: priority}
+		require.NoError(t, store.Add(ctx, &parcel))
+		return parcel.Number
+	}
+
+	standardOld := add(0, createdAt.Add(-2*time.Hour))
+	express := add(5, createdAt)
+	standardNew := add(0, createdAt)
+	urgent := add(MaxParcelPriority, createdAt.Add(time.Hour))
+	expressOld := add(5, createdAt.Add(-time.Hour))
+
+	parcels, err := store.GetByClientByPriority(ctx, 1000)
+	require.NoError(t, err)
+
+	numbers := make([]int64, 0, len(parcels))
+	for _, parcel := range parcels {
+		numbers = append(numbers, parcel.Number)
 	}
+	require.Equal(t, []int64{urgent, expressOld, express, standardOld, standardNew}, numbers)
+	require.Equal(t, MaxParcelPriority, parcels[0].Priority)
+}
+
+func TestAddPriority(t *testing.T) {
+	t.Parallel()
 
 	tests := []struct {
-		name    string
-		mocks   func(dbMock sqlmock.Sqlmock, number int, status string)
-		args    args
-		wantErr require.ErrorAssertionFunc
+		name     string
+		priority int
+		wantErr  require.ErrorAssertionFunc
 	}{
+		{name: "default", priority: 0, wantErr: require.NoError},
+		{name: "highest", priority: MaxParcelPriority, wantErr: require.NoError},
 		{
-			name: "success",
-			args: args{
-				number: 101,
-				status: "Delivered",
-			},
-			mocks: func(dbMock sqlmock.Sqlmock, number int, status string) {
-				dbMock.
-					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET status = ? WHERE number = ?")).
-					WithArgs(status, number).
-					WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
-			},
-			wantErr: require.NoError,
-		},
-		{
-			name: "no rows affected",
-			args: args{
-				number: 999,
-				status: "Delivered",
-			},
-			mocks: func(dbMock sqlmock.Sqlmock, number int, status string) {
-				dbMock.
-					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET status = ? WHERE number = ?")).
-					WithArgs(status, number).
-					WillReturnResult(sqlmock.NewResult(0, 0)) // No rows affected
-			},
+			name:     "below range",
+			priority: MinParcelPriority - 1,
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.NoError(tt, err, i...)
+				require.ErrorIs(tt, err, ErrInvalidPriority, i...)
 			},
 		},
 		{
-			name: "database error",
-			args: args{
-				number: 101,
-				status: "Delivered",
-			},
-			mocks: func(dbMock sqlmock.Sqlmock, number int, status string) {
-				dbMock.
-					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET status = ? WHERE number = ?")).
-					WithArgs(status, number).
-					WillReturnError(errors.New("database error"))
-			},
+			name:     "above range",
+			priority: MaxParcelPriority + 1,
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.EqualError(tt, err, "database error", i...)
+				require.ErrorIs(tt, err, ErrInvalidPriority, i...)
 			},
 		},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-
-			db, dbMock, err := sqlmock.New()
-			require.NoError(t, err)
+		for name, repo := range repositories(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
 
-			store := ParcelStore{db: db}
-			tt.mocks(dbMock, tt.args.number, tt.args.status)
+				parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: time.Now().UTC(), Priority: tt.priority}
+				err := repo.Add(ctx, &parcel)
+				tt.wantErr(t, err)
 
-			err = store.SetStatus(tt.args.number, tt.args.status)
-			tt.wantErr(t, err)
+				if err != nil {
+					require.Zero(t, parcel.Number)
+					return
+				}
 
-			require.NoError(t, dbMock.ExpectationsWereMet())
-		})
+				got, err := repo.Get(ctx, int(parcel.Number))
+				require.NoError(t, err)
+				require.Equal(t, tt.priority, got.Priority)
+			})
+		}
 	}
 }
 
-func TestSetAddress(t *testing.T) {
+func TestParcelValidate(t *testing.T) {
 	t.Parallel()
 
-	type args struct {
-		number  int
-		address string
-	}
+	valid := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address"}
 
 	tests := []struct {
 		name    string
-		mocks   func(dbMock sqlmock.Sqlmock)
-		args    args
+		parcel  func() *Parcel
 		wantErr require.ErrorAssertionFunc
 	}{
 		{
-			name: "success",
-			mocks: func(dbMock sqlmock.Sqlmock) {
-				dbMock.
-					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ? WHERE number = ?")).
-					WithArgs("new address", 101).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-			},
-			args: args{
-				number:  101,
-				address: "new address",
-			},
+			name:    "valid",
+			parcel:  func() *Parcel { p := valid; return &p },
 			wantErr: require.NoError,
 		},
 		{
-			name: "database error",
-			mocks: func(dbMock sqlmock.Sqlmock) {
-				dbMock.
-					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ? WHERE number = ?")).
-					WithArgs("new address", 101).
-					WillReturnError(errors.New("database error"))
-			},
-			args: args{
-				number:  101,
-				address: "new address",
+			name:   "nil",
+			parcel: func() *Parcel { return nil },
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrNilParcel, i...)
 			},
+		},
+		{
+			name:   "non-positive client",
+			parcel: func() *Parcel { p := valid; p.Client = 0; return &p },
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.EqualError(tt, err, "database error", i...)
+				require.ErrorIs(tt, err, ErrInvalidClient, i...)
+				require.NotErrorIs(tt, err, ErrEmptyAddress, i...)
+				require.NotErrorIs(tt, err, ErrInvalidStatus, i...)
 			},
 		},
 		{
-			name: "no rows affected",
-			mocks: func(dbMock sqlmock.Sqlmock) {
-				dbMock.
-					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ? WHERE number = ?")).
-					WithArgs("new address", 999).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+			name:   "blank address",
+			parcel: func() *Parcel { p := valid; p.Address = " \t "; return &p },
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrEmptyAddress, i...)
+				require.NotErrorIs(tt, err, ErrInvalidClient, i...)
+				require.NotErrorIs(tt, err, ErrInvalidStatus, i...)
 			},
-			args: args{
-				number:  999,
-				address: "new address",
+		},
+		{
+			name:   "unknown status",
+			parcel: func() *Parcel { p := valid; p.Status = "lost"; return &p },
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+				require.NotErrorIs(tt, err, ErrInvalidClient, i...)
+				require.NotErrorIs(tt, err, ErrEmptyAddress, i...)
 			},
+		},
+		{
+			name:   "every check fails",
+			parcel: func() *Parcel { return &Parcel{Client: -1, Status: "lost", Priority: MaxParcelPriority + 1} },
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.NoError(tt, err, i...)
+				require.ErrorIs(tt, err, ErrInvalidClient, i...)
+				require.ErrorIs(tt, err, ErrEmptyAddress, i...)
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+				require.ErrorIs(tt, err, ErrInvalidPriority, i...)
 			},
 		},
 	}
@@ -462,31 +788,226 @@ func TestSetAddress(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			db, dbMock, err := sqlmock.New()
-			require.NoError(t, err)
-			defer db.Close()
+			tt.wantErr(t, tt.parcel().Validate())
+		})
+	}
+}
 
-			store := NewParcelStore(db)
-			tt.mocks(dbMock)
+func TestAddValidates(t *testing.T) {
+	t.Parallel()
 
-			err = store.SetAddress(tt.args.number, tt.args.address)
-			tt.wantErr(t, err)
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
 
-			require.NoError(t, dbMock.ExpectationsWereMet())
+			parcel := Parcel{Status: ParcelStatusRegistered, CreatedAt: time.Now().UTC()}
+			err := repo.Add(context.Background(), &parcel)
+			require.ErrorIs(t, err, ErrInvalidClient)
+			require.ErrorIs(t, err, ErrEmptyAddress)
+			require.Zero(t, parcel.Number)
+
+			_, err = repo.Get(context.Background(), 1)
+			require.ErrorIs(t, err, ErrParcelNotFound)
 		})
 	}
 }
 
-func TestDelete(t *testing.T) {
+func TestSetStatus(t *testing.T) {
 	t.Parallel()
 
 	type args struct {
 		number int
+		status ParcelStatus
 	}
 
-	tests := []struct {
-		name    string
-		mocks   func(dbMock sqlmock.Sqlmock)
+	const (
+		version       = 3
+		selectStatus  = "SELECT status FROM parcel WHERE number = ?"
+		updateStatus  = "UPDATE parcel SET status = ?, updated_at = ?, delivered_at = ?, version = version + 1 WHERE number = ? AND version = ?"
+		countParcel   = "SELECT COUNT(*) FROM parcel WHERE number = ?"
+		insertHistory = "INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)"
+	)
+
+	currentStatus := func(dbMock sqlmock.Sqlmock, number int) {
+		dbMock.ExpectBegin()
+		dbMock.
+			ExpectQuery(regexp.QuoteMeta(selectStatus)).
+			WithArgs(number).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusSent))
+	}
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus)
+		args    args
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "success",
+			args: args{
+				number: 101,
+				status: ParcelStatusDelivered,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {
+				currentStatus(dbMock, number)
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateStatus)).
+					WithArgs(status, sqlmock.AnyArg(), sqlmock.AnyArg(), number, version).
+					WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(number, ParcelStatusSent, status, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "not found",
+			args: args{
+				number: 999,
+				status: ParcelStatusDelivered,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(number).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name: "stale version",
+			args: args{
+				number: 101,
+				status: ParcelStatusDelivered,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {
+				currentStatus(dbMock, number)
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateStatus)).
+					WithArgs(status, sqlmock.AnyArg(), sqlmock.AnyArg(), number, version).
+					WillReturnResult(sqlmock.NewResult(0, 0)) // No rows affected
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(countParcel)).
+					WithArgs(number).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrVersionConflict, i...)
+			},
+		},
+		{
+			name: "rows affected error",
+			args: args{
+				number: 101,
+				status: ParcelStatusDelivered,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {
+				currentStatus(dbMock, number)
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateStatus)).
+					WithArgs(status, sqlmock.AnyArg(), sqlmock.AnyArg(), number, version).
+					WillReturnResult(sqlmock.NewErrorResult(errors.New("rows affected error")))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.SetStatus: rows affected error", i...)
+			},
+		},
+		{
+			name: "database error",
+			args: args{
+				number: 101,
+				status: ParcelStatusDelivered,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {
+				currentStatus(dbMock, number)
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateStatus)).
+					WithArgs(status, sqlmock.AnyArg(), sqlmock.AnyArg(), number, version).
+					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.SetStatus: database error", i...)
+			},
+		},
+		{
+			name: "history error rolls back",
+			args: args{
+				number: 101,
+				status: ParcelStatusDelivered,
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {
+				currentStatus(dbMock, number)
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateStatus)).
+					WithArgs(status, sqlmock.AnyArg(), sqlmock.AnyArg(), number, version).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(number, ParcelStatusSent, status, sqlmock.AnyArg()).
+					WillReturnError(errors.New("history error"))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.SetStatus: history error", i...)
+			},
+		},
+		{
+			name: "invalid status",
+			args: args{
+				number: 101,
+				status: "Delivered",
+			},
+			mocks: func(dbMock sqlmock.Sqlmock, number int, status ParcelStatus) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+
+			store := ParcelStore{db: db}
+			tt.mocks(dbMock, tt.args.number, tt.args.status)
+
+			err = store.SetStatus(context.Background(), tt.args.number, tt.args.status, version)
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSetAddress(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		number  int
+		address string
+	}
+
+	const (
+		version       = 3
+		updateAddress = "UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND version = ?"
+		countParcel   = "SELECT COUNT(*) FROM parcel WHERE number = ?"
+	)
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
 		args    args
 		wantErr require.ErrorAssertionFunc
 	}{
@@ -494,8 +1015,128 @@ func TestDelete(t *testing.T) {
 			name: "success",
 			mocks: func(dbMock sqlmock.Sqlmock) {
 				dbMock.
-					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = registered")).
+					ExpectExec(regexp.QuoteMeta(updateAddress)).
+					WithArgs("new address", sqlmock.AnyArg(), 101, version).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			args: args{
+				number:  101,
+				address: "new address",
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "database error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateAddress)).
+					WithArgs("new address", sqlmock.AnyArg(), 101, version).
+					WillReturnError(errors.New("database error"))
+			},
+			args: args{
+				number:  101,
+				address: "new address",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.SetAddress: database error", i...)
+			},
+		},
+		{
+			name: "no rows affected",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateAddress)).
+					WithArgs("new address", sqlmock.AnyArg(), 999, version).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(countParcel)).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			},
+			args: args{
+				number:  999,
+				address: "new address",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name: "stale version",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateAddress)).
+					WithArgs("new address", sqlmock.AnyArg(), 101, version).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(countParcel)).
 					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			},
+			args: args{
+				number:  101,
+				address: "new address",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrVersionConflict, i...)
+			},
+		},
+		{
+			name: "rows affected error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(updateAddress)).
+					WithArgs("new address", sqlmock.AnyArg(), 101, version).
+					WillReturnResult(sqlmock.NewErrorResult(errors.New("rows affected error")))
+			},
+			args: args{
+				number:  101,
+				address: "new address",
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.SetAddress: rows affected error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db)
+			tt.mocks(dbMock)
+
+			err = store.SetAddress(context.Background(), tt.args.number, tt.args.address, version)
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		number int
+	}
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		args    args
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "success",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = ?")).
+					WithArgs(101, ParcelStatusRegistered).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 			},
 			args: args{
@@ -507,30 +1148,45 @@ func TestDelete(t *testing.T) {
 			name: "database error",
 			mocks: func(dbMock sqlmock.Sqlmock) {
 				dbMock.
-					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = registered")).
-					WithArgs(101).
+					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = ?")).
+					WithArgs(101, ParcelStatusRegistered).
 					WillReturnError(errors.New("database error"))
 			},
 			args: args{
 				number: 101,
 			},
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.EqualError(tt, err, "database error", i...)
+				require.EqualError(tt, err, "parcelstore.Delete: database error", i...)
 			},
 		},
 		{
 			name: "no rows affected",
 			mocks: func(dbMock sqlmock.Sqlmock) {
 				dbMock.
-					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = registered")).
-					WithArgs(999).
+					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = ?")).
+					WithArgs(999, ParcelStatusRegistered).
 					WillReturnResult(sqlmock.NewResult(0, 0))
 			},
 			args: args{
 				number: 999,
 			},
 			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
-				require.NoError(tt, err, i...)
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name: "rows affected error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("DELETE FROM parcel WHERE number = ? AND status = ?")).
+					WithArgs(101, ParcelStatusRegistered).
+					WillReturnResult(sqlmock.NewErrorResult(errors.New("rows affected error")))
+			},
+			args: args{
+				number: 101,
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.Delete: rows affected error", i...)
 			},
 		},
 	}
@@ -546,10 +1202,3334 @@ func TestDelete(t *testing.T) {
 			store := NewParcelStore(db)
 			tt.mocks(dbMock)
 
-			err = store.Delete(tt.args.number)
+			err = store.Delete(context.Background(), tt.args.number)
 			tt.wantErr(t, err)
 
 			require.NoError(t, dbMock.ExpectationsWereMet())
 		})
 	}
 }
+
+func TestRegisterActiveParcelLimit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		limit     int
+		active    int
+		delivered int
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name:    "below the limit",
+			limit:   3,
+			active:  2,
+			wantErr: require.NoError,
+		},
+		{
+			name:   "at the limit",
+			limit:  2,
+			active: 2,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrClientParcelLimitExceeded, i...)
+			},
+		},
+		{
+			name:   "above the limit",
+			limit:  1,
+			active: 2,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrClientParcelLimitExceeded, i...)
+			},
+		},
+		{
+			name:      "delivered parcels do not count",
+			limit:     2,
+			active:    1,
+			delivered: 3,
+			wantErr:   require.NoError,
+		},
+		{
+			name:    "zero means unlimited",
+			limit:   0,
+			active:  5,
+			wantErr: require.NoError,
+		},
+	}
+
+	for _, tt := range tests {
+		for name, store := range repositories(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
+				createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+				for range tt.active {
+					addParcel(t, store, 1000, createdAt)
+				}
+				for range tt.delivered {
+					parcel := addParcel(t, store, 1000, createdAt)
+					require.NoError(t, store.SetStatus(ctx, int(parcel.Number), ParcelStatusDelivered, parcel.Version))
+				}
+				// Parcels of other clients never count.
+				addParcel(t, store, 2000, createdAt)
+
+				service := NewParcelService(store, WithMaxActiveParcelsPerClient(tt.limit), WithOutput(io.Discard))
+
+				_, registerErr := service.Register(ctx, 1000, "test address")
+				tt.wantErr(t, registerErr)
+
+				parcels, err := store.GetByClient(ctx, 1000)
+				require.NoError(t, err)
+
+				want := tt.active + tt.delivered
+				if registerErr == nil {
+					want++
+				}
+				require.Len(t, parcels, want)
+			})
+		}
+	}
+}
+
+func TestRegisterBatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		client      int64
+		addresses   []string
+		limit       int
+		wantAddress []string
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name:        "all valid",
+			client:      1000,
+			addresses:   []string{"first address", "second address", "third address"},
+			wantAddress: []string{"first address", "second address", "third address"},
+			wantErr:     require.NoError,
+		},
+		{
+			name:      "empty address mid-list",
+			client:    1000,
+			addresses: []string{"first address", "  ", "third address"},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrEmptyAddress, i...)
+				require.ErrorContains(tt, err, "address 1", i...)
+			},
+		},
+		{
+			name:        "empty batch",
+			client:      1000,
+			addresses:   nil,
+			wantAddress: []string{},
+			wantErr:     require.NoError,
+		},
+		{
+			name:      "invalid client",
+			client:    0,
+			addresses: []string{"first address"},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidClient, i...)
+			},
+		},
+		{
+			name:      "batch exceeds the active parcel limit",
+			client:    1000,
+			addresses: []string{"first address", "second address", "third address"},
+			limit:     2,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrClientParcelLimitExceeded, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for name, repo := range repositories(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				ctx := context.Background()
+				service := NewParcelService(repo, WithMaxActiveParcelsPerClient(tt.limit), WithOutput(io.Discard))
+
+				parcels, err := service.RegisterBatch(ctx, tt.client, tt.addresses)
+				tt.wantErr(t, err)
+
+				stored, getErr := repo.GetByClient(ctx, int(tt.client))
+				require.NoError(t, getErr)
+				require.Len(t, stored, len(tt.wantAddress))
+
+				if err != nil {
+					require.Nil(t, parcels)
+					return
+				}
+
+				addresses := []string{}
+				for i, parcel := range parcels {
+					require.NotZero(t, parcel.Number)
+					require.Equal(t, ParcelStatusRegistered, parcel.Status)
+					require.Equal(t, stored[i], parcel)
+					addresses = append(addresses, parcel.Address)
+				}
+				require.Equal(t, tt.wantAddress, addresses)
+			})
+		}
+	}
+}
+
+func TestRegisterAllowlist(t *testing.T) {
+	t.Parallel()
+
+	const address = "test address"
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		client  int64
+		allowed []int64
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "allowed client",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectExec("INSERT INTO parcel").
+					WithArgs(int64(1), ParcelStatusRegistered, address, sqlmock.AnyArg(), sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
+					WillReturnResult(sqlmock.NewResult(101, 1))
+				dbMock.
+					ExpectExec("UPDATE parcel SET tracking_code").
+					WithArgs(TrackingCode(101), int64(101)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			client:  1,
+			allowed: []int64{1, 2},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "disallowed client",
+			mocks:   func(dbMock sqlmock.Sqlmock) {},
+			client:  3,
+			allowed: []int64{1, 2},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrClientNotAllowed, i...)
+			},
+		},
+		{
+			name: "empty allowlist",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectExec("INSERT INTO parcel").
+					WithArgs(int64(3), ParcelStatusRegistered, address, sqlmock.AnyArg(), sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
+					WillReturnResult(sqlmock.NewResult(101, 1))
+				dbMock.
+					ExpectExec("UPDATE parcel SET tracking_code").
+					WithArgs(TrackingCode(101), int64(101)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			client:  3,
+			wantErr: require.NoError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			service := NewParcelService(NewParcelStore(db), WithAllowedClients(tt.allowed...))
+			tt.mocks(dbMock)
+
+			_, err = service.Register(context.Background(), tt.client, address)
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestStoreCanceledContext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context, store ParcelStore) error
+	}{
+		{
+			name: "add",
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Add(ctx, &Parcel{Client: 1, Status: ParcelStatusRegistered, Address: "address"})
+			},
+		},
+		{
+			name: "get",
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Get(ctx, 1)
+				return err
+			},
+		},
+		{
+			name: "get by client",
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByClient(ctx, 1)
+				return err
+			},
+		},
+		{
+			name: "set status",
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetStatus(ctx, 1, ParcelStatusSent, 1)
+			},
+		},
+		{
+			name: "set address",
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetAddress(ctx, 1, "address", 1)
+			},
+		},
+		{
+			name: "delete",
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Delete(ctx, 1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err = tt.call(ctx, NewParcelStore(db))
+			require.ErrorIs(t, err, context.Canceled)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+// fakeRepository is an in-test ParcelRepository that serves parcels
+// from a map and records every SetStatus call.
+type fakeRepository struct {
+	parcels     map[int]Parcel
+	setStatuses []ParcelStatus
+}
+
+func (f *fakeRepository) Add(_ context.Context, p *Parcel) error {
+	p.Number = int64(len(f.parcels) + 1)
+	f.parcels[int(p.Number)] = *p
+	return nil
+}
+
+func (f *fakeRepository) AddMany(ctx context.Context, parcels []*Parcel) error {
+	for _, p := range parcels {
+		if err := f.Add(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepository) AddWithinLimit(ctx context.Context, parcels []*Parcel, _ int) error {
+	return f.AddMany(ctx, parcels)
+}
+
+func (f *fakeRepository) Get(_ context.Context, number int) (Parcel, error) {
+	parcel, ok := f.parcels[number]
+	if !ok {
+		return Parcel{}, ErrParcelNotFound
+	}
+	return parcel, nil
+}
+
+func (f *fakeRepository) GetByExternalRef(_ context.Context, ref string) (Parcel, error) {
+	for _, parcel := range f.parcels {
+		if parcel.ExternalRef == ref {
+			return parcel, nil
+		}
+	}
+	return Parcel{}, ErrParcelNotFound
+}
+
+func (f *fakeRepository) GetByClient(_ context.Context, client int) ([]Parcel, error) {
+	var parcels []Parcel
+	for _, parcel := range f.parcels {
+		if parcel.Client == int64(client) {
+			parcels = append(parcels, parcel)
+		}
+	}
+	return parcels, nil
+}
+
+func (f *fakeRepository) GetByClientAndStatus(ctx context.Context, client int, status ParcelStatus) ([]Parcel, error) {
+	var parcels []Parcel
+	all, _ := f.GetByClient(ctx, client)
+	for _, parcel := range all {
+		if parcel.Status == status {
+			parcels = append(parcels, parcel)
+		}
+	}
+	return parcels, nil
+}
+
+func (f *fakeRepository) GetLatestByClient(ctx context.Context, client int) (Parcel, error) {
+	parcels, _ := f.GetByClient(ctx, client)
+	if len(parcels) == 0 {
+		return Parcel{}, ErrParcelNotFound
+	}
+	latest := parcels[0]
+	for _, parcel := range parcels[1:] {
+		if parcel.CreatedAt.After(latest.CreatedAt) ||
+			parcel.CreatedAt.Equal(latest.CreatedAt) && parcel.Number > latest.Number {
+			latest = parcel
+		}
+	}
+	return latest, nil
+}
+
+func (f *fakeRepository) CountByStatus(ctx context.Context, client int) (map[ParcelStatus]int, error) {
+	counts := make(map[ParcelStatus]int, len(knownStatuses))
+	for _, status := range knownStatuses {
+		counts[status] = 0
+	}
+	parcels, _ := f.GetByClient(ctx, client)
+	for _, parcel := range parcels {
+		counts[parcel.Status]++
+	}
+	return counts, nil
+}
+
+func (f *fakeRepository) GetStatusHistory(_ context.Context, _ int) ([]StatusChange, error) {
+	return nil, nil
+}
+
+func (f *fakeRepository) GetCreatedBetween(_ context.Context, status ParcelStatus, from, to time.Time) ([]Parcel, error) {
+	var parcels []Parcel
+	for _, parcel := range f.parcels {
+		if parcel.Status == status && !parcel.CreatedAt.Before(from) && parcel.CreatedAt.Before(to) {
+			parcels = append(parcels, parcel)
+		}
+	}
+	sort.Slice(parcels, func(i, j int) bool { return parcels[i].Number < parcels[j].Number })
+	return parcels, nil
+}
+
+func (f *fakeRepository) SetStatus(_ context.Context, number int, status ParcelStatus, _ int) error {
+	f.setStatuses = append(f.setStatuses, status)
+	parcel := f.parcels[number]
+	parcel.Status = status
+	f.parcels[number] = parcel
+	return nil
+}
+
+func (f *fakeRepository) AdvanceStatus(ctx context.Context, number int) (StatusChange, bool, error) {
+	parcel, err := f.Get(ctx, number)
+	if err != nil {
+		return StatusChange{}, false, err
+	}
+
+	change := StatusChange{Number: parcel.Number, OldStatus: parcel.Status, NewStatus: parcel.Status}
+
+	next, ok := parcel.Status.Next()
+	if !ok {
+		return change, false, nil
+	}
+
+	change.NewStatus = next
+
+	return change, true, f.SetStatus(ctx, number, next, parcel.Version)
+}
+
+func (f *fakeRepository) SetAddress(_ context.Context, number int, address string, _ int) error {
+	parcel := f.parcels[number]
+	parcel.Address = address
+	f.parcels[number] = parcel
+	return nil
+}
+
+func (f *fakeRepository) SetAddressWithReason(ctx context.Context, number int, address, _ string, version int) error {
+	return f.SetAddress(ctx, number, address, version)
+}
+
+func (f *fakeRepository) GetAddressHistory(_ context.Context, _ int) ([]AddressChange, error) {
+	return nil, nil
+}
+
+func (f *fakeRepository) Delete(_ context.Context, number int) error {
+	delete(f.parcels, number)
+	return nil
+}
+
+func TestCanDelete(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		number  int
+		want    bool
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "registered",
+			number:  101,
+			want:    true,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "sent",
+			number:  102,
+			want:    false,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "delivered",
+			number:  103,
+			want:    false,
+			wantErr: require.NoError,
+		},
+		{
+			name:   "missing",
+			number: 999,
+			want:   false,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &fakeRepository{
+				parcels: map[int]Parcel{
+					101: {Number: 101, Client: 1, Status: ParcelStatusRegistered, Address: "address"},
+					102: {Number: 102, Client: 1, Status: ParcelStatusSent, Address: "address"},
+					103: {Number: 103, Client: 1, Status: ParcelStatusDelivered, Address: "address"},
+				},
+			}
+			service := NewParcelService(repo)
+
+			got, err := service.CanDelete(context.Background(), tt.number)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, got)
+
+			// A dry run never deletes anything.
+			require.Len(t, repo.parcels, 3)
+		})
+	}
+}
+
+func TestNextStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		status       ParcelStatus
+		wantStatuses []ParcelStatus
+	}{
+		{
+			name:         "registered to sent",
+			status:       ParcelStatusRegistered,
+			wantStatuses: []ParcelStatus{ParcelStatusSent},
+		},
+		{
+			name:         "sent to delivered",
+			status:       ParcelStatusSent,
+			wantStatuses: []ParcelStatus{ParcelStatusDelivered},
+		},
+		{
+			name:         "delivered stays delivered",
+			status:       ParcelStatusDelivered,
+			wantStatuses: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &fakeRepository{
+				parcels: map[int]Parcel{
+					101: {Number: 101, Client: 1, Status: tt.status, Address: "address"},
+				},
+			}
+			service := NewParcelService(repo)
+
+			err := service.NextStatus(context.Background(), 101)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatuses, repo.setStatuses)
+		})
+	}
+}
+
+func TestRevertStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		advance     int
+		number      int
+		wantStatus  ParcelStatus
+		wantSent    bool
+		wantHistory []StatusChange
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name:       "delivered to sent",
+			advance:    2,
+			wantStatus: ParcelStatusSent,
+			wantSent:   true,
+			wantHistory: []StatusChange{
+				{OldStatus: ParcelStatusRegistered, NewStatus: ParcelStatusSent},
+				{OldStatus: ParcelStatusSent, NewStatus: ParcelStatusDelivered},
+				{OldStatus: ParcelStatusDelivered, NewStatus: ParcelStatusSent},
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:       "sent to registered",
+			advance:    1,
+			wantStatus: ParcelStatusRegistered,
+			wantHistory: []StatusChange{
+				{OldStatus: ParcelStatusRegistered, NewStatus: ParcelStatusSent},
+				{OldStatus: ParcelStatusSent, NewStatus: ParcelStatusRegistered},
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:        "registered has no previous status",
+			wantStatus:  ParcelStatusRegistered,
+			wantHistory: []StatusChange{},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrNoPreviousStatus, i...)
+			},
+		},
+		{
+			name:        "missing parcel",
+			number:      999,
+			wantStatus:  ParcelStatusRegistered,
+			wantHistory: []StatusChange{},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := NewParcelStore(openTestDB(t))
+			service := NewParcelService(store, WithOutput(io.Discard))
+
+			parcel := addParcel(t, store, 1000, time.Now().UTC())
+			for range tt.advance {
+				require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+			}
+
+			number := tt.number
+			if number == 0 {
+				number = int(parcel.Number)
+			}
+
+			tt.wantErr(t, service.RevertStatus(ctx, number))
+
+			got, err := store.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantStatus, got.Status)
+			require.Equal(t, tt.wantSent, got.SentAt.Valid)
+			require.False(t, got.DeliveredAt.Valid)
+
+			history, err := store.GetStatusHistory(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Len(t, history, len(tt.wantHistory))
+			for i, want := range tt.wantHistory {
+				require.Equal(t, want.OldStatus, history[i].OldStatus)
+				require.Equal(t, want.NewStatus, history[i].NewStatus)
+			}
+		})
+	}
+}
+
+func TestStoreDialectSQL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		dialect Dialect
+		mocks   func(dbMock sqlmock.Sqlmock)
+		call    func(ctx context.Context, store ParcelStore) error
+	}{
+		{
+			name:    "add sqlite",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec("INSERT INTO parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectExec("UPDATE parcel SET tracking_code = ? WHERE number = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Add(ctx, &Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address"})
+			},
+		},
+		{
+			name:    "add postgres",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery("INSERT INTO parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING number").
+					WillReturnRows(sqlmock.NewRows([]string{"number"}).AddRow(1))
+				dbMock.ExpectExec("UPDATE parcel SET tracking_code = $1 WHERE number = $2").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Add(ctx, &Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address"})
+			},
+		},
+		{
+			name:    "get sqlite",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE number = ? AND deleted_at IS NULL").
+					WillReturnRows(parcelRows(Parcel{Number: 1}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Get(ctx, 1)
+				return err
+			},
+		},
+		{
+			name:    "get postgres",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE number = $1 AND deleted_at IS NULL").
+					WillReturnRows(parcelRows(Parcel{Number: 1}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Get(ctx, 1)
+				return err
+			},
+		},
+		{
+			name:    "get by client sqlite",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number").
+					WillReturnRows(parcelRows())
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByClient(ctx, 1)
+				return err
+			},
+		},
+		{
+			name:    "get by client postgres",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE client = $1 AND deleted_at IS NULL ORDER BY number").
+					WillReturnRows(parcelRows())
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByClient(ctx, 1)
+				return err
+			},
+		},
+		{
+			name:    "set status sqlite",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery("SELECT status FROM parcel WHERE number = ?").
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.ExpectExec("UPDATE parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND version = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectExec("INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetStatus(ctx, 1, ParcelStatusSent, 1)
+			},
+		},
+		{
+			name:    "set status postgres",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery("SELECT status FROM parcel WHERE number = $1").
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.ExpectExec("UPDATE parcel SET status = $1, updated_at = $2, sent_at = $3, version = version + 1 WHERE number = $4 AND version = $5").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectExec("INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES ($1, $2, $3, $4)").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetStatus(ctx, 1, ParcelStatusSent, 1)
+			},
+		},
+		{
+			name:    "set address sqlite",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND version = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetAddress(ctx, 1, "address", 1)
+			},
+		},
+		{
+			name:    "set address postgres",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("UPDATE parcel SET address = $1, updated_at = $2, version = version + 1 WHERE number = $3 AND version = $4").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetAddress(ctx, 1, "address", 1)
+			},
+		},
+		{
+			name:    "delete sqlite",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("DELETE FROM parcel WHERE number = ? AND status = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Delete(ctx, 1)
+			},
+		},
+		{
+			name:    "delete postgres",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("DELETE FROM parcel WHERE number = $1 AND status = $2").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Delete(ctx, 1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			err = tt.call(context.Background(), NewParcelStore(db, WithDialect(tt.dialect)))
+			require.NoError(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAddDialects(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		dialect    Dialect
+		mocks      func(dbMock sqlmock.Sqlmock)
+		wantNumber int64
+		wantErr    require.ErrorAssertionFunc
+	}{
+		{
+			name:    "sqlite uses last insert id",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("INSERT INTO parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")).
+					WithArgs(int64(1), ParcelStatusRegistered, "address", createdAt, sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
+					WillReturnResult(sqlmock.NewResult(7, 1))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET tracking_code = ? WHERE number = ?")).
+					WithArgs(TrackingCode(7), int64(7)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			wantNumber: 7,
+			wantErr:    require.NoError,
+		},
+		{
+			name:    "mysql uses last insert id",
+			dialect: DialectMySQL,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("INSERT INTO parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")).
+					WithArgs(int64(1), ParcelStatusRegistered, "address", createdAt, sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
+					WillReturnResult(sqlmock.NewResult(9, 1))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET tracking_code = ? WHERE number = ?")).
+					WithArgs(TrackingCode(9), int64(9)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			wantNumber: 9,
+			wantErr:    require.NoError,
+		},
+		{
+			name:    "postgres uses returning",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("INSERT INTO parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING number")).
+					WithArgs(int64(1), ParcelStatusRegistered, "address", createdAt, sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
+					WillReturnRows(sqlmock.NewRows([]string{"number"}).AddRow(int64(8)))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET tracking_code = $1 WHERE number = $2")).
+					WithArgs(TrackingCode(8), int64(8)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			wantNumber: 8,
+			wantErr:    require.NoError,
+		},
+		{
+			name:    "postgres database error",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("INSERT INTO parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING number")).
+					WithArgs(int64(1), ParcelStatusRegistered, "address", createdAt, sqlmock.AnyArg(), "", nil, 0, 0, 0, 0, 0, nil).
+					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
+			},
+			wantNumber: 0,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.Add: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db, WithDialect(tt.dialect))
+			tt.mocks(dbMock)
+
+			parcel := Parcel{Client: 1, Status: ParcelStatusRegistered, Address: "address", CreatedAt: createdAt}
+			err = store.Add(context.Background(), &parcel)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantNumber, parcel.Number)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestParcelJSON(t *testing.T) {
+	t.Parallel()
+
+	parcel := Parcel{
+		Number:    101,
+		Client:    102,
+		Status:    ParcelStatusSent,
+		Address:   "test address",
+		CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 123456789, time.UTC),
+	}
+
+	data, err := json.Marshal(parcel)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"number": 101,
+		"client": 102,
+		"status": "sent",
+		"address": "test address",
+		"created_at": "2023-11-20T10:00:00Z"
+	}`, string(data))
+
+	var got Parcel
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.WithinDuration(t, parcel.CreatedAt, got.CreatedAt, time.Second)
+
+	got.CreatedAt = parcel.CreatedAt
+	require.Equal(t, parcel, got)
+}
+
+func TestParcelJSONUpdatedAt(t *testing.T) {
+	t.Parallel()
+
+	parcel := Parcel{
+		Number:    101,
+		Status:    ParcelStatusSent,
+		CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2023, 11, 21, 12, 30, 0, 0, time.UTC),
+	}
+
+	data, err := json.Marshal(parcel)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"updated_at":"2023-11-21T12:30:00Z"`)
+
+	var got Parcel
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.Equal(t, parcel, got)
+}
+
+func TestCreatedAtRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	service := NewParcelService(store)
+
+	registered, err := service.Register(ctx, 1, "test address")
+	require.NoError(t, err)
+
+	got, err := store.Get(ctx, int(registered.Number))
+	require.NoError(t, err)
+	require.WithinDuration(t, registered.CreatedAt, got.CreatedAt, time.Second)
+	require.Equal(t, time.UTC, got.CreatedAt.Location())
+}
+
+func TestReserveNumbers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	first, err := store.ReserveNumbers(ctx, 3)
+	require.NoError(t, err)
+	require.Len(t, first, 3)
+
+	second, err := store.ReserveNumbers(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, second, 2)
+
+	seen := make(map[int64]struct{})
+	for _, number := range append(first, second...) {
+		require.NotContains(t, seen, number)
+		seen[number] = struct{}{}
+	}
+
+	// Regular registrations must not reuse reserved numbers either.
+	parcel := Parcel{Client: 1, Status: ParcelStatusRegistered, Address: "address", CreatedAt: time.Now().UTC()}
+	require.NoError(t, store.Add(ctx, &parcel))
+	require.NotContains(t, seen, parcel.Number)
+
+	// Placeholder rows must not be visible as parcels.
+	parcels, err := store.GetByClient(ctx, 0)
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+
+	_, err = store.ReserveNumbers(ctx, 0)
+	require.Error(t, err)
+}
+
+func TestInsertWithNumber(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	numbers, err := store.ReserveNumbers(ctx, 1)
+	require.NoError(t, err)
+
+	parcel := Parcel{
+		Number:    numbers[0],
+		Client:    1,
+		Status:    ParcelStatusRegistered,
+		Address:   "offline address",
+		CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("reserved number", func(t *testing.T) {
+		require.NoError(t, store.InsertWithNumber(ctx, parcel))
+
+		got, err := store.Get(ctx, int(parcel.Number))
+		require.NoError(t, err)
+		require.Equal(t, parcel.Number, got.Number)
+		require.Equal(t, parcel.Address, got.Address)
+		require.True(t, parcel.CreatedAt.Equal(got.CreatedAt))
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		err := store.InsertWithNumber(ctx, parcel)
+		require.ErrorIs(t, err, ErrParcelNumberConflict)
+	})
+
+	t.Run("not reserved", func(t *testing.T) {
+		unreserved := parcel
+		unreserved.Number = numbers[0] + 100
+
+		err := store.InsertWithNumber(ctx, unreserved)
+		require.ErrorIs(t, err, ErrParcelNumberConflict)
+	})
+}
+
+// seedParcels adds count registered parcels for client and returns
+// their numbers in insertion order.
+func seedParcels(t testing.TB, store ParcelStore, client int64, count int) []int64 {
+	t.Helper()
+
+	numbers := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		parcel := Parcel{
+			Client:    client,
+			Status:    ParcelStatusRegistered,
+			Address:   "test address",
+			CreatedAt: time.Now().UTC(),
+		}
+		require.NoError(t, store.Add(context.Background(), &parcel))
+		numbers = append(numbers, parcel.Number)
+	}
+
+	return numbers
+}
+
+// parcelNumbers returns the numbers of the given parcels in order.
+func parcelNumbers(parcels []Parcel) []int64 {
+	numbers := make([]int64, 0, len(parcels))
+	for _, parcel := range parcels {
+		numbers = append(numbers, parcel.Number)
+	}
+	return numbers
+}
+
+func TestGetByClientPaged(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	numbers := seedParcels(t, store, 1, 5)
+	seedParcels(t, store, 2, 2)
+
+	tests := []struct {
+		name    string
+		limit   int
+		offset  int
+		want    []int64
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "first page",
+			limit:   2,
+			offset:  0,
+			want:    numbers[0:2],
+			wantErr: require.NoError,
+		},
+		{
+			name:    "middle page",
+			limit:   2,
+			offset:  2,
+			want:    numbers[2:4],
+			wantErr: require.NoError,
+		},
+		{
+			name:    "last partial page",
+			limit:   2,
+			offset:  4,
+			want:    numbers[4:5],
+			wantErr: require.NoError,
+		},
+		{
+			name:    "past the end",
+			limit:   2,
+			offset:  10,
+			want:    []int64{},
+			wantErr: require.NoError,
+		},
+		{
+			name:   "zero limit",
+			limit:  0,
+			offset: 0,
+			want:   []int64{},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidPage, i...)
+			},
+		},
+		{
+			name:   "negative offset",
+			limit:  2,
+			offset: -1,
+			want:   []int64{},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidPage, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			parcels, err := store.GetByClientPaged(context.Background(), 1, tt.limit, tt.offset)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, parcelNumbers(parcels))
+		})
+	}
+}
+
+func TestGetByClientAfter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	numbers := seedParcels(t, store, 1, 7)
+	seedParcels(t, store, 2, 3)
+
+	// Walk every page by passing the last number seen as the cursor.
+	var (
+		seen   []int64
+		pages  int
+		cursor int64
+	)
+	for {
+		page, err := store.GetByClientAfter(ctx, 1, cursor, 3)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 3)
+
+		pages++
+		seen = append(seen, parcelNumbers(page)...)
+
+		if len(page) < 3 {
+			break
+		}
+
+		cursor = page[len(page)-1].Number
+	}
+
+	require.Equal(t, numbers, seen)
+	require.Equal(t, 3, pages)
+
+	// A cursor past the last parcel gives an empty page.
+	page, err := store.GetByClientAfter(ctx, 1, numbers[len(numbers)-1], 3)
+	require.NoError(t, err)
+	require.Empty(t, page)
+
+	// A parcel deleted behind the cursor does not shift the next page.
+	require.NoError(t, store.Delete(ctx, int(numbers[0])))
+	page, err = store.GetByClientAfter(ctx, 1, numbers[2], 2)
+	require.NoError(t, err)
+	require.Equal(t, numbers[3:5], parcelNumbers(page))
+}
+
+func TestGetByClientAfterInvalid(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+
+	tests := []struct {
+		name        string
+		afterNumber int64
+		limit       int
+	}{
+		{name: "zero limit", afterNumber: 0, limit: 0},
+		{name: "negative limit", afterNumber: 0, limit: -1},
+		{name: "negative cursor", afterNumber: -1, limit: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			parcels, err := store.GetByClientAfter(context.Background(), 1, tt.afterNumber, tt.limit)
+			require.ErrorIs(t, err, ErrInvalidPage)
+			require.Nil(t, parcels)
+		})
+	}
+}
+
+func TestCountByClient(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		mocks     func(dbMock sqlmock.Sqlmock)
+		wantCount int
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name: "no parcels",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL")).
+					WithArgs(102).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			},
+			wantCount: 0,
+			wantErr:   require.NoError,
+		},
+		{
+			name: "one parcel",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL")).
+					WithArgs(102).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			},
+			wantCount: 1,
+			wantErr:   require.NoError,
+		},
+		{
+			name: "several parcels",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL")).
+					WithArgs(102).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+			},
+			wantCount: 7,
+			wantErr:   require.NoError,
+		},
+		{
+			name: "database error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL")).
+					WithArgs(102).
+					WillReturnError(errors.New("database error"))
+			},
+			wantCount: 0,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.CountByClient: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db)
+			tt.mocks(dbMock)
+
+			count, err := store.CountByClient(context.Background(), 102)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantCount, count)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetByClientAndStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		mocks       func(dbMock sqlmock.Sqlmock)
+		status      ParcelStatus
+		wantNumbers []int64
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name: "matching parcels",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				rows := parcelRows(
+					Parcel{Number: 101, Client: 102, Status: ParcelStatusSent, Address: "Address 1", CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)},
+					Parcel{Number: 103, Client: 102, Status: ParcelStatusSent, Address: "Address 3", CreatedAt: time.Date(2023, 11, 22, 10, 0, 0, 0, time.UTC)},
+				)
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("SELECT "+parcelColumns+" FROM parcel WHERE client = ? AND status = ? AND deleted_at IS NULL")).
+					WithArgs(102, ParcelStatusSent).
+					WillReturnRows(rows)
+			},
+			status:      ParcelStatusSent,
+			wantNumbers: []int64{101, 103},
+			wantErr:     require.NoError,
+		},
+		{
+			name: "no matching parcels",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta("SELECT "+parcelColumns+" FROM parcel WHERE client = ? AND status = ? AND deleted_at IS NULL")).
+					WithArgs(102, ParcelStatusDelivered).
+					WillReturnRows(parcelRows())
+			},
+			status:      ParcelStatusDelivered,
+			wantNumbers: []int64{},
+			wantErr:     require.NoError,
+		},
+		{
+			name:        "invalid status",
+			mocks:       func(dbMock sqlmock.Sqlmock) {},
+			status:      "Sent",
+			wantNumbers: []int64{},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db)
+			tt.mocks(dbMock)
+
+			parcels, err := store.GetByClientAndStatus(context.Background(), 102, tt.status)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantNumbers, parcelNumbers(parcels))
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetByClientAndStatusFilters(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	numbers := seedParcels(t, store, 1, 3)
+	seedParcels(t, store, 2, 1)
+	require.NoError(t, store.SetStatus(ctx, int(numbers[1]), ParcelStatusSent, 1))
+
+	parcels, err := store.GetByClientAndStatus(ctx, 1, ParcelStatusSent)
+	require.NoError(t, err)
+	require.Equal(t, []int64{numbers[1]}, parcelNumbers(parcels))
+
+	parcels, err = store.GetByClientAndStatus(ctx, 1, ParcelStatusRegistered)
+	require.NoError(t, err)
+	require.Equal(t, []int64{numbers[0], numbers[2]}, parcelNumbers(parcels))
+}
+
+func TestRegisterByOperator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	service := NewParcelService(store)
+
+	first, err := service.RegisterBy(ctx, 1, "first address", "operator-1")
+	require.NoError(t, err)
+	require.Equal(t, "operator-1", first.RegisteredBy)
+
+	second, err := service.RegisterBy(ctx, 2, "second address", "operator-1")
+	require.NoError(t, err)
+
+	_, err = service.RegisterBy(ctx, 1, "third address", "operator-2")
+	require.NoError(t, err)
+
+	_, err = service.Register(ctx, 1, "anonymous address")
+	require.NoError(t, err)
+
+	parcels, err := store.GetByOperator(ctx, "operator-1")
+	require.NoError(t, err)
+	require.Equal(t, []int64{first.Number, second.Number}, parcelNumbers(parcels))
+	for _, parcel := range parcels {
+		require.Equal(t, "operator-1", parcel.RegisteredBy)
+	}
+
+	parcels, err = store.GetByOperator(ctx, "operator-3")
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+
+	_, err = store.GetByOperator(ctx, "")
+	require.Error(t, err)
+}
+
+func TestAdvanceStatus(t *testing.T) {
+	t.Parallel()
+
+	const (
+		selectStatus    = "SELECT status FROM parcel WHERE number = ?"
+		updateSent      = "UPDATE parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND status = ?"
+		updateDelivered = "UPDATE parcel SET status = ?, updated_at = ?, delivered_at = ?, version = version + 1 WHERE number = ? AND status = ?"
+		insertHistory   = "INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)"
+	)
+
+	tests := []struct {
+		name         string
+		mocks        func(dbMock sqlmock.Sqlmock)
+		wantStatus   ParcelStatus
+		wantAdvanced bool
+		wantErr      require.ErrorAssertionFunc
+	}{
+		{
+			name: "registered to sent",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.ExpectExec(regexp.QuoteMeta(updateSent)).
+					WithArgs(ParcelStatusSent, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusRegistered).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(101, ParcelStatusRegistered, ParcelStatusSent, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			wantStatus:   ParcelStatusSent,
+			wantAdvanced: true,
+			wantErr:      require.NoError,
+		},
+		{
+			name: "delivered is terminal",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusDelivered))
+				dbMock.ExpectCommit()
+			},
+			wantStatus:   ParcelStatusDelivered,
+			wantAdvanced: false,
+			wantErr:      require.NoError,
+		},
+		{
+			name: "missing parcel",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name: "empty status",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(""))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+		{
+			name: "concurrent change",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusSent))
+				dbMock.ExpectExec(regexp.QuoteMeta(updateDelivered)).
+					WithArgs(ParcelStatusDelivered, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusSent).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrStatusChanged, i...)
+			},
+		},
+		{
+			name: "update error rolls back",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusSent))
+				dbMock.ExpectExec(regexp.QuoteMeta(updateDelivered)).
+					WithArgs(ParcelStatusDelivered, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusSent).
+					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.AdvanceStatus: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db)
+			tt.mocks(dbMock)
+
+			change, advanced, err := store.AdvanceStatus(context.Background(), 101)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantStatus, change.NewStatus)
+			require.Equal(t, tt.wantAdvanced, advanced)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestNextStatusTransaction(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(regexp.QuoteMeta("SELECT status FROM parcel WHERE number = ?")).
+		WithArgs(101).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+	dbMock.ExpectExec(regexp.QuoteMeta("UPDATE parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND status = ?")).
+		WithArgs(ParcelStatusSent, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusRegistered).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(regexp.QuoteMeta("INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)")).
+		WithArgs(101, ParcelStatusRegistered, ParcelStatusSent, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectCommit()
+
+	service := NewParcelService(NewParcelStore(db))
+	require.NoError(t, service.NextStatus(context.Background(), 101))
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestNextStatusInvalidParcel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, db *sql.DB, store ParcelStore) int
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "missing parcel",
+			setup: func(t *testing.T, db *sql.DB, store ParcelStore) int {
+				return 999
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name: "unknown status",
+			setup: func(t *testing.T, db *sql.DB, store ParcelStore) int {
+				parcel := addParcel(t, store, 1000, time.Now().UTC())
+				_, err := db.Exec("UPDATE parcel SET status = ? WHERE number = ?", "lost", parcel.Number)
+				require.NoError(t, err)
+				return int(parcel.Number)
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+				require.ErrorContains(tt, err, `"lost"`, i...)
+			},
+		},
+		{
+			name: "empty status",
+			setup: func(t *testing.T, db *sql.DB, store ParcelStore) int {
+				parcel := addParcel(t, store, 1000, time.Now().UTC())
+				_, err := db.Exec("UPDATE parcel SET status = '' WHERE number = ?", parcel.Number)
+				require.NoError(t, err)
+				return int(parcel.Number)
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := openTestDB(t)
+			store := NewParcelStore(db)
+			number := tt.setup(t, db, store)
+
+			var out bytes.Buffer
+			service := NewParcelService(store, WithOutput(&out))
+
+			tt.wantErr(t, service.NextStatus(context.Background(), number))
+			require.Empty(t, out.String())
+
+			history, err := store.GetStatusHistory(context.Background(), number)
+			require.NoError(t, err)
+			require.Empty(t, history)
+		})
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+
+	t.Run("commit", func(t *testing.T) {
+		parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+
+		err := store.WithTx(ctx, func(tx ParcelStore) error {
+			if err := tx.Add(ctx, &parcel); err != nil {
+				return err
+			}
+
+			return tx.SetStatus(ctx, int(parcel.Number), ParcelStatusSent, parcel.Version)
+		})
+		require.NoError(t, err)
+
+		stored, err := store.Get(ctx, int(parcel.Number))
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusSent, stored.Status)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		errAbort := errors.New("abort")
+		parcel := Parcel{Client: 1001, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+
+		err := store.WithTx(ctx, func(tx ParcelStore) error {
+			if err := tx.Add(ctx, &parcel); err != nil {
+				return err
+			}
+
+			return errAbort
+		})
+		require.ErrorIs(t, err, errAbort)
+
+		parcels, err := store.GetByClient(ctx, 1001)
+		require.NoError(t, err)
+		require.Empty(t, parcels)
+	})
+}
+
+func TestManifest(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	service := NewParcelService(store)
+	ctx := context.Background()
+
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	day := time.Date(2024, time.March, 10, 12, 0, 0, 0, loc)
+
+	seed := []struct {
+		status    ParcelStatus
+		createdAt time.Time
+	}{
+		{ParcelStatusRegistered, time.Date(2024, time.March, 10, 0, 30, 0, 0, loc)},
+		{ParcelStatusRegistered, time.Date(2024, time.March, 10, 23, 59, 0, 0, loc)},
+		{ParcelStatusSent, time.Date(2024, time.March, 10, 9, 0, 0, 0, loc)},
+		{ParcelStatusRegistered, time.Date(2024, time.March, 9, 23, 59, 0, 0, loc)},
+		{ParcelStatusRegistered, time.Date(2024, time.March, 11, 0, 0, 0, 0, loc)},
+	}
+
+	var numbers []int64
+	for _, s := range seed {
+		parcel := Parcel{Client: 1000, Status: s.status, Address: "test", CreatedAt: s.createdAt.UTC()}
+		require.NoError(t, store.Add(ctx, &parcel))
+		numbers = append(numbers, parcel.Number)
+	}
+
+	manifest, err := service.Manifest(ctx, day, loc)
+	require.NoError(t, err)
+	require.Equal(t, numbers[:2], parcelNumbers(manifest))
+}
+
+func TestAddMany(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		parcels := make([]*Parcel, 100)
+		for i := range parcels {
+			parcels[i] = &Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+		}
+
+		require.NoError(t, store.AddMany(ctx, parcels))
+
+		numbers := make(map[int64]struct{}, len(parcels))
+		for _, p := range parcels {
+			require.NotZero(t, p.Number)
+			numbers[p.Number] = struct{}{}
+		}
+		require.Len(t, numbers, len(parcels))
+
+		count, err := store.CountByClient(ctx, 1000)
+		require.NoError(t, err)
+		require.Equal(t, len(parcels), count)
+	})
+
+	t.Run("atomic failure", func(t *testing.T) {
+		parcels := []*Parcel{
+			{Client: 1001, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()},
+			{Client: 1001, Status: "unknown", Address: "test", CreatedAt: time.Now().UTC()},
+		}
+
+		err := store.AddMany(ctx, parcels)
+		require.ErrorIs(t, err, ErrInvalidStatus)
+		require.Zero(t, parcels[0].Number)
+
+		count, err := store.CountByClient(ctx, 1001)
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+}
+
+func TestAddWithinLimit(t *testing.T) {
+	t.Parallel()
+
+	const countActive = "SELECT COUNT(*) FROM parcel WHERE client = ? AND status <> ? AND deleted_at IS NULL"
+
+	tests := []struct {
+		name    string
+		limit   int
+		mocks   func(dbMock sqlmock.Sqlmock)
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:  "within the limit",
+			limit: 3,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(countActive)).
+					WithArgs(1000, ParcelStatusDelivered).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				dbMock.ExpectExec(regexp.QuoteMeta(insertParcelQuery(TableName{}))).
+					WillReturnResult(sqlmock.NewResult(7, 1))
+				dbMock.ExpectExec(regexp.QuoteMeta("UPDATE parcel SET tracking_code = ? WHERE number = ?")).
+					WithArgs(TrackingCode(7), 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:  "over the limit",
+			limit: 2,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(countActive)).
+					WithArgs(1000, ParcelStatusDelivered).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrClientParcelLimitExceeded, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+			err = NewParcelStore(db).AddWithinLimit(context.Background(), []*Parcel{&parcel}, tt.limit)
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAddWithinLimitIgnoresDeleted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	deleted := addParcel(t, store, 1000, time.Now().UTC())
+	require.NoError(t, store.SoftDelete(ctx, int(deleted.Number)))
+
+	parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+	require.NoError(t, store.AddWithinLimit(ctx, []*Parcel{&parcel}, 1))
+	require.NotZero(t, parcel.Number)
+
+	another := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+	require.ErrorIs(t, store.AddWithinLimit(ctx, []*Parcel{&another}, 1), ErrClientParcelLimitExceeded)
+	require.Zero(t, another.Number)
+}
+
+func TestUpdatedAt(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+
+	parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+	require.NoError(t, store.Add(ctx, &parcel))
+	require.False(t, parcel.UpdatedAt.IsZero())
+
+	added, err := store.Get(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	require.WithinDuration(t, parcel.UpdatedAt, added.UpdatedAt, time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, store.SetAddress(ctx, int(parcel.Number), "new address", parcel.Version))
+
+	updated, err := store.Get(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	require.True(t, updated.UpdatedAt.After(added.UpdatedAt))
+	require.Equal(t, added.CreatedAt, updated.CreatedAt)
+}
+
+func TestSoftDelete(t *testing.T) {
+	t.Parallel()
+
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+
+	numbers := seedParcels(t, store, 1000, 2)
+	deleted := int(numbers[0])
+
+	require.NoError(t, store.SoftDelete(ctx, deleted))
+	require.ErrorIs(t, store.SoftDelete(ctx, deleted), ErrParcelNotFound)
+
+	got, err := store.Get(ctx, deleted)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+	require.Equal(t, Parcel{}, got)
+
+	parcels, err := store.GetByClient(ctx, 1000)
+	require.NoError(t, err)
+	require.Equal(t, numbers[1:], parcelNumbers(parcels))
+
+	all, err := store.GetByClientIncludingDeleted(ctx, 1000)
+	require.NoError(t, err)
+	require.Equal(t, numbers, parcelNumbers(all))
+	require.True(t, all[0].DeletedAt.Valid)
+	require.False(t, all[1].DeletedAt.Valid)
+
+	require.NoError(t, store.Restore(ctx, deleted))
+	require.ErrorIs(t, store.Restore(ctx, deleted), ErrParcelNotFound)
+
+	got, err = store.Get(ctx, deleted)
+	require.NoError(t, err)
+	require.Equal(t, int64(deleted), got.Number)
+	require.False(t, got.DeletedAt.Valid)
+
+	parcels, err = store.GetByClient(ctx, 1000)
+	require.NoError(t, err)
+	require.Equal(t, numbers, parcelNumbers(parcels))
+}
+
+func TestServiceOutput(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	repo := &fakeRepository{
+		parcels: map[int]Parcel{
+			1: {Number: 1, Client: 1000, Status: ParcelStatusRegistered, Address: "first address", CreatedAt: createdAt},
+		},
+	}
+
+	var out bytes.Buffer
+	service := NewParcelService(repo, WithOutput(&out))
+
+	require.NoError(t, service.NextStatus(ctx, 1))
+	require.NoError(t, service.PrintClientParcels(ctx, 1000))
+
+	require.Equal(t, "У посылки № 1 новый статус: sent\n"+
+		"Посылки клиента 1000:\n"+
+		"Посылка № 1 на адрес first address от клиента с идентификатором 1000 зарегистрирована 2024-03-10T12:00:00Z, статус sent\n",
+		out.String())
+
+	out.Reset()
+	parcel, err := service.Register(ctx, 1001, "second address")
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("Новая посылка № %d на адрес second address от клиента с идентификатором 1001 зарегистрирована %s\n",
+		parcel.Number, parcel.CreatedAt.Format(time.RFC3339)), out.String())
+}
+
+func TestPrintClientParcelsStatuses(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		statuses []ParcelStatus
+		want     []int64
+		wantErr  require.ErrorAssertionFunc
+	}{
+		{
+			name:    "no statuses lists all",
+			want:    []int64{1, 2, 3, 4},
+			wantErr: require.NoError,
+		},
+		{
+			name:     "one status",
+			statuses: []ParcelStatus{ParcelStatusSent},
+			want:     []int64{2, 4},
+			wantErr:  require.NoError,
+		},
+		{
+			name:     "several statuses in number order",
+			statuses: []ParcelStatus{ParcelStatusDelivered, ParcelStatusRegistered, ParcelStatusDelivered},
+			want:     []int64{1, 3},
+			wantErr:  require.NoError,
+		},
+		{
+			name:     "unknown status",
+			statuses: []ParcelStatus{"lost"},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for name, repo := range repositories(t) {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				ctx := context.Background()
+				for range 4 {
+					addParcel(t, repo, 1000, createdAt)
+				}
+				addParcel(t, repo, 1001, createdAt)
+
+				for _, number := range []int{2, 3, 3, 4} {
+					_, _, err := repo.AdvanceStatus(ctx, number)
+					require.NoError(t, err)
+				}
+
+				var out bytes.Buffer
+				service := NewParcelService(repo, WithOutput(&out))
+
+				err := service.PrintClientParcels(ctx, 1000, tt.statuses...)
+				tt.wantErr(t, err)
+				if err != nil {
+					require.Empty(t, out.String())
+					return
+				}
+
+				lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+				require.Equal(t, "Посылки клиента 1000:", lines[0])
+				require.Len(t, lines, len(tt.want)+1)
+				for i, number := range tt.want {
+					require.True(t, strings.HasPrefix(lines[i+1], fmt.Sprintf("Посылка № %d ", number)), lines[i+1])
+				}
+			})
+		}
+	}
+}
+
+func TestFormatClientParcels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	service := NewParcelService(store)
+
+	numbers := seedParcels(t, store, 1000, 3)
+	seedParcels(t, store, 1001, 1)
+
+	listing, err := service.FormatClientParcels(ctx, 1000)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(listing, "\n"), "\n")
+	require.Len(t, lines, len(numbers)+1)
+	require.Equal(t, "Посылки клиента 1000:", lines[0])
+	for i, number := range numbers {
+		require.True(t, strings.HasPrefix(lines[i+1], fmt.Sprintf("Посылка № %d на адрес test address от клиента с идентификатором 1000 ", number)), lines[i+1])
+	}
+}
+
+func TestPing(t *testing.T) {
+	t.Parallel()
+
+	errUnreachable := errors.New("connection refused")
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "reachable",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectPing()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "unreachable",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectPing().WillReturnError(errUnreachable)
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errUnreachable, i...)
+				require.EqualError(tt, err, "parcelstore.Ping: parcel store unreachable: connection refused", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			err = NewParcelStore(db).Ping(context.Background())
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestChangeAddressByStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		status  ParcelStatus
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "registered",
+			status:  ParcelStatusRegistered,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "sent",
+			status:  ParcelStatusSent,
+			wantErr: require.NoError,
+		},
+		{
+			name:   "delivered",
+			status: ParcelStatusDelivered,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelAlreadyDelivered, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := NewMemoryStore()
+			service := NewParcelService(store)
+
+			parcel := Parcel{Client: 1000, Status: tt.status, Address: "old address", CreatedAt: time.Now().UTC()}
+			require.NoError(t, store.Add(ctx, &parcel))
+
+			err := service.ChangeAddress(ctx, int(parcel.Number), "new address")
+			tt.wantErr(t, err)
+
+			want := "new address"
+			if err != nil {
+				want = "old address"
+			}
+
+			got, err := store.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Equal(t, want, got.Address)
+		})
+	}
+}
+
+// vanishingRepository deletes a parcel right before changing its
+// address, like a concurrent delete racing ParcelService.ChangeAddress.
+type vanishingRepository struct {
+	ParcelRepository
+}
+
+func (r vanishingRepository) SetAddress(ctx context.Context, number int, address string, version int) error {
+	if err := r.ParcelRepository.Delete(ctx, number); err != nil {
+		return err
+	}
+	return r.ParcelRepository.SetAddress(ctx, number, address, version)
+}
+
+func TestChangeAddressNotFound(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		wrap   func(repo ParcelRepository) ParcelRepository
+		number func(parcel Parcel) int
+	}{
+		{
+			name:   "missing number",
+			wrap:   func(repo ParcelRepository) ParcelRepository { return repo },
+			number: func(parcel Parcel) int { return int(parcel.Number) + 1 },
+		},
+		{
+			name:   "deleted concurrently",
+			wrap:   func(repo ParcelRepository) ParcelRepository { return vanishingRepository{ParcelRepository: repo} },
+			number: func(parcel Parcel) int { return int(parcel.Number) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for name, repo := range repositories(t) {
+				t.Run(name, func(t *testing.T) {
+					parcel := addParcel(t, repo, 1000, time.Now().UTC())
+					service := NewParcelService(tt.wrap(repo))
+
+					err := service.ChangeAddress(ctx, tt.number(parcel), "new address")
+					require.True(t, errors.Is(err, ErrParcelNotFound), "got %v", err)
+				})
+			}
+		})
+	}
+}
+
+func TestGetByNumbers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("found and missing", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewParcelStore(openTestDB(t))
+		numbers := seedParcels(t, store, 1000, 3)
+
+		parcels, err := store.GetByNumbers(ctx, []int64{numbers[2], 999, numbers[0]})
+		require.NoError(t, err)
+		require.Equal(t, []int64{numbers[0], numbers[2]}, parcelNumbers(parcels))
+	})
+
+	t.Run("placeholders", func(t *testing.T) {
+		t.Parallel()
+
+		db, dbMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		defer db.Close()
+
+		dbMock.ExpectQuery("SELECT "+parcelColumns+" FROM parcel WHERE number IN ($1, $2, $3) AND deleted_at IS NULL ORDER BY number").
+			WithArgs(int64(1), int64(2), int64(3)).
+			WillReturnRows(parcelRows(Parcel{Number: 1}, Parcel{Number: 3}))
+
+		parcels, err := NewParcelStore(db, WithDialect(DialectPostgres)).GetByNumbers(ctx, []int64{1, 2, 3})
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 3}, parcelNumbers(parcels))
+
+		require.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		t.Parallel()
+
+		db, dbMock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		parcels, err := NewParcelStore(db).GetByNumbers(ctx, nil)
+		require.NoError(t, err)
+		require.NotNil(t, parcels)
+		require.Empty(t, parcels)
+
+		require.NoError(t, dbMock.ExpectationsWereMet())
+	})
+}
+
+func TestGetByDateRange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	from := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	add := func(createdAt time.Time) int64 {
+		parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: createdAt}
+		require.NoError(t, store.Add(ctx, &parcel))
+		return parcel.Number
+	}
+
+	late := add(to.Add(-time.Nanosecond))
+	add(from.Add(-time.Nanosecond))
+	start := add(from)
+	add(to)
+
+	parcels, err := store.GetByDateRange(ctx, from, to)
+	require.NoError(t, err)
+	require.Equal(t, []int64{start, late}, parcelNumbers(parcels))
+
+	_, err = store.GetByDateRange(ctx, to, from)
+	require.ErrorIs(t, err, ErrInvalidDateRange)
+
+	_, err = store.GetByDateRange(ctx, from, from)
+	require.ErrorIs(t, err, ErrInvalidDateRange)
+}
+
+func TestSearchByAddress(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	add := func(address string) int64 {
+		parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: address, CreatedAt: time.Now().UTC()}
+		require.NoError(t, store.Add(ctx, &parcel))
+		return parcel.Number
+	}
+
+	baker := add("221B Baker Street")
+	add("10 Downing Street")
+	discount := add("Shop 100% Discount")
+	add("Shop 1000 Discount")
+	underscore := add("dept_42, Main Road")
+	add("dept-42, Main Road")
+	bang := add("Yahoo! Plaza")
+
+	tests := []struct {
+		name      string
+		substring string
+		want      []int64
+		wantErr   require.ErrorAssertionFunc
+	}{
+		{
+			name:      "case-insensitive match",
+			substring: "bAKER st",
+			want:      []int64{baker},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "no match",
+			substring: "Abbey Road",
+			want:      []int64{},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "literal percent",
+			substring: "100%",
+			want:      []int64{discount},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "literal underscore",
+			substring: "dept_",
+			want:      []int64{underscore},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "literal escape character",
+			substring: "yahoo!",
+			want:      []int64{bang},
+			wantErr:   require.NoError,
+		},
+		{
+			name:      "blank query",
+			substring: "  ",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrEmptySearchQuery, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parcels, err := store.SearchByAddress(ctx, tt.substring)
+			tt.wantErr(t, err)
+			if err == nil {
+				require.Equal(t, tt.want, parcelNumbers(parcels))
+			}
+		})
+	}
+}
+
+func TestCountByStatus(t *testing.T) {
+	t.Parallel()
+
+	const query = "SELECT status, COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL GROUP BY status"
+
+	tests := []struct {
+		name    string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		want    map[ParcelStatus]int
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "missing statuses are zero",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(query)).
+					WithArgs(1000).
+					WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+						AddRow(ParcelStatusRegistered, 3).
+						AddRow(ParcelStatusDelivered, 10))
+			},
+			want: map[ParcelStatus]int{
+				ParcelStatusRegistered: 3,
+				ParcelStatusSent:       0,
+				ParcelStatusDelivered:  10,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "no parcels",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(query)).
+					WithArgs(1000).
+					WillReturnRows(sqlmock.NewRows([]string{"status", "count"}))
+			},
+			want: map[ParcelStatus]int{
+				ParcelStatusRegistered: 0,
+				ParcelStatusSent:       0,
+				ParcelStatusDelivered:  0,
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "database error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(query)).
+					WithArgs(1000).
+					WillReturnError(errors.New("database error"))
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.CountByStatus: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			counts, err := NewParcelStore(db).CountByStatus(context.Background(), 1000)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, counts)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestFindDuplicateAddresses(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		addresses []string
+		want      map[string][]int64
+	}{
+		{
+			name:      "no duplicates",
+			addresses: []string{"first address", "second address"},
+			want:      map[string][]int64{},
+		},
+		{
+			name:      "one group",
+			addresses: []string{"first address", "second address", "first address"},
+			want:      map[string][]int64{"first address": {1, 3}},
+		},
+		{
+			name:      "several groups",
+			addresses: []string{"first address", "second address", "first address", "second address", "third address", "second address"},
+			want: map[string][]int64{
+				"first address":  {1, 3},
+				"second address": {2, 4, 6},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := NewParcelStore(openTestDB(t))
+
+			for _, address := range tt.addresses {
+				parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: address, CreatedAt: createdAt}
+				require.NoError(t, store.Add(ctx, &parcel))
+			}
+			// The same address of another client is not a duplicate.
+			other := Parcel{Client: 2000, Status: ParcelStatusRegistered, Address: "third address", CreatedAt: createdAt}
+			require.NoError(t, store.Add(ctx, &other))
+
+			got, err := store.FindDuplicateAddresses(ctx, 1000)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFindDuplicateAddressesError(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dbMock.ExpectQuery(regexp.QuoteMeta("GROUP BY address HAVING COUNT(*) > 1")).
+		WithArgs(1000, 1000).
+		WillReturnError(errors.New("database error"))
+
+	got, err := NewParcelStore(db).FindDuplicateAddresses(context.Background(), 1000)
+	require.EqualError(t, err, "parcelstore.FindDuplicateAddresses: database error")
+	require.Nil(t, got)
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+// staleRefRepository hides the first external reference lookup, like a
+// concurrent registration that inserts the parcel after it was checked.
+type staleRefRepository struct {
+	ParcelRepository
+	lookups int
+}
+
+func (r *staleRefRepository) GetByExternalRef(ctx context.Context, ref string) (Parcel, error) {
+	r.lookups++
+	if r.lookups == 1 {
+		return Parcel{}, ErrParcelNotFound
+	}
+	return r.ParcelRepository.GetByExternalRef(ctx, ref)
+}
+
+func TestRegisterIdempotent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		ref     string
+		setup   func(t *testing.T, repo ParcelRepository) (ParcelRepository, Parcel)
+		wantNew bool
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "first insert",
+			ref:     "order-1",
+			wantNew: true,
+			wantErr: require.NoError,
+		},
+		{
+			name: "duplicate ref",
+			ref:  "order-1",
+			setup: func(t *testing.T, repo ParcelRepository) (ParcelRepository, Parcel) {
+				existing, err := NewParcelService(repo).RegisterIdempotent(ctx, 1000, "first address", "order-1")
+				require.NoError(t, err)
+				return repo, existing
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "duplicate ref inserted concurrently",
+			ref:  "order-1",
+			setup: func(t *testing.T, repo ParcelRepository) (ParcelRepository, Parcel) {
+				existing := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "first address", CreatedAt: time.Now().UTC(), ExternalRef: "order-1"}
+				require.NoError(t, repo.Add(ctx, &existing))
+				return &staleRefRepository{ParcelRepository: repo}, existing
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "empty ref",
+			ref:  "",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrEmptyExternalRef, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for name, repo := range repositories(t) {
+				t.Run(name, func(t *testing.T) {
+					var existing Parcel
+					if tt.setup != nil {
+						repo, existing = tt.setup(t, repo)
+					}
+
+					got, err := NewParcelService(repo, WithOutput(io.Discard)).RegisterIdempotent(ctx, 1000, "second address", tt.ref)
+					tt.wantErr(t, err)
+					if err != nil {
+						return
+					}
+
+					require.Equal(t, tt.ref, got.ExternalRef)
+					if !tt.wantNew {
+						require.Equal(t, existing.Number, got.Number)
+						require.Equal(t, existing.Address, got.Address)
+					}
+
+					parcels, err := repo.GetByClient(ctx, 1000)
+					require.NoError(t, err)
+					require.Len(t, parcels, 1)
+				})
+			}
+		})
+	}
+}
+
+func TestEachByClient(t *testing.T) {
+	t.Parallel()
+
+	const query = "SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number"
+
+	errStop := errors.New("stop")
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	parcels := []Parcel{
+		{Number: 1, Client: 1000, Status: ParcelStatusRegistered, Address: "first", CreatedAt: createdAt, Version: 1},
+		{Number: 2, Client: 1000, Status: ParcelStatusRegistered, Address: "second", CreatedAt: createdAt, Version: 1},
+		{Number: 3, Client: 1000, Status: ParcelStatusRegistered, Address: "third", CreatedAt: createdAt, Version: 1},
+	}
+
+	tests := []struct {
+		name        string
+		mocks       func(dbMock sqlmock.Sqlmock)
+		stopAfter   int
+		wantVisited []int64
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name: "visits every parcel",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(query)).
+					WithArgs(1000).
+					WillReturnRows(parcelRows(parcels...)).
+					RowsWillBeClosed()
+			},
+			wantVisited: []int64{1, 2, 3},
+			wantErr:     require.NoError,
+		},
+		{
+			name: "stops after the first parcel",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(query)).
+					WithArgs(1000).
+					WillReturnRows(parcelRows(parcels...)).
+					RowsWillBeClosed()
+			},
+			stopAfter:   1,
+			wantVisited: []int64{1},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errStop, i...)
+			},
+		},
+		{
+			name: "database error",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(regexp.QuoteMeta(query)).
+					WithArgs(1000).
+					WillReturnError(errors.New("database error"))
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.EachByClient: database error", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db)
+			tt.mocks(dbMock)
+
+			var visited []int64
+			err = store.EachByClient(context.Background(), 1000, func(p Parcel) error {
+				visited = append(visited, p.Number)
+				if len(visited) == tt.stopAfter {
+					return errStop
+				}
+				return nil
+			})
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantVisited, visited)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAddDimensions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		parcel  Parcel
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "measured",
+			parcel:  Parcel{WeightGrams: 1500, LengthMM: 300, WidthMM: 200, HeightMM: 100},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "unmeasured",
+			parcel:  Parcel{},
+			wantErr: require.NoError,
+		},
+		{
+			name:   "negative weight",
+			parcel: Parcel{WeightGrams: -1},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidDimensions, i...)
+			},
+		},
+		{
+			name:   "negative dimension",
+			parcel: Parcel{LengthMM: 300, WidthMM: -200, HeightMM: 100},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidDimensions, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			for name, repo := range repositories(t) {
+				t.Run(name, func(t *testing.T) {
+					ctx := context.Background()
+
+					parcel := tt.parcel
+					parcel.Client = 1000
+					parcel.Status = ParcelStatusRegistered
+					parcel.Address = "test address"
+					parcel.CreatedAt = time.Now().UTC()
+
+					err := repo.Add(ctx, &parcel)
+					tt.wantErr(t, err)
+					if err != nil {
+						require.Zero(t, parcel.Number)
+						return
+					}
+
+					got, err := repo.Get(ctx, int(parcel.Number))
+					require.NoError(t, err)
+					require.Equal(t, tt.parcel.WeightGrams, got.WeightGrams)
+					require.Equal(t, tt.parcel.LengthMM, got.LengthMM)
+					require.Equal(t, tt.parcel.WidthMM, got.WidthMM)
+					require.Equal(t, tt.parcel.HeightMM, got.HeightMM)
+				})
+			}
+		})
+	}
+}
+
+func TestVolumeMM3(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		parcel Parcel
+		want   int64
+	}{
+		{
+			name:   "box",
+			parcel: Parcel{LengthMM: 300, WidthMM: 200, HeightMM: 100},
+			want:   6_000_000,
+		},
+		{
+			name:   "unknown dimension",
+			parcel: Parcel{LengthMM: 300, WidthMM: 200},
+			want:   0,
+		},
+		{
+			name:   "large without overflow",
+			parcel: Parcel{LengthMM: 3_000_000, WidthMM: 2_000_000, HeightMM: 1_000_000},
+			want:   6_000_000_000_000_000_000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, tt.parcel.VolumeMM3())
+		})
+	}
+}
+
+func TestStatusTimestamps(t *testing.T) {
+	t.Parallel()
+
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			parcel := addParcel(t, repo, 1000, time.Now().UTC())
+			require.False(t, parcel.SentAt.Valid)
+			require.False(t, parcel.DeliveredAt.Valid)
+
+			require.NoError(t, repo.SetStatus(ctx, int(parcel.Number), ParcelStatusSent, parcel.Version))
+
+			sent, err := repo.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.True(t, sent.SentAt.Valid)
+			require.False(t, sent.DeliveredAt.Valid)
+			require.WithinDuration(t, time.Now(), sent.SentAt.Time, time.Minute)
+
+			_, ok := sent.TimeInTransit()
+			require.False(t, ok)
+
+			change, advanced, err := repo.AdvanceStatus(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.True(t, advanced)
+			require.Equal(t, ParcelStatusSent, change.OldStatus)
+			require.Equal(t, ParcelStatusDelivered, change.NewStatus)
+
+			delivered, err := repo.Get(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.True(t, delivered.DeliveredAt.Valid)
+			require.True(t, sent.SentAt.Time.Equal(delivered.SentAt.Time))
+			require.False(t, delivered.DeliveredAt.Time.Before(delivered.SentAt.Time))
+
+			transit, ok := delivered.TimeInTransit()
+			require.True(t, ok)
+			require.Equal(t, delivered.DeliveredAt.Time.Sub(delivered.SentAt.Time), transit)
+		})
+	}
+}
+
+func TestTimeInTransit(t *testing.T) {
+	t.Parallel()
+
+	sentAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		parcel Parcel
+		want   time.Duration
+		wantOK bool
+	}{
+		{
+			name: "delivered",
+			parcel: Parcel{
+				SentAt:      sql.NullTime{Time: sentAt, Valid: true},
+				DeliveredAt: sql.NullTime{Time: sentAt.Add(26 * time.Hour), Valid: true},
+			},
+			want:   26 * time.Hour,
+			wantOK: true,
+		},
+		{
+			name:   "in transit",
+			parcel: Parcel{SentAt: sql.NullTime{Time: sentAt, Valid: true}},
+		},
+		{
+			name:   "not sent",
+			parcel: Parcel{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := tt.parcel.TimeInTransit()
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParcelJSONStatusTimestamps(t *testing.T) {
+	t.Parallel()
+
+	parcel := Parcel{
+		Number:      101,
+		Status:      ParcelStatusDelivered,
+		CreatedAt:   time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC),
+		SentAt:      sql.NullTime{Time: time.Date(2023, 11, 21, 9, 0, 0, 0, time.UTC), Valid: true},
+		DeliveredAt: sql.NullTime{Time: time.Date(2023, 11, 22, 15, 45, 0, 0, time.UTC), Valid: true},
+		Priority:    5,
+	}
+
+	data, err := json.Marshal(parcel)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"sent_at":"2023-11-21T09:00:00Z"`)
+	require.Contains(t, string(data), `"delivered_at":"2023-11-22T15:45:00Z"`)
+	require.Contains(t, string(data), `"priority":5`)
+
+	var got Parcel
+	require.NoError(t, json.Unmarshal(data, &got))
+	require.Equal(t, parcel, got)
+}
+
+func TestDeleteManyRegistered(t *testing.T) {
+	t.Parallel()
+
+	const deleteRegistered = "DELETE FROM parcel WHERE number IN (?, ?) AND status = ?"
+
+	tests := []struct {
+		name        string
+		numbers     []int64
+		mocks       func(dbMock sqlmock.Sqlmock)
+		wantDeleted int64
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name:    "deletes registered parcels",
+			numbers: []int64{101, 102},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec(regexp.QuoteMeta(deleteRegistered)).
+					WithArgs(int64(101), int64(102), ParcelStatusRegistered).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantDeleted: 1,
+			wantErr:     require.NoError,
+		},
+		{
+			name:    "delete error",
+			numbers: []int64{101, 102},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec(regexp.QuoteMeta(deleteRegistered)).
+					WillReturnError(errors.New("database error"))
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.DeleteManyRegistered: database error", i...)
+			},
+		},
+		{
+			name:    "no numbers",
+			mocks:   func(dbMock sqlmock.Sqlmock) {},
+			wantErr: require.NoError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			deleted, err := NewParcelStore(db).DeleteManyRegistered(context.Background(), tt.numbers)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantDeleted, deleted)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteManyRegisteredSQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	first := addParcel(t, store, 1000, createdAt)
+	second := addParcel(t, store, 1000, createdAt)
+	sent := addParcel(t, store, 1000, createdAt)
+	delivered := addParcel(t, store, 1000, createdAt)
+	untouched := addParcel(t, store, 1000, createdAt)
+
+	require.NoError(t, store.SetStatus(ctx, int(sent.Number), ParcelStatusSent, sent.Version))
+	require.NoError(t, store.SetStatus(ctx, int(delivered.Number), ParcelStatusDelivered, delivered.Version))
+
+	deleted, err := store.DeleteManyRegistered(ctx, []int64{first.Number, second.Number, sent.Number, delivered.Number, 999})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	for _, number := range []int64{first.Number, second.Number} {
+		_, err = store.Get(ctx, int(number))
+		require.ErrorIs(t, err, ErrParcelNotFound)
+	}
+
+	for _, parcel := range []Parcel{sent, delivered, untouched} {
+		_, err = store.Get(ctx, int(parcel.Number))
+		require.NoError(t, err)
+	}
+}
+
+func TestSetStatusMany(t *testing.T) {
+	t.Parallel()
+
+	const (
+		selectStatuses = "SELECT number, status FROM parcel WHERE number IN (?, ?, ?) AND status <> ?"
+		updateStatuses = "UPDATE parcel SET status = ?, updated_at = ?, sent_at = COALESCE(sent_at, ?), delivered_at = NULL, version = version + 1 WHERE number IN (?, ?, ?) AND status <> ?"
+		insertHistory  = "INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)"
+	)
+
+	numbers := []int64{101, 102, 999}
+
+	tests := []struct {
+		name        string
+		numbers     []int64
+		status      ParcelStatus
+		mocks       func(dbMock sqlmock.Sqlmock)
+		wantChanged int64
+		wantErr     require.ErrorAssertionFunc
+	}{
+		{
+			name:    "existing and missing parcels",
+			numbers: numbers,
+			status:  ParcelStatusSent,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatuses)).
+					WithArgs(int64(101), int64(102), int64(999), ParcelStatusSent).
+					WillReturnRows(sqlmock.NewRows([]string{"number", "status"}).
+						AddRow(int64(101), ParcelStatusRegistered).
+						AddRow(int64(102), ParcelStatusRegistered))
+				dbMock.ExpectExec(regexp.QuoteMeta(updateStatuses)).
+					WithArgs(ParcelStatusSent, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(101), int64(102), int64(999), ParcelStatusSent).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				dbMock.ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(101, ParcelStatusRegistered, ParcelStatusSent, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(102, ParcelStatusRegistered, ParcelStatusSent, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				dbMock.ExpectCommit()
+			},
+			wantChanged: 2,
+			wantErr:     require.NoError,
+		},
+		{
+			name:    "update error",
+			numbers: numbers,
+			status:  ParcelStatusSent,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(regexp.QuoteMeta(selectStatuses)).
+					WillReturnRows(sqlmock.NewRows([]string{"number", "status"}).AddRow(int64(101), ParcelStatusRegistered))
+				dbMock.ExpectExec(regexp.QuoteMeta(updateStatuses)).
+					WillReturnError(errors.New("database error"))
+				dbMock.ExpectRollback()
+			},
+			wantChanged: 0,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.SetStatusMany: database error", i...)
+			},
+		},
+		{
+			name:        "no numbers",
+			numbers:     nil,
+			status:      ParcelStatusSent,
+			mocks:       func(dbMock sqlmock.Sqlmock) {},
+			wantChanged: 0,
+			wantErr:     require.NoError,
+		},
+		{
+			name:        "invalid status",
+			numbers:     numbers,
+			status:      "lost",
+			mocks:       func(dbMock sqlmock.Sqlmock) {},
+			wantChanged: 0,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			changed, err := NewParcelStore(db).SetStatusMany(context.Background(), tt.numbers, tt.status)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantChanged, changed)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSetStatusManySQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	first := addParcel(t, store, 1000, createdAt)
+	second := addParcel(t, store, 1000, createdAt)
+	alreadySent := addParcel(t, store, 1000, createdAt)
+	untouched := addParcel(t, store, 1000, createdAt)
+
+	require.NoError(t, store.SetStatus(ctx, int(alreadySent.Number), ParcelStatusSent, alreadySent.Version))
+	before, err := store.Get(ctx, int(alreadySent.Number))
+	require.NoError(t, err)
+
+	changed, err := store.SetStatusMany(ctx, []int64{first.Number, second.Number, alreadySent.Number, 999}, ParcelStatusSent)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), changed)
+
+	for _, number := range []int64{first.Number, second.Number} {
+		got, err := store.Get(ctx, int(number))
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusSent, got.Status)
+		require.True(t, got.SentAt.Valid)
+		require.Equal(t, 2, got.Version)
+
+		history, err := store.GetStatusHistory(ctx, int(number))
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.Equal(t, ParcelStatusRegistered, history[0].OldStatus)
+		require.Equal(t, ParcelStatusSent, history[0].NewStatus)
+	}
+
+	after, err := store.Get(ctx, int(alreadySent.Number))
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	got, err := store.Get(ctx, int(untouched.Number))
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusRegistered, got.Status)
+}
+
+func TestSentinelErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		call   func(store ParcelStore, service ParcelService, sent Parcel) error
+		target error
+	}{
+		{
+			name: "nil parcel",
+			call: func(store ParcelStore, _ ParcelService, _ Parcel) error {
+				return store.Add(ctx, nil)
+			},
+			target: ErrNilParcel,
+		},
+		{
+			name: "missing parcel",
+			call: func(store ParcelStore, _ ParcelService, _ Parcel) error {
+				_, err := store.Get(ctx, 999)
+				return err
+			},
+			target: ErrParcelNotFound,
+		},
+		{
+			name: "invalid status",
+			call: func(store ParcelStore, _ ParcelService, sent Parcel) error {
+				return store.SetStatus(ctx, int(sent.Number), "lost", sent.Version)
+			},
+			target: ErrInvalidStatus,
+		},
+		{
+			name: "parcel not deletable",
+			call: func(_ ParcelStore, service ParcelService, sent Parcel) error {
+				return service.Delete(ctx, int(sent.Number))
+			},
+			target: ErrParcelNotDeletable,
+		},
+		{
+			name: "invalid client",
+			call: func(_ ParcelStore, service ParcelService, _ Parcel) error {
+				_, err := service.Register(ctx, 0, "test address")
+				return err
+			},
+			target: ErrInvalidClient,
+		},
+		{
+			name: "invalid client listing",
+			call: func(_ ParcelStore, service ParcelService, _ Parcel) error {
+				return service.PrintClientParcels(ctx, -1)
+			},
+			target: ErrInvalidClient,
+		},
+		{
+			name: "empty address",
+			call: func(_ ParcelStore, service ParcelService, _ Parcel) error {
+				_, err := service.Register(ctx, 1000, " ")
+				return err
+			},
+			target: ErrEmptyAddress,
+		},
+		{
+			name: "empty operator",
+			call: func(store ParcelStore, _ ParcelService, _ Parcel) error {
+				_, err := store.GetByOperator(ctx, "")
+				return err
+			},
+			target: ErrEmptyOperator,
+		},
+		{
+			name: "invalid reserve count",
+			call: func(store ParcelStore, _ ParcelService, _ Parcel) error {
+				_, err := store.ReserveNumbers(ctx, 0)
+				return err
+			},
+			target: ErrInvalidReserveCount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := NewParcelStore(openTestDB(t))
+			service := NewParcelService(store, WithOutput(io.Discard))
+
+			sent := addParcel(t, store, 1000, time.Now().UTC())
+			require.NoError(t, service.NextStatus(ctx, int(sent.Number)))
+			sent, err := store.Get(ctx, int(sent.Number))
+			require.NoError(t, err)
+
+			err = tt.call(store, service, sent)
+			require.ErrorIs(t, err, tt.target)
+			require.ErrorIs(t, fmt.Errorf("caller: %w", err), tt.target)
+		})
+	}
+}
+
+// slowRepository is a ParcelRepository whose Get blocks until its
+// context is done, recording the deadline it was given. Without a
+// deadline it serves the parcel from the embedded repository.
+type slowRepository struct {
+	ParcelRepository
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func (r *slowRepository) Get(ctx context.Context, number int) (Parcel, error) {
+	r.deadline, r.hasDeadline = ctx.Deadline()
+	if !r.hasDeadline {
+		return r.ParcelRepository.Get(ctx, number)
+	}
+
+	<-ctx.Done()
+	return Parcel{}, ctx.Err()
+}
+
+func TestOperationTimeout(t *testing.T) {
+	t.Parallel()
+
+	callerDeadline := time.Now().Add(50 * time.Millisecond)
+
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		ctx          func() (context.Context, context.CancelFunc)
+		wantDeadline bool
+		// exactDeadline, if set, is the deadline the store must see.
+		exactDeadline time.Time
+		wantErr       require.ErrorAssertionFunc
+	}{
+		{
+			name:    "default timeout cancels slow call",
+			timeout: 20 * time.Millisecond,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			wantDeadline: true,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, context.DeadlineExceeded, i...)
+			},
+		},
+		{
+			name:    "caller deadline is kept",
+			timeout: time.Hour,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithDeadline(context.Background(), callerDeadline)
+			},
+			wantDeadline:  true,
+			exactDeadline: callerDeadline,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, context.DeadlineExceeded, i...)
+			},
+		},
+		{
+			name:    "zero timeout disables it",
+			timeout: 0,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			wantDeadline: false,
+			wantErr:      require.NoError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := NewMemoryStore()
+			parcel := addParcel(t, store, 1000, time.Now().UTC())
+
+			repo := &slowRepository{ParcelRepository: store}
+			service := NewParcelService(repo, WithOutput(io.Discard), WithOperationTimeout(tt.timeout))
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			_, err := service.Get(ctx, int(parcel.Number))
+			tt.wantErr(t, err)
+			require.Less(t, time.Since(start), time.Second)
+
+			require.Equal(t, tt.wantDeadline, repo.hasDeadline)
+			if !tt.exactDeadline.IsZero() {
+				require.Equal(t, tt.exactDeadline, repo.deadline)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by RateLimitedRegistrar.Register and
+// RegisterBy when the client has used up its registrations for now.
+var ErrRateLimited = errors.New("registration rate limit exceeded")
+
+// Registrar registers new parcels. ParcelService implements it.
+type Registrar interface {
+	// Register registers a new parcel of client for delivery to address.
+	Register(ctx context.Context, client int64, address string) (Parcel, error)
+	// RegisterBy is Register for a parcel registered by operator.
+	RegisterBy(ctx context.Context, client int64, address, operator string) (Parcel, error)
+}
+
+var _ Registrar = ParcelService{}
+
+// RateLimitedRegistrar is a Registrar decorator capping how fast each
+// client can register parcels, to protect against abuse.
+//
+// Every client has its own token bucket holding up to burst tokens,
+// refilled at rate tokens per second. A registration takes a token,
+// also when it then fails, and is rejected with ErrRateLimited when the
+// bucket is empty. Buckets that have refilled to the burst are dropped,
+// as they behave like the fresh bucket of a new client, so idle clients
+// do not hold memory. It is safe for concurrent use.
+type RateLimitedRegistrar struct {
+	next  Registrar
+	rate  float64
+	burst int
+	clock Clock
+
+	// mu guards buckets and swept.
+	mu sync.Mutex
+	// buckets holds the token bucket of every client that is not full.
+	buckets map[int64]*tokenBucket
+	// swept is when idle buckets were last dropped.
+	swept time.Time
+}
+
+// tokenBucket is the state of the token bucket of one client.
+type tokenBucket struct {
+	// tokens is the number of registrations the client can make now.
+	tokens float64
+	// updated is when tokens was last refilled.
+	updated time.Time
+}
+
+// RateLimitOption configures a RateLimitedRegistrar.
+type RateLimitOption func(*RateLimitedRegistrar)
+
+// WithRateLimitClock makes the limiter read the current time from
+// clock, so tests can control how the buckets refill. A nil clock keeps
+// the system clock.
+func WithRateLimitClock(clock Clock) RateLimitOption {
+	return func(r *RateLimitedRegistrar) {
+		if clock != nil {
+			r.clock = clock
+		}
+	}
+}
+
+// NewRateLimitedRegistrar wraps next so that each client can register
+// at most burst parcels at once and rate parcels per second on average.
+//
+// Parameters:
+//   - next: the registrar the allowed registrations are passed to,
+//     usually a ParcelService.
+//   - rate: how many tokens per second a client's bucket regains; zero
+//     or less never refills it.
+//   - burst: the capacity of a client's bucket, which starts full;
+//     values below one are raised to one.
+//   - opts: Optional settings such as the clock.
+//
+// Returns:
+// - The rate-limited registrar.
+func NewRateLimitedRegistrar(next Registrar, rate float64, burst int, opts ...RateLimitOption) *RateLimitedRegistrar {
+	r := &RateLimitedRegistrar{
+		next:    next,
+		rate:    max(rate, 0),
+		burst:   max(burst, 1),
+		clock:   realClock{},
+		buckets: make(map[int64]*tokenBucket),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Register registers a new parcel with the wrapped registrar if the
+// client has a token left.
+//
+// Returns:
+//   - The created Parcel, as returned by the wrapped registrar.
+//   - An error wrapping ErrRateLimited if the client registers too
+//     fast, or the error of the wrapped registrar.
+func (r *RateLimitedRegistrar) Register(ctx context.Context, client int64, address string) (Parcel, error) {
+	if !r.take(client) {
+		return Parcel{}, fmt.Errorf("%w: client %d", ErrRateLimited, client)
+	}
+
+	return r.next.Register(ctx, client, address)
+}
+
+// RegisterBy is Register for a parcel registered by operator. It takes
+// a token from the same bucket of the client.
+func (r *RateLimitedRegistrar) RegisterBy(ctx context.Context, client int64, address, operator string) (Parcel, error) {
+	if !r.take(client) {
+		return Parcel{}, fmt.Errorf("%w: client %d", ErrRateLimited, client)
+	}
+
+	return r.next.RegisterBy(ctx, client, address, operator)
+}
+
+// take refills the bucket of client for the time passed since its last
+// use and takes a token from it, reporting whether there was one.
+func (r *RateLimitedRegistrar) take(client int64) bool {
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(now)
+
+	bucket, ok := r.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(r.burst), updated: now}
+		r.buckets[client] = bucket
+	}
+
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens = min(bucket.tokens+elapsed.Seconds()*r.rate, float64(r.burst))
+		bucket.updated = now
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
+
+// sweep drops the buckets that have refilled to the burst since their
+// last use. It runs at most once per refill window, the time an empty
+// bucket takes to fill up, so its cost is spread over the registrations
+// made in between. Without a rate buckets never refill and are kept.
+// The caller must hold r.mu.
+func (r *RateLimitedRegistrar) sweep(now time.Time) {
+	if r.rate <= 0 {
+		return
+	}
+
+	window := time.Duration(float64(r.burst) / r.rate * float64(time.Second))
+	if now.Sub(r.swept) < window {
+		return
+	}
+
+	for client, bucket := range r.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*r.rate >= float64(r.burst) {
+			delete(r.buckets, client)
+		}
+	}
+
+	r.swept = now
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitedRegistrar(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)}
+	service := NewParcelService(NewMemoryStore(), WithOutput(io.Discard))
+	registrar := NewRateLimitedRegistrar(service, 0.5, 3, WithRateLimitClock(clock))
+
+	register := func(client int64) error {
+		_, err := registrar.Register(ctx, client, "test address")
+		return err
+	}
+
+	// The bucket starts full: the burst is allowed at once.
+	for range 3 {
+		require.NoError(t, register(1000))
+	}
+
+	err := register(1000)
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	// Other clients have their own buckets.
+	require.NoError(t, register(2000))
+
+	// Half a token is not enough.
+	clock.now = clock.now.Add(time.Second)
+	require.ErrorIs(t, register(1000), ErrRateLimited)
+
+	// Two seconds in total refill one token at 0.5 per second.
+	clock.now = clock.now.Add(time.Second)
+	require.NoError(t, register(1000))
+	require.ErrorIs(t, register(1000), ErrRateLimited)
+
+	// A long pause refills the bucket up to the burst only.
+	clock.now = clock.now.Add(time.Hour)
+	for range 3 {
+		require.NoError(t, register(1000))
+	}
+	require.ErrorIs(t, register(1000), ErrRateLimited)
+
+	parcels, err := service.store.GetByClient(ctx, 1000)
+	require.NoError(t, err)
+	require.Len(t, parcels, 7)
+}
+
+func TestRateLimitedRegistrarSettings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		allowed int
+	}{
+		{name: "burst below one allows one", rate: 1, burst: 0, allowed: 1},
+		{name: "no rate never refills", rate: 0, burst: 2, allowed: 2},
+		{name: "negative rate never refills", rate: -1, burst: 2, allowed: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			clock := &fakeClock{now: time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)}
+			service := NewParcelService(NewMemoryStore(), WithOutput(io.Discard))
+			registrar := NewRateLimitedRegistrar(service, tt.rate, tt.burst, WithRateLimitClock(clock))
+
+			for range tt.allowed {
+				_, err := registrar.Register(ctx, 1000, "test address")
+				require.NoError(t, err)
+			}
+
+			_, err := registrar.Register(ctx, 1000, "test address")
+			require.ErrorIs(t, err, ErrRateLimited)
+
+			if tt.rate <= 0 {
+				clock.now = clock.now.Add(time.Hour)
+
+				_, err = registrar.Register(ctx, 1000, "test address")
+				require.ErrorIs(t, err, ErrRateLimited)
+			}
+		})
+	}
+}
+
+func TestRateLimitedRegistrarEvictsIdleBuckets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)}
+	service := NewParcelService(NewMemoryStore(), WithOutput(io.Discard))
+	// An empty bucket refills in two seconds.
+	registrar := NewRateLimitedRegistrar(service, 1, 2, WithRateLimitClock(clock))
+
+	register := func(client int64) error {
+		_, err := registrar.Register(ctx, client, "test address")
+		return err
+	}
+
+	// The first registration sweeps the empty map; the next sweep is due
+	// two seconds later.
+	for client := int64(1000); client < 1003; client++ {
+		require.NoError(t, register(client))
+	}
+	require.Len(t, registrar.buckets, 3)
+
+	// One second later the sweep is not due yet. Client 1003 uses up
+	// its bucket.
+	clock.now = clock.now.Add(time.Second)
+	require.NoError(t, register(1003))
+	require.NoError(t, register(1003))
+	require.Len(t, registrar.buckets, 4)
+
+	// After the window, the full buckets of 1000 to 1002 are dropped,
+	// while 1003 has refilled one and a half tokens only and keeps its
+	// state.
+	clock.now = clock.now.Add(1500 * time.Millisecond)
+	require.NoError(t, register(1003))
+	require.Len(t, registrar.buckets, 1)
+	require.ErrorIs(t, register(1003), ErrRateLimited)
+
+	// An evicted client starts again with a full bucket.
+	require.NoError(t, register(1001))
+	require.NoError(t, register(1001))
+	require.ErrorIs(t, register(1001), ErrRateLimited)
+}
+
+func TestRateLimitedRegistrarKeepsBucketsWithoutRate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)}
+	service := NewParcelService(NewMemoryStore(), WithOutput(io.Discard))
+	registrar := NewRateLimitedRegistrar(service, 0, 1, WithRateLimitClock(clock))
+
+	_, err := registrar.Register(ctx, 1000, "test address")
+	require.NoError(t, err)
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	_, err = registrar.Register(ctx, 1001, "test address")
+	require.NoError(t, err)
+	require.Len(t, registrar.buckets, 2)
+
+	_, err = registrar.Register(ctx, 1000, "test address")
+	require.ErrorIs(t, err, ErrRateLimited)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// ReconnectingDB is a database connection pool that can be opened
+// again from its Config when the database stops answering, for example
+// after a network failure or a database restart.
+//
+// Stores created with WithReconnectingDB always use the current pool,
+// so they recover once Reconnect succeeds. It is safe for concurrent
+// use.
+type ReconnectingDB struct {
+	// cfg describes how to open the database.
+	cfg Config
+	// open opens a new pool from cfg; it is openDB outside tests.
+	open func(ctx context.Context, cfg Config) (*sql.DB, func(context.Context) error, error)
+
+	// mu guards db and closeFunc.
+	mu sync.RWMutex
+	// db is the current pool.
+	db *sql.DB
+	// closeFunc closes the current pool.
+	closeFunc func(context.Context) error
+}
+
+// NewReconnectingDB opens the database described by cfg like openDB
+// and keeps cfg to open it again on Reconnect.
+//
+// Parameters:
+// - ctx: the context bounding the initial connectivity check.
+// - cfg: the database settings, kept for reconnecting.
+//
+// Returns:
+// - The connected database.
+// - An error, if the database cannot be opened or reached.
+func NewReconnectingDB(ctx context.Context, cfg Config) (*ReconnectingDB, error) {
+	r := &ReconnectingDB{cfg: cfg, open: openDB}
+
+	db, closeFunc, err := r.open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.db, r.closeFunc = db, closeFunc
+
+	return r, nil
+}
+
+// DB returns the current connection pool. The pool changes when
+// Reconnect replaces it, so callers should not keep it.
+func (r *ReconnectingDB) DB() *sql.DB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.db
+}
+
+// Reconnect checks the current pool with Ping and, if the database
+// does not answer, opens a new pool from the stored Config and closes
+// the old one. A healthy pool is kept as it is.
+//
+// Parameters:
+// - ctx: the context bounding the ping and the reconnection.
+//
+// Returns:
+// - An error, if the database is unreachable and cannot be reopened;
+// the old pool is kept in that case.
+func (r *ReconnectingDB) Reconnect(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	pingErr := r.db.PingContext(pingCtx)
+	if pingErr == nil {
+		return nil
+	}
+
+	db, closeFunc, err := r.open(ctx, r.cfg)
+	if err != nil {
+		return fmt.Errorf("reconnect after %v: %w", pingErr, err)
+	}
+
+	// The old pool is dead: close it without waiting for its queries,
+	// which cannot complete anyway.
+	_ = r.db.Close()
+
+	r.db, r.closeFunc = db, closeFunc
+
+	return nil
+}
+
+// Close waits for the queries of the current pool like closeDB and
+// closes it.
+func (r *ReconnectingDB) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.closeFunc(ctx)
+}
+
+// WithReconnectingDB makes the store run its queries on the current
+// pool of conn instead of the one passed to NewParcelStore, so it
+// recovers after conn.Reconnect.
+//
+// Prepared statements are bound to a pool and would not survive a
+// reconnect, so this option disables them.
+func WithReconnectingDB(conn *ReconnectingDB) StoreOption {
+	return func(s *ParcelStore) {
+		if conn != nil {
+			s.conn = conn
+			s.stmts = nil
+		}
+	}
+}
+
+// pool returns the connection pool the store runs its queries on.
+func (s ParcelStore) pool() *sql.DB {
+	if s.conn != nil {
+		return s.conn.DB()
+	}
+
+	return s.db
+}
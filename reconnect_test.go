@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		dsn     string
+		mocks   func(dbMock sqlmock.Sqlmock)
+		wantNew bool
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name: "healthy connection is kept",
+			dsn:  "reconnect-healthy",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectPing()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name: "dead connection is reopened",
+			dsn:  "reconnect-dead",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectPing().WillReturnError(errors.New("connection reset"))
+				dbMock.ExpectPing()
+				dbMock.ExpectClose()
+			},
+			wantNew: true,
+			wantErr: require.NoError,
+		},
+		{
+			name: "unreachable database keeps the old connection",
+			dsn:  "reconnect-unreachable",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectPing().WillReturnError(errors.New("connection reset"))
+				dbMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+				dbMock.ExpectClose()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "reconnect after connection reset: ping database: connection refused", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockDB, dbMock, err := sqlmock.NewWithDSN(tt.dsn, sqlmock.MonitorPingsOption(true))
+			require.NoError(t, err)
+			defer mockDB.Close()
+
+			ctx := context.Background()
+			cfg := Config{Driver: "sqlmock", DSN: tt.dsn, MaxOpenConns: 1, MaxIdleConns: 1}
+
+			dbMock.ExpectPing()
+			conn, err := NewReconnectingDB(ctx, cfg)
+			require.NoError(t, err)
+
+			old := conn.DB()
+			tt.mocks(dbMock)
+
+			tt.wantErr(t, conn.Reconnect(ctx))
+			require.Equal(t, tt.wantNew, conn.DB() != old)
+
+			dbMock.ExpectClose()
+			require.NoError(t, conn.Close(ctx))
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReconnectStore(t *testing.T) {
+	t.Parallel()
+
+	const dsn = "reconnect-store"
+
+	mockDB, dbMock, err := sqlmock.NewWithDSN(dsn, sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer mockDB.Close()
+
+	ctx := context.Background()
+
+	dbMock.ExpectPing()
+	conn, err := NewReconnectingDB(ctx, Config{Driver: "sqlmock", DSN: dsn, MaxOpenConns: 1, MaxIdleConns: 1})
+	require.NoError(t, err)
+
+	store := NewParcelStore(conn.DB(), WithReconnectingDB(conn))
+
+	dbMock.ExpectPing().WillReturnError(errors.New("connection reset"))
+	require.Error(t, store.Ping(ctx))
+
+	dbMock.ExpectPing().WillReturnError(errors.New("connection reset"))
+	dbMock.ExpectPing()
+	dbMock.ExpectClose()
+	require.NoError(t, conn.Reconnect(ctx))
+
+	// The store runs its queries on the new connection, ad hoc.
+	dbMock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL")).
+		WithArgs(1000).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	count, err := store.CountByClient(ctx, 1000)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	dbMock.ExpectClose()
+	require.NoError(t, conn.Close(ctx))
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,32 @@
+package main
+
+import "database/sql"
+
+// WithReader makes the store send its read-only queries, such as Get,
+// GetByClient, CountByStatus or GetStatusHistory, to a separate
+// database, typically a read replica, while every write keeps using
+// the database passed to NewParcelStore. A nil reader keeps all
+// queries on that database.
+//
+// Replicas may lag behind, so a parcel just written can be missing from
+// the reads for a moment. Reads inside WithTx always use the
+// transaction. The store does not close the reader.
+func WithReader(reader *sql.DB) StoreOption {
+	return func(s *ParcelStore) {
+		s.reader = reader
+	}
+}
+
+// forReads returns the store to run a read-only query on: a copy bound
+// to the reader database if one is set and the store is not in a
+// transaction, or the store itself otherwise. Statements are prepared
+// on the writer, so the copy runs its queries ad hoc.
+func (s ParcelStore) forReads() ParcelStore {
+	if s.reader == nil || s.tx != nil {
+		return s
+	}
+
+	s.db, s.conn, s.stmts = s.reader, nil, nil
+
+	return s
+}
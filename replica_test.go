@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithReader(t *testing.T) {
+	t.Parallel()
+
+	getQuery := "SELECT " + parcelColumns + " FROM parcel WHERE number = ? AND deleted_at IS NULL"
+
+	tests := []struct {
+		name        string
+		noReader    bool
+		readerMocks func(dbMock sqlmock.Sqlmock)
+		writerMocks func(dbMock sqlmock.Sqlmock)
+		call        func(ctx context.Context, store ParcelStore) error
+	}{
+		{
+			name: "get reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(getQuery).WillReturnRows(parcelRows(Parcel{Number: 1}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Get(ctx, 1)
+				return err
+			},
+		},
+		{
+			name: "get by client reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY number").
+					WillReturnRows(parcelRows(Parcel{Number: 1, Client: 1000}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByClient(ctx, 1000)
+				return err
+			},
+		},
+		{
+			name: "count by client reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.CountByClient(ctx, 1000)
+				return err
+			},
+		},
+		{
+			name: "count by status reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT status, COUNT(*) FROM parcel WHERE client = ? AND deleted_at IS NULL GROUP BY status").
+					WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow(ParcelStatusSent, 2))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.CountByStatus(ctx, 1000)
+				return err
+			},
+		},
+		{
+			name: "get by client and status reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND status = ? AND deleted_at IS NULL").
+					WillReturnRows(parcelRows(Parcel{Number: 1, Client: 1000, Status: ParcelStatusSent}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByClientAndStatus(ctx, 1000, ParcelStatusSent)
+				return err
+			},
+		},
+		{
+			name: "get latest by client reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE client = ? AND deleted_at IS NULL ORDER BY created_at DESC, number DESC LIMIT 1").
+					WillReturnRows(parcelRows(Parcel{Number: 1, Client: 1000}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetLatestByClient(ctx, 1000)
+				return err
+			},
+		},
+		{
+			name: "exists reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT EXISTS(SELECT 1 FROM parcel WHERE number = ? AND deleted_at IS NULL)").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Exists(ctx, 1)
+				return err
+			},
+		},
+		{
+			name: "get by numbers reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel WHERE number IN (?, ?) AND deleted_at IS NULL ORDER BY number").
+					WillReturnRows(parcelRows(Parcel{Number: 1}, Parcel{Number: 2}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByNumbers(ctx, []int64{1, 2})
+				return err
+			},
+		},
+		{
+			name: "get status history reads from the reader",
+			readerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT number, old_status, new_status, changed_at FROM parcel_status_history WHERE number = ? ORDER BY id").
+					WillReturnRows(sqlmock.NewRows([]string{"number", "old_status", "new_status", "changed_at"}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetStatusHistory(ctx, 1)
+				return err
+			},
+		},
+		{
+			name: "add writes to the writer",
+			writerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(insertParcelQuery(TableName{})).WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectExec("UPDATE parcel SET tracking_code = ? WHERE number = ?").WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Add(ctx, &Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address"})
+			},
+		},
+		{
+			name: "set status writes to the writer",
+			writerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery("SELECT status FROM parcel WHERE number = ?").
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.ExpectExec("UPDATE parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND version = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectExec("INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetStatus(ctx, 1, ParcelStatusSent, 1)
+			},
+		},
+		{
+			name: "set address writes to the writer",
+			writerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND version = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetAddress(ctx, 1, "new address", 1)
+			},
+		},
+		{
+			name: "delete writes to the writer",
+			writerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("DELETE FROM parcel WHERE number = ? AND status = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Delete(ctx, 1)
+			},
+		},
+		{
+			name: "reads in a transaction use the writer",
+			writerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery(getQuery).WillReturnRows(parcelRows(Parcel{Number: 1}))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.WithTx(ctx, func(tx ParcelStore) error {
+					_, err := tx.Get(ctx, 1)
+					return err
+				})
+			},
+		},
+		{
+			name:     "without a reader reads use the writer",
+			noReader: true,
+			writerMocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery(getQuery).WillReturnRows(parcelRows(Parcel{Number: 1}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Get(ctx, 1)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			writer, writerMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer writer.Close()
+
+			reader, readerMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer reader.Close()
+
+			if tt.readerMocks != nil {
+				tt.readerMocks(readerMock)
+			}
+			if tt.writerMocks != nil {
+				tt.writerMocks(writerMock)
+			}
+
+			opts := []StoreOption{WithPreparedStatements(false)}
+			if !tt.noReader {
+				opts = append(opts, WithReader(reader))
+			}
+
+			require.NoError(t, tt.call(context.Background(), NewParcelStore(writer, opts...)))
+
+			require.NoError(t, readerMock.ExpectationsWereMet())
+			require.NoError(t, writerMock.ExpectationsWereMet())
+		})
+	}
+}
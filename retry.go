@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// Defaults used by NewRetryingStore.
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 10 * time.Millisecond
+)
+
+// SQLite result codes that signal lock contention.
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// RetryingStore is a ParcelRepository decorator that retries write
+// operations failing with a transient error.
+//
+// Add, SetStatus, AdvanceStatus, SetAddress, SetAddressWithReason and
+// Delete are retried up to a configured number of times with
+// exponential backoff, as long as the error is classified as retryable.
+// Reads are passed through unchanged.
+type RetryingStore struct {
+	next        ParcelRepository
+	maxRetries  int
+	backoff     time.Duration
+	isRetryable func(error) bool
+	// sleep waits between attempts; tests replace it to avoid delays.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+var _ ParcelRepository = RetryingStore{}
+
+// RetryOption configures a RetryingStore.
+type RetryOption func(*RetryingStore)
+
+// WithMaxRetries sets how many times a failed write is retried. Zero
+// disables retries; negative values are ignored.
+func WithMaxRetries(n int) RetryOption {
+	return func(s *RetryingStore) {
+		if n >= 0 {
+			s.maxRetries = n
+		}
+	}
+}
+
+// WithRetryBackoff sets the delay before the first retry. Each further
+// retry waits twice as long as the previous one.
+func WithRetryBackoff(d time.Duration) RetryOption {
+	return func(s *RetryingStore) {
+		if d >= 0 {
+			s.backoff = d
+		}
+	}
+}
+
+// WithRetryClassifier replaces IsRetryable as the function deciding
+// which errors are transient. A nil function keeps the default.
+func WithRetryClassifier(isRetryable func(error) bool) RetryOption {
+	return func(s *RetryingStore) {
+		if isRetryable != nil {
+			s.isRetryable = isRetryable
+		}
+	}
+}
+
+// NewRetryingStore wraps next so that its writes are retried on
+// transient errors.
+//
+// By default a write is retried 3 times, starting with a 10ms backoff,
+// and errors are classified with IsRetryable.
+func NewRetryingStore(next ParcelRepository, opts ...RetryOption) RetryingStore {
+	store := RetryingStore{
+		next:        next,
+		maxRetries:  defaultMaxRetries,
+		backoff:     defaultRetryBackoff,
+		isRetryable: IsRetryable,
+		sleep:       sleepContext,
+	}
+	for _, opt := range opts {
+		opt(&store)
+	}
+
+	return store
+}
+
+// IsRetryable reports whether err is a transient database error worth
+// retrying: SQLite busy or locked errors, Postgres serialization
+// failures, deadlocks and lock timeouts, and driver.ErrBadConn. These
+// all mean the statement did not take effect. Postgres connection
+// errors (SQLSTATE class 08) are not retryable, as the connection may
+// have been lost after a write was committed and retrying would apply
+// it twice. Context cancellation is never retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) {
+		switch coded.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return true
+		}
+	}
+
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case "40001", "40P01", "55P03":
+			return true
+		}
+	}
+
+	return false
+}
+
+// retry runs op until it succeeds, fails with a non-retryable error, or
+// the retries are exhausted. It returns the last error.
+func (s RetryingStore) retry(ctx context.Context, op func() error) error {
+	delay := s.backoff
+
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= s.maxRetries || !s.isRetryable(err) {
+			return err
+		}
+
+		if sleepErr := s.sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+
+		delay *= 2
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Add stores a new parcel, retrying on transient errors.
+func (s RetryingStore) Add(ctx context.Context, p *Parcel) error {
+	return s.retry(ctx, func() error {
+		return s.next.Add(ctx, p)
+	})
+}
+
+// AddMany stores several new parcels, retrying on transient errors.
+// The batch is atomic, so a failed attempt stores none of them.
+func (s RetryingStore) AddMany(ctx context.Context, parcels []*Parcel) error {
+	return s.retry(ctx, func() error {
+		return s.next.AddMany(ctx, parcels)
+	})
+}
+
+// AddWithinLimit stores several new parcels of one client if they fit
+// its active parcel limit, retrying on transient errors.
+func (s RetryingStore) AddWithinLimit(ctx context.Context, parcels []*Parcel, limit int) error {
+	return s.retry(ctx, func() error {
+		return s.next.AddWithinLimit(ctx, parcels, limit)
+	})
+}
+
+// Get returns the parcel with the given number.
+func (s RetryingStore) Get(ctx context.Context, number int) (Parcel, error) {
+	return s.next.Get(ctx, number)
+}
+
+// GetByExternalRef returns the parcel with the given external reference.
+func (s RetryingStore) GetByExternalRef(ctx context.Context, ref string) (Parcel, error) {
+	return s.next.GetByExternalRef(ctx, ref)
+}
+
+// GetByClient returns all parcels of the given client.
+func (s RetryingStore) GetByClient(ctx context.Context, client int) ([]Parcel, error) {
+	return s.next.GetByClient(ctx, client)
+}
+
+// GetByClientAndStatus returns the parcels of the given client in the
+// given status.
+func (s RetryingStore) GetByClientAndStatus(ctx context.Context, client int, status ParcelStatus) ([]Parcel, error) {
+	return s.next.GetByClientAndStatus(ctx, client, status)
+}
+
+// GetLatestByClient returns the most recently created parcel of the
+// given client.
+func (s RetryingStore) GetLatestByClient(ctx context.Context, client int) (Parcel, error) {
+	return s.next.GetLatestByClient(ctx, client)
+}
+
+// CountByStatus returns the number of parcels of the given client in
+// each status.
+func (s RetryingStore) CountByStatus(ctx context.Context, client int) (map[ParcelStatus]int, error) {
+	return s.next.CountByStatus(ctx, client)
+}
+
+// GetStatusHistory returns the status changes of the given parcel.
+func (s RetryingStore) GetStatusHistory(ctx context.Context, number int) ([]StatusChange, error) {
+	return s.next.GetStatusHistory(ctx, number)
+}
+
+// GetCreatedBetween returns the parcels in the given status created in
+// the interval [from, to).
+func (s RetryingStore) GetCreatedBetween(ctx context.Context, status ParcelStatus, from, to time.Time) ([]Parcel, error) {
+	return s.next.GetCreatedBetween(ctx, status, from, to)
+}
+
+// SetStatus changes the status of the given parcel, retrying on
+// transient errors.
+func (s RetryingStore) SetStatus(ctx context.Context, number int, status ParcelStatus, version int) error {
+	return s.retry(ctx, func() error {
+		return s.next.SetStatus(ctx, number, status, version)
+	})
+}
+
+// AdvanceStatus moves the given parcel to its next status, retrying on
+// transient errors.
+func (s RetryingStore) AdvanceStatus(ctx context.Context, number int) (change StatusChange, advanced bool, err error) {
+	err = s.retry(ctx, func() error {
+		var opErr error
+		change, advanced, opErr = s.next.AdvanceStatus(ctx, number)
+		return opErr
+	})
+
+	return change, advanced, err
+}
+
+// SetAddress changes the address of the given parcel, retrying on
+// transient errors.
+func (s RetryingStore) SetAddress(ctx context.Context, number int, address string, version int) error {
+	return s.retry(ctx, func() error {
+		return s.next.SetAddress(ctx, number, address, version)
+	})
+}
+
+// SetAddressWithReason changes the address of the given parcel and
+// records the reason, retrying on transient errors.
+func (s RetryingStore) SetAddressWithReason(ctx context.Context, number int, address, reason string, version int) error {
+	return s.retry(ctx, func() error {
+		return s.next.SetAddressWithReason(ctx, number, address, reason, version)
+	})
+}
+
+// GetAddressHistory returns the address changes of the given parcel.
+func (s RetryingStore) GetAddressHistory(ctx context.Context, number int) ([]AddressChange, error) {
+	return s.next.GetAddressHistory(ctx, number)
+}
+
+// Delete removes the given parcel if it is still registered, retrying
+// on transient errors.
+func (s RetryingStore) Delete(ctx context.Context, number int) error {
+	return s.retry(ctx, func() error {
+		return s.next.Delete(ctx, number)
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// errTransient is classified as retryable by the tests' classifier.
+var errTransient = errors.New("database is locked")
+
+// flakyRepository is a ParcelRepository whose writes fail with the
+// queued errors before delegating to a MemoryStore.
+type flakyRepository struct {
+	*MemoryStore
+	failures []error
+	calls    int
+}
+
+func (f *flakyRepository) fail() error {
+	f.calls++
+	if len(f.failures) == 0 {
+		return nil
+	}
+
+	err := f.failures[0]
+	f.failures = f.failures[1:]
+	return err
+}
+
+func (f *flakyRepository) Add(ctx context.Context, p *Parcel) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.MemoryStore.Add(ctx, p)
+}
+
+func (f *flakyRepository) SetStatus(ctx context.Context, number int, status ParcelStatus, version int) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.MemoryStore.SetStatus(ctx, number, status, version)
+}
+
+// sqliteError mimics the error type of the sqlite driver.
+type sqliteError int
+
+func (e sqliteError) Error() string { return fmt.Sprintf("sqlite error %d", int(e)) }
+
+func (e sqliteError) Code() int { return int(e) }
+
+// pgError mimics the error types of the Postgres drivers.
+type pgError string
+
+func (e pgError) Error() string { return "pg error " + string(e) }
+
+func (e pgError) SQLState() string { return string(e) }
+
+func TestRetryingStore(t *testing.T) {
+	t.Parallel()
+
+	isTransient := func(err error) bool {
+		return errors.Is(err, errTransient)
+	}
+
+	tests := []struct {
+		name       string
+		failures   []error
+		maxRetries int
+		wantCalls  int
+		wantDelays []time.Duration
+		wantErr    require.ErrorAssertionFunc
+	}{
+		{
+			name:       "fails twice then succeeds",
+			failures:   []error{errTransient, errTransient},
+			maxRetries: 3,
+			wantCalls:  3,
+			wantDelays: []time.Duration{time.Millisecond, 2 * time.Millisecond},
+			wantErr:    require.NoError,
+		},
+		{
+			name:       "retries exhausted",
+			failures:   []error{errTransient, errTransient},
+			maxRetries: 1,
+			wantCalls:  2,
+			wantDelays: []time.Duration{time.Millisecond},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, errTransient, i...)
+			},
+		},
+		{
+			name:       "permanent error",
+			failures:   []error{errors.New("constraint failed")},
+			maxRetries: 3,
+			wantCalls:  1,
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "constraint failed", i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &flakyRepository{MemoryStore: NewMemoryStore(), failures: tt.failures}
+			store := NewRetryingStore(repo,
+				WithMaxRetries(tt.maxRetries),
+				WithRetryBackoff(time.Millisecond),
+				WithRetryClassifier(isTransient))
+
+			var delays []time.Duration
+			store.sleep = func(_ context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+			err := store.Add(context.Background(), &parcel)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.wantCalls, repo.calls)
+			require.Equal(t, tt.wantDelays, delays)
+
+			parcels, err := store.GetByClient(context.Background(), 1000)
+			require.NoError(t, err)
+			if tt.wantCalls > len(tt.failures) {
+				require.Len(t, parcels, 1)
+			} else {
+				require.Empty(t, parcels)
+			}
+		})
+	}
+}
+
+func TestRetryingStoreCanceled(t *testing.T) {
+	t.Parallel()
+
+	repo := &flakyRepository{MemoryStore: NewMemoryStore(), failures: []error{errTransient, errTransient}}
+	store := NewRetryingStore(repo, WithRetryBackoff(time.Hour), WithRetryClassifier(func(err error) bool {
+		return errors.Is(err, errTransient)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := store.SetStatus(ctx, 1, ParcelStatusSent, 1)
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, repo.calls)
+}
+
+func TestRetryingStoreConnectionFailure(t *testing.T) {
+	t.Parallel()
+
+	// The connection may have been lost after the insert was committed,
+	// so the default classifier must not let Add store the parcel twice.
+	repo := &flakyRepository{MemoryStore: NewMemoryStore(), failures: []error{pgError("08006")}}
+	store := NewRetryingStore(repo, WithRetryBackoff(time.Millisecond))
+
+	parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test", CreatedAt: time.Now().UTC()}
+	err := store.Add(context.Background(), &parcel)
+	require.ErrorIs(t, err, pgError("08006"))
+	require.Equal(t, 1, repo.calls)
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "sqlite busy", err: sqliteError(5), want: true},
+		{name: "sqlite busy snapshot", err: sqliteError(517), want: true},
+		{name: "sqlite locked", err: fmt.Errorf("parcelstore.Add: %w", sqliteError(6)), want: true},
+		{name: "sqlite constraint", err: sqliteError(19), want: false},
+		{name: "postgres serialization failure", err: pgError("40001"), want: true},
+		{name: "postgres deadlock", err: pgError("40P01"), want: true},
+		{name: "postgres lock not available", err: pgError("55P03"), want: true},
+		{name: "postgres connection failure", err: pgError("08006"), want: false},
+		{name: "postgres unique violation", err: pgError("23505"), want: false},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// parcelTableDDL creates the parcel table with columns matching the
+// Parcel struct. registered_by is empty when the operator is unknown;
+// external_ref is NULL when the parcel has no external reference.
+// version starts at 1 and is incremented by every update. tracking_code
+// is set right after the insert, in the same transaction. sent_at and
+// delivered_at are set when the parcel moves to that status. priority
+// ranks the parcel for handling.
+const parcelTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number        INTEGER PRIMARY KEY AUTOINCREMENT,
+	client        INTEGER      NOT NULL,
+	status        VARCHAR(128) NOT NULL,
+	address       VARCHAR(512) NOT NULL,
+	created_at    DATETIME     NOT NULL,
+	updated_at    DATETIME     NOT NULL,
+	deleted_at    DATETIME,
+	registered_by VARCHAR(128) NOT NULL DEFAULT '',
+	external_ref  VARCHAR(128),
+	version       INTEGER      NOT NULL DEFAULT 1,
+	weight_grams  INTEGER      NOT NULL DEFAULT 0,
+	length_mm     INTEGER      NOT NULL DEFAULT 0,
+	width_mm      INTEGER      NOT NULL DEFAULT 0,
+	height_mm     INTEGER      NOT NULL DEFAULT 0,
+	tracking_code VARCHAR(32),
+	sent_at       DATETIME,
+	delivered_at  DATETIME,
+	priority      INTEGER      NOT NULL DEFAULT 0,
+	metadata      TEXT
+)`
+
+// clientTableDDL creates the table naming the clients that parcels
+// refer to. Parcels may refer to clients without a row here.
+const clientTableDDL = `CREATE TABLE IF NOT EXISTS client (
+	id   INTEGER      PRIMARY KEY,
+	name VARCHAR(256) NOT NULL
+)`
+
+// parcelReservationTableDDL creates the table holding parcel numbers
+// that were reserved for offline registration but not used yet.
+const parcelReservationTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number      INTEGER PRIMARY KEY,
+	reserved_at DATETIME NOT NULL
+)`
+
+// parcelStatusHistoryTableDDL creates the table recording every status
+// change of a parcel. id keeps changes made at the same moment ordered.
+const parcelStatusHistoryTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	number     INTEGER      NOT NULL,
+	old_status VARCHAR(128) NOT NULL,
+	new_status VARCHAR(128) NOT NULL,
+	changed_at DATETIME     NOT NULL
+)`
+
+// parcelAddressHistoryTableDDL creates the table recording every
+// address change made with a reason, for audits.
+const parcelAddressHistoryTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	number      INTEGER      NOT NULL,
+	old_address VARCHAR(512) NOT NULL,
+	new_address VARCHAR(512) NOT NULL,
+	reason      VARCHAR(512) NOT NULL,
+	changed_at  DATETIME     NOT NULL
+)`
+
+// parcelArchiveTableDDL creates the table ParcelStore.ArchiveDelivered
+// moves delivered parcels to. It has the columns of the parcel table,
+// without its generated numbers and unique indexes, plus the time the
+// parcel was archived.
+const parcelArchiveTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number        INTEGER      PRIMARY KEY,
+	client        INTEGER      NOT NULL,
+	status        VARCHAR(128) NOT NULL,
+	address       VARCHAR(512) NOT NULL,
+	created_at    DATETIME     NOT NULL,
+	updated_at    DATETIME     NOT NULL,
+	deleted_at    DATETIME,
+	registered_by VARCHAR(128) NOT NULL DEFAULT '',
+	external_ref  VARCHAR(128),
+	version       INTEGER      NOT NULL DEFAULT 1,
+	weight_grams  INTEGER      NOT NULL DEFAULT 0,
+	length_mm     INTEGER      NOT NULL DEFAULT 0,
+	width_mm      INTEGER      NOT NULL DEFAULT 0,
+	height_mm     INTEGER      NOT NULL DEFAULT 0,
+	tracking_code VARCHAR(32),
+	sent_at       DATETIME,
+	delivered_at  DATETIME,
+	priority      INTEGER      NOT NULL DEFAULT 0,
+	metadata      TEXT,
+	archived_at   DATETIME     NOT NULL
+)`
+
+// mysqlParcelTableDDL is parcelTableDDL for MySQL. MySQL has no
+// CREATE INDEX IF NOT EXISTS, so the unique indexes are declared with
+// the table. DATETIME(6) keeps the microseconds SQLite stores.
+const mysqlParcelTableDDL = `CREATE TABLE IF NOT EXISTS %[1]s (
+	number        BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	client        BIGINT       NOT NULL,
+	status        VARCHAR(128) NOT NULL,
+	address       VARCHAR(512) NOT NULL,
+	created_at    DATETIME(6)  NOT NULL,
+	updated_at    DATETIME(6)  NOT NULL,
+	deleted_at    DATETIME(6),
+	registered_by VARCHAR(128) NOT NULL DEFAULT '',
+	external_ref  VARCHAR(128),
+	version       INTEGER      NOT NULL DEFAULT 1,
+	weight_grams  INTEGER      NOT NULL DEFAULT 0,
+	length_mm     INTEGER      NOT NULL DEFAULT 0,
+	width_mm      INTEGER      NOT NULL DEFAULT 0,
+	height_mm     INTEGER      NOT NULL DEFAULT 0,
+	tracking_code VARCHAR(32),
+	sent_at       DATETIME(6),
+	delivered_at  DATETIME(6),
+	priority      INTEGER      NOT NULL DEFAULT 0,
+	metadata      TEXT,
+	UNIQUE KEY %[2]s (external_ref),
+	UNIQUE KEY %[3]s (tracking_code)
+)`
+
+// mysqlClientTableDDL is clientTableDDL for MySQL.
+const mysqlClientTableDDL = `CREATE TABLE IF NOT EXISTS client (
+	id   BIGINT       NOT NULL PRIMARY KEY,
+	name VARCHAR(256) NOT NULL
+)`
+
+// mysqlParcelReservationTableDDL is parcelReservationTableDDL for MySQL.
+const mysqlParcelReservationTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number      BIGINT      NOT NULL PRIMARY KEY,
+	reserved_at DATETIME(6) NOT NULL
+)`
+
+// mysqlParcelStatusHistoryTableDDL is parcelStatusHistoryTableDDL for
+// MySQL.
+const mysqlParcelStatusHistoryTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	id         BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	number     BIGINT       NOT NULL,
+	old_status VARCHAR(128) NOT NULL,
+	new_status VARCHAR(128) NOT NULL,
+	changed_at DATETIME(6)  NOT NULL
+)`
+
+// mysqlParcelAddressHistoryTableDDL is parcelAddressHistoryTableDDL for
+// MySQL.
+const mysqlParcelAddressHistoryTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	id          BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	number      BIGINT       NOT NULL,
+	old_address VARCHAR(512) NOT NULL,
+	new_address VARCHAR(512) NOT NULL,
+	reason      VARCHAR(512) NOT NULL,
+	changed_at  DATETIME(6)  NOT NULL
+)`
+
+// mysqlParcelArchiveTableDDL is parcelArchiveTableDDL for MySQL.
+const mysqlParcelArchiveTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number        BIGINT       NOT NULL PRIMARY KEY,
+	client        BIGINT       NOT NULL,
+	status        VARCHAR(128) NOT NULL,
+	address       VARCHAR(512) NOT NULL,
+	created_at    DATETIME(6)  NOT NULL,
+	updated_at    DATETIME(6)  NOT NULL,
+	deleted_at    DATETIME(6),
+	registered_by VARCHAR(128) NOT NULL DEFAULT '',
+	external_ref  VARCHAR(128),
+	version       INTEGER      NOT NULL DEFAULT 1,
+	weight_grams  INTEGER      NOT NULL DEFAULT 0,
+	length_mm     INTEGER      NOT NULL DEFAULT 0,
+	width_mm      INTEGER      NOT NULL DEFAULT 0,
+	height_mm     INTEGER      NOT NULL DEFAULT 0,
+	tracking_code VARCHAR(32),
+	sent_at       DATETIME(6),
+	delivered_at  DATETIME(6),
+	priority      INTEGER      NOT NULL DEFAULT 0,
+	metadata      TEXT,
+	archived_at   DATETIME(6)  NOT NULL
+)`
+
+// postgresParcelTableDDL is parcelTableDDL for Postgres, which has
+// neither AUTOINCREMENT nor DATETIME: numbers come from a BIGSERIAL and
+// timestamps are TIMESTAMPTZ. The unique indexes are created with
+// parcelIndexDDL.
+const postgresParcelTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number        BIGSERIAL    PRIMARY KEY,
+	client        BIGINT       NOT NULL,
+	status        VARCHAR(128) NOT NULL,
+	address       VARCHAR(512) NOT NULL,
+	created_at    TIMESTAMPTZ  NOT NULL,
+	updated_at    TIMESTAMPTZ  NOT NULL,
+	deleted_at    TIMESTAMPTZ,
+	registered_by VARCHAR(128) NOT NULL DEFAULT '',
+	external_ref  VARCHAR(128),
+	version       INTEGER      NOT NULL DEFAULT 1,
+	weight_grams  INTEGER      NOT NULL DEFAULT 0,
+	length_mm     INTEGER      NOT NULL DEFAULT 0,
+	width_mm      INTEGER      NOT NULL DEFAULT 0,
+	height_mm     INTEGER      NOT NULL DEFAULT 0,
+	tracking_code VARCHAR(32),
+	sent_at       TIMESTAMPTZ,
+	delivered_at  TIMESTAMPTZ,
+	priority      INTEGER      NOT NULL DEFAULT 0,
+	metadata      TEXT
+)`
+
+// postgresClientTableDDL is clientTableDDL for Postgres.
+const postgresClientTableDDL = `CREATE TABLE IF NOT EXISTS client (
+	id   BIGINT       PRIMARY KEY,
+	name VARCHAR(256) NOT NULL
+)`
+
+// postgresParcelReservationTableDDL is parcelReservationTableDDL for
+// Postgres.
+const postgresParcelReservationTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number      BIGINT      PRIMARY KEY,
+	reserved_at TIMESTAMPTZ NOT NULL
+)`
+
+// postgresParcelStatusHistoryTableDDL is parcelStatusHistoryTableDDL for
+// Postgres.
+const postgresParcelStatusHistoryTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	id         BIGSERIAL    PRIMARY KEY,
+	number     BIGINT       NOT NULL,
+	old_status VARCHAR(128) NOT NULL,
+	new_status VARCHAR(128) NOT NULL,
+	changed_at TIMESTAMPTZ  NOT NULL
+)`
+
+// postgresParcelAddressHistoryTableDDL is parcelAddressHistoryTableDDL
+// for Postgres.
+const postgresParcelAddressHistoryTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	id          BIGSERIAL    PRIMARY KEY,
+	number      BIGINT       NOT NULL,
+	old_address VARCHAR(512) NOT NULL,
+	new_address VARCHAR(512) NOT NULL,
+	reason      VARCHAR(512) NOT NULL,
+	changed_at  TIMESTAMPTZ  NOT NULL
+)`
+
+// postgresParcelArchiveTableDDL is parcelArchiveTableDDL for Postgres.
+const postgresParcelArchiveTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+	number        BIGINT       PRIMARY KEY,
+	client        BIGINT       NOT NULL,
+	status        VARCHAR(128) NOT NULL,
+	address       VARCHAR(512) NOT NULL,
+	created_at    TIMESTAMPTZ  NOT NULL,
+	updated_at    TIMESTAMPTZ  NOT NULL,
+	deleted_at    TIMESTAMPTZ,
+	registered_by VARCHAR(128) NOT NULL DEFAULT '',
+	external_ref  VARCHAR(128),
+	version       INTEGER      NOT NULL DEFAULT 1,
+	weight_grams  INTEGER      NOT NULL DEFAULT 0,
+	length_mm     INTEGER      NOT NULL DEFAULT 0,
+	width_mm      INTEGER      NOT NULL DEFAULT 0,
+	height_mm     INTEGER      NOT NULL DEFAULT 0,
+	tracking_code VARCHAR(32),
+	sent_at       TIMESTAMPTZ,
+	delivered_at  TIMESTAMPTZ,
+	priority      INTEGER      NOT NULL DEFAULT 0,
+	metadata      TEXT,
+	archived_at   TIMESTAMPTZ  NOT NULL
+)`
+
+// parcelIndexDDL returns the statement creating the unique index on
+// column of the parcel table. The index on external_ref keeps a
+// registration from being stored twice; the one on tracking_code backs
+// lookups by code. SQLite wants the schema of a qualified table on the
+// index name rather than on the table.
+func parcelIndexDDL(dialect Dialect, table TableName, column string) string {
+	index, on := table.index(column), table.String()
+	if schema, name, ok := strings.Cut(on, "."); ok && dialect == DialectSQLite {
+		index, on = schema+"."+index, name
+	}
+
+	return "CREATE UNIQUE INDEX IF NOT EXISTS " + index + " ON " + on + " (" + column + ")"
+}
+
+// schemaStatements returns the DDL applied by CreateSchema for the
+// dialect, in order. The tables and indexes are named after table.
+func schemaStatements(dialect Dialect, table TableName) []string {
+	switch dialect {
+	case DialectMySQL:
+		return []string{
+			fmt.Sprintf(mysqlParcelTableDDL, table.String(), table.index("external_ref"), table.index("tracking_code")),
+			mysqlClientTableDDL,
+			fmt.Sprintf(mysqlParcelReservationTableDDL, table.reservation()),
+			fmt.Sprintf(mysqlParcelStatusHistoryTableDDL, table.statusHistory()),
+			fmt.Sprintf(mysqlParcelAddressHistoryTableDDL, table.addressHistory()),
+			fmt.Sprintf(mysqlParcelArchiveTableDDL, table.archive()),
+		}
+	case DialectPostgres:
+		return []string{
+			fmt.Sprintf(postgresParcelTableDDL, table.String()),
+			parcelIndexDDL(dialect, table, "external_ref"),
+			parcelIndexDDL(dialect, table, "tracking_code"),
+			postgresClientTableDDL,
+			fmt.Sprintf(postgresParcelReservationTableDDL, table.reservation()),
+			fmt.Sprintf(postgresParcelStatusHistoryTableDDL, table.statusHistory()),
+			fmt.Sprintf(postgresParcelAddressHistoryTableDDL, table.addressHistory()),
+			fmt.Sprintf(postgresParcelArchiveTableDDL, table.archive()),
+		}
+	}
+
+	return []string{
+		fmt.Sprintf(parcelTableDDL, table.String()),
+		parcelIndexDDL(dialect, table, "external_ref"),
+		parcelIndexDDL(dialect, table, "tracking_code"),
+		clientTableDDL,
+		fmt.Sprintf(parcelReservationTableDDL, table.reservation()),
+		fmt.Sprintf(parcelStatusHistoryTableDDL, table.statusHistory()),
+		fmt.Sprintf(parcelAddressHistoryTableDDL, table.addressHistory()),
+		fmt.Sprintf(parcelArchiveTableDDL, table.archive()),
+	}
+}
+
+// CreateSchema creates the tables used by ParcelStore.
+//
+// The parcel table and its side tables are named after table, as
+// WithTableName expects them; the zero TableName creates the default
+// "parcel" tables. Every statement is guarded with IF NOT EXISTS, so
+// calling it against an already initialised database is a no-op. A
+// parcel table created by an earlier version is migrated to the
+// current columns first, see migrateParcelTable.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the statements.
+// - db: the database in which the schema is created.
+// - dialect: the SQL flavour of db, which selects the DDL.
+// - table: the name of the parcel table.
+//
+// Returns:
+// - An error, if any occurs while executing the statements or
+// migrating the parcel table.
+func CreateSchema(ctx context.Context, db *sql.DB, dialect Dialect, table TableName) error {
+	statements := schemaStatements(dialect, table)
+	if _, err := db.ExecContext(ctx, statements[0]); err != nil {
+		return err
+	}
+
+	// The indexes below cover columns an old parcel table may lack.
+	if err := migrateParcelTable(ctx, db, dialect, table); err != nil {
+		return fmt.Errorf("migrate %s: %w", table, err)
+	}
+
+	for _, statement := range statements[1:] {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestDB opens an in-memory SQLite database with the schema applied.
+func openTestDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	// Every connection to ":memory:" gets its own database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	require.NoError(t, CreateSchema(context.Background(), db, DialectSQLite, TableName{}))
+
+	return db
+}
+
+func TestCreateSchema(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// The schema is already applied; a second run must not fail.
+	require.NoError(t, CreateSchema(ctx, db, DialectSQLite, TableName{}))
+
+	store := NewParcelStore(db)
+	parcel := Parcel{
+		Client:    1,
+		Status:    ParcelStatusRegistered,
+		Address:   "test address",
+		CreatedAt: time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, store.Add(ctx, &parcel))
+
+	got, err := store.Get(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	require.Equal(t, parcel, got)
+}
+
+func TestSchemaStatements(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		dialect     Dialect
+		prefixes    []string
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:    "sqlite",
+			dialect: DialectSQLite,
+			prefixes: []string{
+				"CREATE TABLE IF NOT EXISTS parcel (",
+				"CREATE UNIQUE INDEX IF NOT EXISTS parcel_external_ref_idx ON parcel (external_ref)",
+				"CREATE UNIQUE INDEX IF NOT EXISTS parcel_tracking_code_idx ON parcel (tracking_code)",
+				"CREATE TABLE IF NOT EXISTS client (",
+				"CREATE TABLE IF NOT EXISTS parcel_reservation (",
+				"CREATE TABLE IF NOT EXISTS parcel_status_history (",
+				"CREATE TABLE IF NOT EXISTS parcel_address_history (",
+				"CREATE TABLE IF NOT EXISTS parcel_archive (",
+			},
+			contains:    []string{"INTEGER PRIMARY KEY AUTOINCREMENT", "CREATE UNIQUE INDEX IF NOT EXISTS"},
+			notContains: []string{"AUTO_INCREMENT"},
+		},
+		{
+			name:    "mysql",
+			dialect: DialectMySQL,
+			prefixes: []string{
+				"CREATE TABLE IF NOT EXISTS parcel (",
+				"CREATE TABLE IF NOT EXISTS client (",
+				"CREATE TABLE IF NOT EXISTS parcel_reservation (",
+				"CREATE TABLE IF NOT EXISTS parcel_status_history (",
+				"CREATE TABLE IF NOT EXISTS parcel_address_history (",
+				"CREATE TABLE IF NOT EXISTS parcel_archive (",
+			},
+			contains: []string{
+				"number        BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY",
+				"id         BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY",
+				"UNIQUE KEY parcel_external_ref_idx (external_ref)",
+				"UNIQUE KEY parcel_tracking_code_idx (tracking_code)",
+				"DATETIME(6)",
+			},
+			notContains: []string{"AUTOINCREMENT", "CREATE UNIQUE INDEX"},
+		},
+		{
+			name:    "postgres",
+			dialect: DialectPostgres,
+			prefixes: []string{
+				"CREATE TABLE IF NOT EXISTS parcel (",
+				"CREATE UNIQUE INDEX IF NOT EXISTS parcel_external_ref_idx ON parcel (external_ref)",
+				"CREATE UNIQUE INDEX IF NOT EXISTS parcel_tracking_code_idx ON parcel (tracking_code)",
+				"CREATE TABLE IF NOT EXISTS client (",
+				"CREATE TABLE IF NOT EXISTS parcel_reservation (",
+				"CREATE TABLE IF NOT EXISTS parcel_status_history (",
+				"CREATE TABLE IF NOT EXISTS parcel_address_history (",
+				"CREATE TABLE IF NOT EXISTS parcel_archive (",
+			},
+			contains: []string{
+				"number        BIGSERIAL    PRIMARY KEY",
+				"id         BIGSERIAL    PRIMARY KEY",
+				"id          BIGSERIAL    PRIMARY KEY",
+				"TIMESTAMPTZ",
+				"CREATE UNIQUE INDEX IF NOT EXISTS",
+			},
+			notContains: []string{"AUTOINCREMENT", "AUTO_INCREMENT", "DATETIME"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			statements := schemaStatements(tt.dialect, TableName{})
+			require.Len(t, statements, len(tt.prefixes))
+			for i, prefix := range tt.prefixes {
+				require.True(t, strings.HasPrefix(statements[i], prefix), statements[i])
+			}
+
+			ddl := strings.Join(statements, ";\n")
+			for _, want := range tt.contains {
+				require.Contains(t, ddl, want)
+			}
+			for _, unwanted := range tt.notContains {
+				require.NotContains(t, ddl, unwanted)
+			}
+
+			// Every column read by scanParcel must be created.
+			for _, column := range strings.Split(parcelColumns, ", ") {
+				require.Regexp(t, `\n\t`+column+` +[A-Z]`, statements[0])
+			}
+		})
+	}
+}
+
+func TestCreateSchemaDialects(t *testing.T) {
+	t.Parallel()
+
+	for _, dialect := range []Dialect{DialectMySQL, DialectPostgres} {
+		t.Run(dialect.String(), func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			statements := schemaStatements(dialect, TableName{})
+			dbMock.ExpectExec(regexp.QuoteMeta(statements[0])).WillReturnResult(sqlmock.NewResult(0, 0))
+			// The parcel table is up to date, so it is not migrated.
+			dbMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM parcel WHERE 1 = 0")).
+				WillReturnRows(sqlmock.NewRows(strings.Split(parcelColumns, ", ")))
+			for _, statement := range statements[1:] {
+				dbMock.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			require.NoError(t, CreateSchema(context.Background(), db, dialect, TableName{}))
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
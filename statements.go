@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// selectParcelQuery returns the query of ParcelStore.Get for the given
+// parcel table.
+func selectParcelQuery(table TableName) string {
+	return "SELECT " + parcelColumns + " FROM " + table.String() + " WHERE number = ? AND deleted_at IS NULL"
+}
+
+// insertParcelQuery returns the query of ParcelStore.Add for the given
+// parcel table.
+func insertParcelQuery(table TableName) string {
+	return "INSERT INTO " + table.String() + " (client, status, address, created_at, updated_at, registered_by, external_ref, " +
+		"weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+}
+
+// statementCache holds the prepared statements of the most frequent
+// store queries. It is shared between copies of the store, so a
+// transaction-bound store reuses the statements of its parent.
+type statementCache struct {
+	// mu guards the fields below and serialises the preparation.
+	mu sync.RWMutex
+	// done reports that every query was prepared or failed to, or that
+	// the cache was closed, so there is nothing left to prepare.
+	done bool
+	// stmts maps the rebound query text to its prepared statement.
+	// Queries that failed to prepare are missing and run ad hoc.
+	stmts map[string]*sql.Stmt
+	// failed holds the queries the driver refused to prepare. They are
+	// not retried.
+	failed map[string]bool
+}
+
+// WithPreparedStatements enables or disables preparing the queries of
+// Get and Add once and reusing them. Stores prepare them by default;
+// disable it for connection poolers that do not support prepared
+// statements.
+func WithPreparedStatements(enabled bool) StoreOption {
+	return func(s *ParcelStore) {
+		if enabled {
+			s.stmts = &statementCache{}
+		} else {
+			s.stmts = nil
+		}
+	}
+}
+
+// cachedQueries returns the queries prepared by the statement cache, as
+// sent to the driver.
+func (s ParcelStore) cachedQueries() []string {
+	insert := insertParcelQuery(s.table)
+	if s.dialect.usesReturning() {
+		insert += " RETURNING number"
+	}
+
+	return []string{s.dialect.Rebind(selectParcelQuery(s.table)), s.dialect.Rebind(insert)}
+}
+
+// prepareStatements prepares the cached queries the first time it is
+// called outside a transaction. A query the driver fails to prepare is
+// not retried; it keeps running as an ad-hoc query. A preparation cut
+// short by ctx is retried by the next call instead, as the failure says
+// nothing about the query.
+//
+// Preparing inside a transaction is skipped, as it would need a second
+// connection while the transaction holds one.
+func (s ParcelStore) prepareStatements(ctx context.Context) {
+	if s.stmts == nil || s.tx != nil {
+		return
+	}
+
+	s.stmts.mu.RLock()
+	done := s.stmts.done
+	s.stmts.mu.RUnlock()
+
+	if done {
+		return
+	}
+
+	s.stmts.mu.Lock()
+	defer s.stmts.mu.Unlock()
+
+	if s.stmts.done {
+		return
+	}
+
+	if s.stmts.stmts == nil {
+		s.stmts.stmts = make(map[string]*sql.Stmt)
+		s.stmts.failed = make(map[string]bool)
+	}
+
+	for _, query := range s.cachedQueries() {
+		if s.stmts.stmts[query] != nil || s.stmts.failed[query] {
+			continue
+		}
+
+		stmt, err := s.pool().PrepareContext(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			s.stmts.failed[query] = true
+			continue
+		}
+
+		s.stmts.stmts[query] = stmt
+	}
+
+	s.stmts.done = true
+}
+
+// statement returns the prepared statement for query, bound to the
+// store's transaction if any, or nil if the query has none.
+func (s ParcelStore) statement(ctx context.Context, query string) *sql.Stmt {
+	if s.stmts == nil {
+		return nil
+	}
+
+	s.stmts.mu.RLock()
+	stmt := s.stmts.stmts[query]
+	s.stmts.mu.RUnlock()
+
+	if stmt == nil {
+		return nil
+	}
+
+	if s.tx != nil {
+		return s.tx.StmtContext(ctx, stmt)
+	}
+
+	return stmt
+}
+
+// queryRow runs a query expected to return at most one row, with its
+// prepared statement if there is one.
+func (s ParcelStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	if stmt := s.statement(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+
+	return s.executor().QueryRowContext(ctx, query, args...)
+}
+
+// exec runs a query without returning rows, with its prepared statement
+// if there is one.
+func (s ParcelStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if stmt := s.statement(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+
+	return s.executor().ExecContext(ctx, query, args...)
+}
+
+// Close releases the prepared statements of the store. Later calls run
+// their queries ad hoc. Close does not close the database connection.
+//
+// Returns:
+// - The errors of closing the statements, joined.
+func (s ParcelStore) Close() error {
+	if s.stmts == nil {
+		return nil
+	}
+
+	s.stmts.mu.Lock()
+	stmts := s.stmts.stmts
+	s.stmts.stmts = nil
+	// Make sure no statement is prepared after Close.
+	s.stmts.done = true
+	s.stmts.mu.Unlock()
+
+	var errs []error
+	for _, stmt := range stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreparedGet(t *testing.T) {
+	t.Parallel()
+
+	getQuery := regexp.QuoteMeta(selectParcelQuery(TableName{}))
+	insertQuery := regexp.QuoteMeta(insertParcelQuery(TableName{}))
+	parcel := Parcel{Number: 1, Client: 1000, Status: ParcelStatusRegistered, Address: "test address"}
+
+	tests := []struct {
+		name  string
+		mocks func(dbMock sqlmock.Sqlmock)
+	}{
+		{
+			name: "prepared statement",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				get := dbMock.ExpectPrepare(getQuery).WillBeClosed()
+				dbMock.ExpectPrepare(insertQuery).WillBeClosed()
+				get.ExpectQuery().WithArgs(1).WillReturnRows(parcelRows(parcel))
+				get.ExpectQuery().WithArgs(1).WillReturnRows(parcelRows(parcel))
+			},
+		},
+		{
+			name: "preparation fails",
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectPrepare(getQuery).WillReturnError(errors.New("prepare error"))
+				dbMock.ExpectPrepare(insertQuery).WillReturnError(errors.New("prepare error"))
+				dbMock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(parcelRows(parcel))
+				dbMock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(parcelRows(parcel))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			store := NewParcelStore(db)
+			for range 2 {
+				got, err := store.Get(context.Background(), 1)
+				require.NoError(t, err)
+				require.Equal(t, parcel, got)
+			}
+			require.NoError(t, store.Close())
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPreparedGetRetriesAfterCancel(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	parcel := Parcel{Number: 1, Client: 1000, Status: ParcelStatusRegistered, Address: "test address"}
+	get := dbMock.ExpectPrepare(regexp.QuoteMeta(selectParcelQuery(TableName{}))).WillBeClosed()
+	dbMock.ExpectPrepare(regexp.QuoteMeta(insertParcelQuery(TableName{}))).WillBeClosed()
+	get.ExpectQuery().WithArgs(1).WillReturnRows(parcelRows(parcel))
+
+	store := NewParcelStore(db)
+
+	// The cancelled call cannot prepare the statements; that must not
+	// keep the next call from preparing them.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = store.Get(ctx, 1)
+	require.ErrorIs(t, err, context.Canceled)
+
+	got, err := store.Get(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, parcel, got)
+	require.NoError(t, store.Close())
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestPreparedStatementsSQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, enabled := range []bool{true, false} {
+		store := NewParcelStore(openTestDB(t), WithPreparedStatements(enabled))
+
+		// Add prepares the statements and runs the insert inside its
+		// transaction; AddMany only joins an existing one.
+		added := addParcel(t, store, 1000, createdAt)
+		batch := []*Parcel{{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt}}
+		require.NoError(t, store.AddMany(ctx, batch))
+
+		got, err := store.Get(ctx, int(added.Number))
+		require.NoError(t, err)
+		require.Equal(t, added.Number, got.Number)
+
+		require.NoError(t, store.Close())
+
+		// The store keeps working with ad-hoc queries after Close.
+		got, err = store.Get(ctx, int(batch[0].Number))
+		require.NoError(t, err)
+		require.Equal(t, batch[0].Number, got.Number)
+		addParcel(t, store, 1000, createdAt)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, bb := range []struct {
+		name     string
+		prepared bool
+	}{
+		{name: "prepared", prepared: true},
+		{name: "ad hoc", prepared: false},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			ctx := context.Background()
+			store := NewParcelStore(openTestDB(b), WithPreparedStatements(bb.prepared))
+			b.Cleanup(func() {
+				_ = store.Close()
+			})
+
+			parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: time.Now().UTC()}
+			require.NoError(b, store.Add(ctx, &parcel))
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := store.Get(ctx, int(parcel.Number)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "sync"
+
+// OpStat counts the calls and failures of a single store operation.
+type OpStat struct {
+	// Calls is the number of times the operation was invoked.
+	Calls int64 `json:"calls"`
+	// Errors is the number of invocations that returned an error.
+	Errors int64 `json:"errors"`
+}
+
+// operationStats collects OpStat values keyed by operation name.
+//
+// It is shared by all copies of a ParcelStore, so it is guarded by a
+// mutex. A nil *operationStats ignores records.
+type operationStats struct {
+	mu    sync.Mutex
+	stats map[string]OpStat
+}
+
+// newOperationStats returns an empty collector.
+func newOperationStats() *operationStats {
+	return &operationStats{stats: make(map[string]OpStat)}
+}
+
+// record counts one call of op, and one error if err is not nil.
+func (o *operationStats) record(op string, err error) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stat := o.stats[op]
+	stat.Calls++
+	if err != nil {
+		stat.Errors++
+	}
+	o.stats[op] = stat
+}
+
+// snapshot returns a copy of the collected stats.
+func (o *operationStats) snapshot() map[string]OpStat {
+	if o == nil {
+		return map[string]OpStat{}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	snapshot := make(map[string]OpStat, len(o.stats))
+	for op, stat := range o.stats {
+		snapshot[op] = stat
+	}
+
+	return snapshot
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationStats(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	store := NewParcelStore(db)
+
+	for i := 0; i < 2; i++ {
+		dbMock.ExpectBegin()
+		dbMock.ExpectExec("INSERT INTO parcel").WillReturnError(errors.New("database error"))
+		dbMock.ExpectRollback()
+	}
+	for i := 0; i < 3; i++ {
+		dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM parcel").
+			WillReturnRows(parcelRows(Parcel{Number: 101, Client: 1, Status: ParcelStatusRegistered, Address: "address", CreatedAt: time.Now()}))
+	}
+
+	for i := 0; i < 2; i++ {
+		err := store.Add(ctx, &Parcel{Client: 1, Status: ParcelStatusRegistered, Address: "address"})
+		require.Error(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := store.Get(ctx, 101)
+		require.NoError(t, err)
+	}
+
+	stats := store.OperationStats()
+	require.Equal(t, OpStat{Calls: 2, Errors: 2}, stats["Add"])
+	require.Equal(t, OpStat{Calls: 3, Errors: 0}, stats["Get"])
+	require.NotContains(t, stats, "Delete")
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestOperationStatsWithoutCollector(t *testing.T) {
+	t.Parallel()
+
+	var stats *operationStats
+	stats.record("Get", nil)
+
+	require.Empty(t, stats.snapshot())
+}
+
+func TestOperationStatsNestedCalls(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	valid := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address", CreatedAt: createdAt}
+	require.NoError(t, store.AddMany(ctx, []*Parcel{&valid}))
+
+	err := store.AddMany(ctx, []*Parcel{{Client: 1000, Status: "lost", Address: "test address", CreatedAt: createdAt}})
+	require.ErrorIs(t, err, ErrInvalidStatus)
+	require.EqualError(t, err, `parcelstore.AddMany: invalid parcel status: "lost"`)
+
+	_, err = store.StatusDurations(ctx, int(valid.Number))
+	require.NoError(t, err)
+
+	_, err = store.StatusDurations(ctx, int(valid.Number)+1)
+	require.EqualError(t, err, "parcelstore.StatusDurations: parcel not found")
+
+	stats := store.OperationStats()
+	require.Equal(t, OpStat{Calls: 2, Errors: 1}, stats["AddMany"])
+	require.Equal(t, OpStat{Calls: 2, Errors: 1}, stats["StatusDurations"])
+	require.NotContains(t, stats, "Add")
+	require.NotContains(t, stats, "Get")
+	require.NotContains(t, stats, "GetStatusHistory")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ParcelStatus is the lifecycle state of a parcel.
+type ParcelStatus string
+
+const (
+	// ParcelStatusRegistered indicates that the parcel has been registered.
+	ParcelStatusRegistered ParcelStatus = "registered"
+	// ParcelStatusSent indicates that the parcel has been sent.
+	ParcelStatusSent ParcelStatus = "sent"
+	// ParcelStatusDelivered indicates that the parcel has been delivered.
+	ParcelStatusDelivered ParcelStatus = "delivered"
+)
+
+// knownStatuses lists every valid ParcelStatus in lifecycle order.
+var knownStatuses = []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered}
+
+// ErrInvalidStatus is returned when a value is not one of the known
+// parcel statuses.
+var ErrInvalidStatus = errors.New("invalid parcel status")
+
+// ErrNoPreviousStatus is returned by ParcelService.RevertStatus for a
+// parcel that is still in the first status of its lifecycle.
+var ErrNoPreviousStatus = errors.New("parcel has no previous status")
+
+// IsValid reports whether s is one of the known parcel statuses.
+//
+// The comparison is exact: "Delivered" is not a valid status.
+// Use ParseStatus to accept user input in any case.
+func (s ParcelStatus) IsValid() bool {
+	switch s {
+	case ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseStatus converts user input into a ParcelStatus.
+//
+// Surrounding whitespace is ignored and the input is matched
+// case-insensitively, so "Delivered" parses as ParcelStatusDelivered.
+//
+// Parameters:
+// - value: the status as supplied by the caller.
+//
+// Returns:
+// - The matching ParcelStatus.
+// - An error wrapping ErrInvalidStatus if the value is not known.
+func ParseStatus(value string) (ParcelStatus, error) {
+	status := ParcelStatus(strings.ToLower(strings.TrimSpace(value)))
+	if !status.IsValid() {
+		return "", fmt.Errorf("%w: %q", ErrInvalidStatus, value)
+	}
+
+	return status, nil
+}
+
+// statusTransitions is the parcel state machine: each status maps to the
+// single status it may advance to. Statuses without an entry are terminal.
+var statusTransitions = map[ParcelStatus]ParcelStatus{
+	ParcelStatusRegistered: ParcelStatusSent,
+	ParcelStatusSent:       ParcelStatusDelivered,
+}
+
+// timestampColumns maps the statuses whose time is recorded to the
+// parcel column holding it.
+var timestampColumns = map[ParcelStatus]string{
+	ParcelStatusSent:      "sent_at",
+	ParcelStatusDelivered: "delivered_at",
+}
+
+// timestampColumn returns the parcel column stamped when a parcel moves
+// to s, or "" if s is not recorded.
+func (s ParcelStatus) timestampColumn() string {
+	return timestampColumns[s]
+}
+
+// timestampChanges lists the timestamp columns affected by moving a
+// parcel from status from to status to. Moving forward stamps the
+// column of to; moving backward clears the columns of the statuses
+// after to, so a reverted delivery leaves no DeliveredAt behind.
+func timestampChanges(from, to ParcelStatus) (stamp string, clear []string) {
+	fromRank, toRank := slices.Index(knownStatuses, from), slices.Index(knownStatuses, to)
+
+	if toRank > fromRank {
+		return to.timestampColumn(), nil
+	}
+
+	if toRank < fromRank {
+		return "", laterTimestampColumns(to)
+	}
+
+	return "", nil
+}
+
+// laterTimestampColumns returns the timestamp columns of the statuses
+// that follow s in the lifecycle.
+func laterTimestampColumns(s ParcelStatus) []string {
+	var columns []string
+	for _, status := range knownStatuses[slices.Index(knownStatuses, s)+1:] {
+		if column := status.timestampColumn(); column != "" {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// Next returns the status that follows s in the parcel lifecycle.
+//
+// The boolean is false when s is terminal (delivered) or unknown.
+func (s ParcelStatus) Next() (ParcelStatus, bool) {
+	next, ok := statusTransitions[s]
+	return next, ok
+}
+
+// Previous returns the status that s follows in the parcel lifecycle.
+//
+// The boolean is false when s is the first status (registered) or
+// unknown.
+func (s ParcelStatus) Previous() (ParcelStatus, bool) {
+	for from, to := range statusTransitions {
+		if to == s {
+			return from, true
+		}
+	}
+
+	return "", false
+}
+
+// CanTransition reports whether a parcel in status from may be moved to
+// status to. Callers can use it to validate a requested status before
+// calling SetStatus.
+//
+// Parameters:
+// - from: the current status of the parcel.
+// - to: the requested status.
+//
+// Returns:
+// - true if to directly follows from in the lifecycle.
+func CanTransition(from, to ParcelStatus) bool {
+	next, ok := from.Next()
+	return ok && next == to
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParcelStatusIsValid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		status ParcelStatus
+		want   bool
+	}{
+		{status: ParcelStatusRegistered, want: true},
+		{status: ParcelStatusSent, want: true},
+		{status: ParcelStatusDelivered, want: true},
+		{status: "Delivered", want: false},
+		{status: "lost", want: false},
+		{status: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, tt.status.IsValid())
+		})
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		want    ParcelStatus
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "valid",
+			value:   "sent",
+			want:    ParcelStatusSent,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "mixed case",
+			value:   "Delivered",
+			want:    ParcelStatusDelivered,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "surrounding whitespace",
+			value:   "  REGISTERED ",
+			want:    ParcelStatusRegistered,
+			wantErr: require.NoError,
+		},
+		{
+			name:  "invalid",
+			value: "lost",
+			want:  "",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+		{
+			name:  "empty",
+			value: "",
+			want:  "",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			status, err := ParseStatus(tt.value)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, status)
+		})
+	}
+}
+
+func TestParcelStatusNext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		status   ParcelStatus
+		wantNext ParcelStatus
+		wantOK   bool
+	}{
+		{status: ParcelStatusRegistered, wantNext: ParcelStatusSent, wantOK: true},
+		{status: ParcelStatusSent, wantNext: ParcelStatusDelivered, wantOK: true},
+		{status: ParcelStatusDelivered, wantNext: "", wantOK: false},
+		{status: "lost", wantNext: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			t.Parallel()
+
+			next, ok := tt.status.Next()
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantNext, next)
+		})
+	}
+}
+
+func TestParcelStatusPrevious(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		status       ParcelStatus
+		wantPrevious ParcelStatus
+		wantOK       bool
+	}{
+		{status: ParcelStatusRegistered, wantPrevious: "", wantOK: false},
+		{status: ParcelStatusSent, wantPrevious: ParcelStatusRegistered, wantOK: true},
+		{status: ParcelStatusDelivered, wantPrevious: ParcelStatusSent, wantOK: true},
+		{status: "lost", wantPrevious: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			t.Parallel()
+
+			previous, ok := tt.status.Previous()
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.wantPrevious, previous)
+		})
+	}
+}
+
+func TestTimestampChanges(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		from      ParcelStatus
+		to        ParcelStatus
+		wantStamp string
+		wantClear []string
+	}{
+		{name: "registered to sent", from: ParcelStatusRegistered, to: ParcelStatusSent, wantStamp: "sent_at"},
+		{name: "sent to delivered", from: ParcelStatusSent, to: ParcelStatusDelivered, wantStamp: "delivered_at"},
+		{name: "registered to delivered", from: ParcelStatusRegistered, to: ParcelStatusDelivered, wantStamp: "delivered_at"},
+		{name: "delivered to sent", from: ParcelStatusDelivered, to: ParcelStatusSent, wantClear: []string{"delivered_at"}},
+		{name: "sent to registered", from: ParcelStatusSent, to: ParcelStatusRegistered, wantClear: []string{"sent_at", "delivered_at"}},
+		{name: "unchanged", from: ParcelStatusSent, to: ParcelStatusSent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			stamp, clear := timestampChanges(tt.from, tt.to)
+			require.Equal(t, tt.wantStamp, stamp)
+			require.Equal(t, tt.wantClear, clear)
+		})
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	t.Parallel()
+
+	statuses := []ParcelStatus{ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered}
+	allowed := map[[2]ParcelStatus]bool{
+		{ParcelStatusRegistered, ParcelStatusSent}: true,
+		{ParcelStatusSent, ParcelStatusDelivered}:  true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			t.Run(string(from)+" to "+string(to), func(t *testing.T) {
+				t.Parallel()
+
+				require.Equal(t, allowed[[2]ParcelStatus{from, to}], CanTransition(from, to))
+			})
+		}
+	}
+
+	require.False(t, CanTransition("lost", ParcelStatusSent))
+	require.False(t, CanTransition(ParcelStatusRegistered, "lost"))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ClientSummary holds the parcel statistics of a client, for
+// dashboards that would otherwise fetch and count every parcel.
+type ClientSummary struct {
+	// Client is the unique identifier of the client.
+	Client int64 `json:"client"`
+	// Total is the number of parcels of the client.
+	Total int `json:"total"`
+	// ByStatus is the number of parcels in each known status, including
+	// zero counts.
+	ByStatus map[ParcelStatus]int `json:"by_status"`
+	// Latest is the most recently created parcel, or nil if the client
+	// has none.
+	Latest *Parcel `json:"latest,omitempty"`
+}
+
+// Summary returns the parcel statistics of a client.
+//
+// A client without parcels gets a summary with zero counts and no
+// latest parcel rather than an error.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store calls.
+// - client: An integer representing the client's unique identifier.
+//
+// Returns:
+// - The summary of the client's parcels.
+// - ErrInvalidClient for a non-positive client, or any store error.
+func (s ParcelService) Summary(ctx context.Context, client int) (ClientSummary, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if client <= 0 {
+		return ClientSummary{}, fmt.Errorf("%w: %d", ErrInvalidClient, client)
+	}
+
+	counts, err := s.store.CountByStatus(ctx, client)
+	if err != nil {
+		return ClientSummary{}, err
+	}
+
+	summary := ClientSummary{Client: int64(client), ByStatus: counts}
+	for _, count := range counts {
+		summary.Total += count
+	}
+
+	latest, err := s.store.GetLatestByClient(ctx, client)
+	switch {
+	case errors.Is(err, ErrParcelNotFound):
+	case err != nil:
+		return ClientSummary{}, err
+	default:
+		summary.Latest = &latest
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			service := NewParcelService(repo)
+
+			t.Run("empty client", func(t *testing.T) {
+				summary, err := service.Summary(ctx, 2000)
+				require.NoError(t, err)
+				require.Equal(t, ClientSummary{
+					Client: 2000,
+					ByStatus: map[ParcelStatus]int{
+						ParcelStatusRegistered: 0,
+						ParcelStatusSent:       0,
+						ParcelStatusDelivered:  0,
+					},
+				}, summary)
+			})
+
+			addParcel(t, repo, 1000, createdAt)
+			sent := addParcel(t, repo, 1000, createdAt.Add(time.Hour))
+			latest := addParcel(t, repo, 1000, createdAt.Add(2*time.Hour))
+			addParcel(t, repo, 3000, createdAt.Add(3*time.Hour))
+
+			_, _, err := repo.AdvanceStatus(ctx, int(sent.Number))
+			require.NoError(t, err)
+
+			t.Run("populated client", func(t *testing.T) {
+				summary, err := service.Summary(ctx, 1000)
+				require.NoError(t, err)
+				require.Equal(t, int64(1000), summary.Client)
+				require.Equal(t, 3, summary.Total)
+				require.Equal(t, map[ParcelStatus]int{
+					ParcelStatusRegistered: 2,
+					ParcelStatusSent:       1,
+					ParcelStatusDelivered:  0,
+				}, summary.ByStatus)
+				require.NotNil(t, summary.Latest)
+				require.Equal(t, latest.Number, summary.Latest.Number)
+			})
+
+			t.Run("invalid client", func(t *testing.T) {
+				_, err := service.Summary(ctx, 0)
+				require.ErrorIs(t, err, ErrInvalidClient)
+			})
+		})
+	}
+}
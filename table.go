@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultTableName is the parcel table used by stores without
+// WithTableName, as created by CreateSchema.
+const defaultTableName = "parcel"
+
+// ErrInvalidTableName is returned by ParseTableName for a name that is
+// not a plain or schema-qualified SQL identifier.
+var ErrInvalidTableName = errors.New("invalid table name")
+
+// tableNamePattern matches a table name optionally qualified by its
+// schema, such as "parcel" or "shipping.parcel". Identifiers are
+// limited to letters, digits and underscores, so a name matching it
+// can be put into a query without quoting.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// TableName is the name of the parcel table, validated by
+// ParseTableName. The zero value stands for the default "parcel".
+type TableName struct {
+	name string
+}
+
+// ParseTableName validates the name of a parcel table, so it is safe to
+// put into queries.
+//
+// Parameters:
+//   - name: the table name, optionally qualified by its schema, such as
+//     "shipping.parcel".
+//
+// Returns:
+// - The validated table name.
+// - ErrInvalidTableName if name is not a plain or qualified identifier.
+func ParseTableName(name string) (TableName, error) {
+	if !tableNamePattern.MatchString(name) {
+		return TableName{}, fmt.Errorf("%w: %q", ErrInvalidTableName, name)
+	}
+
+	return TableName{name: name}, nil
+}
+
+// String returns the table name as it appears in queries.
+func (t TableName) String() string {
+	if t.name == "" {
+		return defaultTableName
+	}
+
+	return t.name
+}
+
+// base returns the table name without its schema.
+func (t TableName) base() string {
+	name := t.String()
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+
+	return name
+}
+
+// reservation returns the name of the table holding reserved parcel
+// numbers, named after the parcel table.
+func (t TableName) reservation() string {
+	return t.String() + "_reservation"
+}
+
+// statusHistory returns the name of the status history table, named
+// after the parcel table.
+func (t TableName) statusHistory() string {
+	return t.String() + "_status_history"
+}
+
+// addressHistory returns the name of the address history table, named
+// after the parcel table.
+func (t TableName) addressHistory() string {
+	return t.String() + "_address_history"
+}
+
+// archive returns the name of the archive table, named after the parcel
+// table.
+func (t TableName) archive() string {
+	return t.String() + "_archive"
+}
+
+// index returns the name of the unique index on column of the parcel
+// table. Index names are not schema-qualified.
+func (t TableName) index(column string) string {
+	return t.base() + "_" + column + "_idx"
+}
+
+// WithTableName makes the store query the given parcel table instead
+// of "parcel". The status history, address history, archive and
+// reservation tables are named after it, as CreateSchema creates them
+// for the same table name: for "shipment" they are
+// shipment_status_history and so on. The client table is shared by all
+// parcel tables.
+func WithTableName(table TableName) StoreOption {
+	return func(s *ParcelStore) {
+		s.table = table
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTableName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		table string
+		valid bool
+	}{
+		{name: "plain", table: "parcel", valid: true},
+		{name: "schema qualified", table: "shipping.parcel", valid: true},
+		{name: "underscores and digits", table: "_parcel_v2", valid: true},
+		{name: "empty", table: ""},
+		{name: "statement injection", table: "parcel; DROP TABLE parcel"},
+		{name: "comment injection", table: "parcel--"},
+		{name: "quoted", table: `"parcel"`},
+		{name: "space", table: "parcel x"},
+		{name: "leading digit", table: "1parcel"},
+		{name: "too many parts", table: "db.shipping.parcel"},
+		{name: "empty part", table: "shipping."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			table, err := ParseTableName(tt.table)
+			if !tt.valid {
+				require.ErrorIs(t, err, ErrInvalidTableName)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.table, table.String())
+		})
+	}
+}
+
+func TestTableNameDefault(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "parcel", TableName{}.String())
+}
+
+func TestTableNameSQL(t *testing.T) {
+	t.Parallel()
+
+	table, err := ParseTableName("shipping.parcel")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		dialect Dialect
+		mocks   func(dbMock sqlmock.Sqlmock)
+		call    func(ctx context.Context, store ParcelStore) error
+	}{
+		{
+			name:    "add",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery("INSERT INTO shipping.parcel (client, status, address, created_at, updated_at, registered_by, external_ref, weight_grams, length_mm, width_mm, height_mm, priority, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING number").
+					WillReturnRows(sqlmock.NewRows([]string{"number"}).AddRow(1))
+				dbMock.ExpectExec("UPDATE shipping.parcel SET tracking_code = $1 WHERE number = $2").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Add(ctx, &Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test address"})
+			},
+		},
+		{
+			name:    "get",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectQuery("SELECT " + parcelColumns + " FROM shipping.parcel WHERE number = ? AND deleted_at IS NULL").
+					WillReturnRows(parcelRows(Parcel{Number: 1}))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.Get(ctx, 1)
+				return err
+			},
+		},
+		{
+			name:    "set status uses the prefixed history table",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectQuery("SELECT status FROM shipping.parcel WHERE number = ?").
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.ExpectExec("UPDATE shipping.parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND version = ?").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.ExpectExec("INSERT INTO shipping.parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.SetStatus(ctx, 1, ParcelStatusSent, 1)
+			},
+		},
+		{
+			name:    "get by client with name qualifies the columns",
+			dialect: DialectSQLite,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				columns := "shipping.parcel." + strings.ReplaceAll(parcelColumns, ", ", ", shipping.parcel.")
+				dbMock.ExpectQuery("SELECT " + columns + ", client.name FROM shipping.parcel " +
+					"LEFT JOIN client ON client.id = shipping.parcel.client WHERE shipping.parcel.client = ? AND shipping.parcel.deleted_at IS NULL ORDER BY shipping.parcel.number").
+					WillReturnRows(sqlmock.NewRows(append(strings.Split(parcelColumns, ", "), "name")))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				_, err := store.GetByClientWithName(ctx, 1000)
+				return err
+			},
+		},
+		{
+			name:    "delete",
+			dialect: DialectPostgres,
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectExec("DELETE FROM shipping.parcel WHERE number = $1 AND status = $2").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(ctx context.Context, store ParcelStore) error {
+				return store.Delete(ctx, 1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer db.Close()
+
+			tt.mocks(dbMock)
+
+			err = tt.call(context.Background(), NewParcelStore(db, WithDialect(tt.dialect), WithTableName(table)))
+			require.NoError(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTableNameSQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	table, err := ParseTableName("main.shipment")
+	require.NoError(t, err)
+
+	require.NoError(t, CreateSchema(ctx, db, DialectSQLite, table))
+	// The schema is already applied; a second run must not fail.
+	require.NoError(t, CreateSchema(ctx, db, DialectSQLite, table))
+
+	store := NewParcelStore(db, WithTableName(table))
+	p := addParcel(t, store, 1000, time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+
+	got, err := store.Get(ctx, int(p.Number))
+	require.NoError(t, err)
+	require.Equal(t, p.Address, got.Address)
+
+	require.NoError(t, store.SetStatus(ctx, int(p.Number), ParcelStatusSent, got.Version))
+
+	history, err := store.GetStatusHistory(ctx, int(p.Number))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+
+	var changes int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM shipment_status_history").Scan(&changes))
+	require.Equal(t, 1, changes)
+
+	_, err = db.ExecContext(ctx, "SELECT 1 FROM parcel")
+	require.Error(t, err, "only the shipment tables must be created")
+}
+
+func TestTableNameSchema(t *testing.T) {
+	t.Parallel()
+
+	table, err := ParseTableName("shipping.shipment")
+	require.NoError(t, err)
+
+	for _, dialect := range []Dialect{DialectSQLite, DialectPostgres, DialectMySQL} {
+		t.Run(dialect.String(), func(t *testing.T) {
+			t.Parallel()
+
+			ddl := strings.Join(schemaStatements(dialect, table), "\n")
+
+			for _, name := range []string{"shipment", "shipment_reservation", "shipment_status_history", "shipment_address_history", "shipment_archive"} {
+				require.Contains(t, ddl, "CREATE TABLE IF NOT EXISTS shipping."+name+" (")
+			}
+
+			require.Contains(t, ddl, "CREATE TABLE IF NOT EXISTS client (")
+			require.Contains(t, ddl, "shipment_external_ref_idx")
+			require.Contains(t, ddl, "shipment_tracking_code_idx")
+			require.NotRegexp(t, `\bparcel`, ddl)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// noopTracer is used by stores created without WithTracer.
+var noopTracer = noop.NewTracerProvider().Tracer("")
+
+// WithTracer makes the store wrap each operation in a span named
+// parcelstore.<Method> started from tracer. A nil tracer keeps the
+// default, which records nothing.
+func WithTracer(tracer trace.Tracer) StoreOption {
+	return func(s *ParcelStore) {
+		if tracer != nil {
+			s.tracer = tracer
+		}
+	}
+}
+
+// startSpan starts the span of the store operation op with the given
+// attributes. The span is ended by observe.
+func (s ParcelStore) startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := s.tracer
+	if tracer == nil {
+		tracer = noopTracer
+	}
+
+	return tracer.Start(ctx, "parcelstore."+op, trace.WithAttributes(attrs...))
+}
+
+// attrNumber returns the span attribute holding a parcel number.
+func attrNumber(number int) attribute.KeyValue {
+	return attribute.Int("parcel.number", number)
+}
+
+// attrClient returns the span attribute holding a client identifier.
+func attrClient(client int) attribute.KeyValue {
+	return attribute.Int("parcel.client", client)
+}
+
+// attrStatus returns the span attribute holding a parcel status.
+func attrStatus(status ParcelStatus) attribute.KeyValue {
+	return attribute.String("parcel.status", string(status))
+}
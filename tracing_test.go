@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStoreTracing(t *testing.T) {
+	t.Parallel()
+
+	db, dbMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := NewParcelStore(db, WithTracer(provider.Tracer("test")))
+	ctx := context.Background()
+
+	dbMock.ExpectQuery(regexp.QuoteMeta("SELECT " + parcelColumns + " FROM parcel WHERE number = ? AND deleted_at IS NULL")).
+		WithArgs(101).
+		WillReturnRows(parcelRows(Parcel{Number: 101, Status: ParcelStatusSent}))
+	dbMock.ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND version = ?")).
+		WithArgs("new address", sqlmock.AnyArg(), 102, 1).
+		WillReturnError(errors.New("database error"))
+
+	_, err = store.Get(ctx, 101)
+	require.NoError(t, err)
+	require.Error(t, store.SetAddress(ctx, 102, "new address", 1))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	require.Equal(t, "parcelstore.Get", spans[0].Name())
+	require.Contains(t, spans[0].Attributes(), attribute.Int("parcel.number", 101))
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	require.Equal(t, "parcelstore.SetAddress", spans[1].Name())
+	require.Contains(t, spans[1].Attributes(), attribute.Int("parcel.number", 102))
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Equal(t, "parcelstore.SetAddress: database error", spans[1].Status().Description)
+
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestStoreTracingAttributes(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := NewParcelStore(openTestDB(t), WithTracer(provider.Tracer("test")))
+	ctx := context.Background()
+
+	parcel := Parcel{Client: 1000, Status: ParcelStatusRegistered, Address: "test"}
+	require.NoError(t, store.Add(ctx, &parcel))
+	require.NoError(t, store.SetStatus(ctx, int(parcel.Number), ParcelStatusSent, parcel.Version))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	require.Equal(t, "parcelstore.Add", spans[0].Name())
+	require.Contains(t, spans[0].Attributes(), attribute.Int("parcel.number", int(parcel.Number)))
+	require.Contains(t, spans[0].Attributes(), attribute.String("parcel.status", "registered"))
+
+	require.Equal(t, "parcelstore.SetStatus", spans[1].Name())
+	require.Contains(t, spans[1].Attributes(), attribute.String("parcel.status", "sent"))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTrackingCode is returned by ParcelStore.GetByTrackingCode for
+// a code that is malformed or whose check character does not match.
+var ErrInvalidTrackingCode = errors.New("invalid tracking code")
+
+const (
+	// trackingCodePrefix starts every tracking code.
+	trackingCodePrefix = "PKG-"
+	// trackingCodeAlphabet is Crockford's base32 alphabet. It leaves out
+	// I, L, O and U, which are easily confused when read out loud.
+	trackingCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	// trackingCodeWidth is the minimum number of base32 characters
+	// encoding the parcel number.
+	trackingCodeWidth = 6
+)
+
+// TrackingCode returns the human-readable tracking code of the parcel
+// with the given number, such as "PKG-000035K" for parcel 101.
+//
+// The code is the number in Crockford's base32, padded to six
+// characters, followed by a Luhn mod 32 check character that catches
+// any single mistyped character and most swapped neighbours. The same
+// number always yields the same code, and different numbers yield
+// different codes.
+func TrackingCode(number int64) string {
+	var digits []int
+	for n := number; n > 0; n /= int64(len(trackingCodeAlphabet)) {
+		digits = append([]int{int(n % int64(len(trackingCodeAlphabet)))}, digits...)
+	}
+
+	for len(digits) < trackingCodeWidth {
+		digits = append([]int{0}, digits...)
+	}
+
+	var code strings.Builder
+	code.WriteString(trackingCodePrefix)
+	for _, digit := range digits {
+		code.WriteByte(trackingCodeAlphabet[digit])
+	}
+	code.WriteByte(trackingCodeAlphabet[trackingCheck(digits)])
+
+	return code.String()
+}
+
+// normalizeTrackingCode upper-cases code and verifies its shape and
+// check character.
+func normalizeTrackingCode(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	body, ok := strings.CutPrefix(code, trackingCodePrefix)
+	if !ok || len(body) < trackingCodeWidth+1 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTrackingCode, code)
+	}
+
+	digits := make([]int, len(body))
+	for i := range body {
+		digits[i] = strings.IndexByte(trackingCodeAlphabet, body[i])
+		if digits[i] < 0 {
+			return "", fmt.Errorf("%w: %q", ErrInvalidTrackingCode, code)
+		}
+	}
+
+	last := len(digits) - 1
+	if trackingCheck(digits[:last]) != digits[last] {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTrackingCode, code)
+	}
+
+	return code, nil
+}
+
+// trackingCheck computes the Luhn mod 32 check digit of digits.
+func trackingCheck(digits []int) int {
+	base := len(trackingCodeAlphabet)
+	factor := 2
+	sum := 0
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		addend := factor * digits[i]
+		sum += addend/base + addend%base
+
+		factor = 3 - factor
+	}
+
+	return (base - sum%base) % base
+}
+
+// GetByTrackingCode retrieves a parcel by its tracking code. The code is
+// matched case-insensitively.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the query.
+// - code: the tracking code of the parcel, as returned by TrackingCode.
+//
+// Returns:
+// - The parcel with the code.
+// - ErrInvalidTrackingCode if the code is malformed, ErrParcelNotFound if
+// no parcel has the code, or any error of the query.
+func (s ParcelStore) GetByTrackingCode(ctx context.Context, code string) (_ Parcel, err error) {
+	ctx, span := s.startSpan(ctx, "GetByTrackingCode")
+	defer s.observe(span, "GetByTrackingCode", &err)
+
+	code, err = normalizeTrackingCode(code)
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	row := s.forReads().executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT "+parcelColumns+" FROM "+s.table.String()+" WHERE tracking_code = ? AND deleted_at IS NULL"), code)
+
+	var parcel Parcel
+
+	err = scanParcel(row, &parcel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Parcel{}, ErrParcelNotFound
+	}
+
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return parcel, nil
+}
+
+// TrackingInfo is everything a customer is told about a parcel by
+// ParcelService.Track.
+type TrackingInfo struct {
+	// Parcel is the current state of the parcel.
+	Parcel Parcel `json:"parcel"`
+	// History lists the status changes of the parcel, oldest first.
+	History []StatusChange `json:"history"`
+	// Delivered reports whether the parcel has been delivered.
+	Delivered bool `json:"delivered"`
+	// EstimatedDelivery is when the parcel was delivered, or when it is
+	// expected to be under the service's DeliveryPolicy.
+	EstimatedDelivery time.Time `json:"estimated_delivery"`
+}
+
+// Track returns a parcel together with its status history and its
+// estimated delivery time, for tracking pages that show everything
+// about a parcel at once.
+//
+// Parameters:
+// - ctx: The context controlling cancellation of the store calls.
+// - number: An integer representing the unique identifier of the parcel.
+//
+// Returns:
+// - The tracking information of the parcel.
+// - ErrParcelNotFound if the parcel does not exist, or any store error.
+func (s ParcelService) Track(ctx context.Context, number int) (TrackingInfo, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	parcel, err := s.store.Get(ctx, number)
+	if err != nil {
+		return TrackingInfo{}, err
+	}
+
+	history, err := s.store.GetStatusHistory(ctx, number)
+	if err != nil {
+		return TrackingInfo{}, err
+	}
+
+	if history == nil {
+		history = []StatusChange{}
+	}
+
+	return TrackingInfo{
+		Parcel:            parcel,
+		History:           history,
+		Delivered:         parcel.Status == ParcelStatusDelivered,
+		EstimatedDelivery: parcel.EstimatedDelivery(s.deliveryPolicy),
+	}, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackingCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		number int64
+		want   string
+	}{
+		{name: "first parcel", number: 1, want: "PKG-000001Y"},
+		{name: "two digits", number: 101, want: "PKG-000035K"},
+		{name: "wider than padding", number: 1 << 40, want: "PKG-100000000Y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, TrackingCode(tt.number))
+			require.Equal(t, tt.want, TrackingCode(tt.number), "generation must be deterministic")
+
+			code, err := normalizeTrackingCode(tt.want)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, code)
+		})
+	}
+}
+
+func TestTrackingCodeUnique(t *testing.T) {
+	t.Parallel()
+
+	seen := make(map[string]int64)
+	for number := int64(1); number <= 100_000; number++ {
+		code := TrackingCode(number)
+		previous, ok := seen[code]
+		require.False(t, ok, "numbers %d and %d share code %s", previous, number, code)
+		seen[code] = number
+	}
+}
+
+func TestNormalizeTrackingCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		code    string
+		want    string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "valid",
+			code:    "PKG-000035K",
+			want:    "PKG-000035K",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "lower case with spaces",
+			code:    " pkg-000035k ",
+			want:    "PKG-000035K",
+			wantErr: require.NoError,
+		},
+		{
+			name: "mistyped character",
+			code: "PKG-000036K",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidTrackingCode, i...)
+			},
+		},
+		{
+			name: "swapped characters",
+			code: "PKG-000053K",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidTrackingCode, i...)
+			},
+		},
+		{
+			name: "missing prefix",
+			code: "000035K",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidTrackingCode, i...)
+			},
+		},
+		{
+			name: "too short",
+			code: "PKG-35K",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidTrackingCode, i...)
+			},
+		},
+		{
+			name: "character outside the alphabet",
+			code: "PKG-00003UK",
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidTrackingCode, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := normalizeTrackingCode(tt.code)
+			tt.wantErr(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetByTrackingCode(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	first := addParcel(t, store, 1000, createdAt)
+	second := addParcel(t, store, 1001, createdAt)
+	require.Equal(t, TrackingCode(first.Number), first.TrackingCode)
+	require.NotEqual(t, first.TrackingCode, second.TrackingCode)
+
+	got, err := store.GetByTrackingCode(ctx, second.TrackingCode)
+	require.NoError(t, err)
+	require.Equal(t, second.Number, got.Number)
+	require.Equal(t, second.TrackingCode, got.TrackingCode)
+
+	got, err = store.GetByTrackingCode(ctx, " "+first.TrackingCode+" ")
+	require.NoError(t, err)
+	require.Equal(t, first.Number, got.Number)
+
+	got, err = store.GetByTrackingCode(ctx, TrackingCode(999))
+	require.ErrorIs(t, err, ErrParcelNotFound)
+	require.Equal(t, Parcel{}, got)
+
+	_, err = store.GetByTrackingCode(ctx, "PKG-000000A")
+	require.ErrorIs(t, err, ErrInvalidTrackingCode)
+
+	_, err = store.db.ExecContext(ctx, "INSERT INTO parcel (client, status, address, created_at, updated_at, tracking_code) VALUES (?, ?, ?, ?, ?, ?)",
+		1002, ParcelStatusRegistered, "test address", createdAt, createdAt, first.TrackingCode)
+	require.Error(t, err, "tracking codes must be unique")
+}
+
+func TestTrack(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	for name, repo := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			service := NewParcelService(repo, WithClock(fakeClock{now: createdAt}), WithDeliveryPolicy(DeliveryPolicy{RegisteredDays: 0.5, SentDays: 1.5}), WithOutput(io.Discard))
+
+			parcel, err := service.Register(ctx, 1000, "test address")
+			require.NoError(t, err)
+
+			info, err := service.Track(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Equal(t, parcel.Number, info.Parcel.Number)
+			require.Equal(t, ParcelStatusRegistered, info.Parcel.Status)
+			require.NotNil(t, info.History)
+			require.Empty(t, info.History)
+			require.False(t, info.Delivered)
+			require.True(t, createdAt.Add(48*time.Hour).Equal(info.EstimatedDelivery), info.EstimatedDelivery)
+
+			require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+			require.NoError(t, service.NextStatus(ctx, int(parcel.Number)))
+
+			info, err = service.Track(ctx, int(parcel.Number))
+			require.NoError(t, err)
+			require.Equal(t, parcel.TrackingCode, info.Parcel.TrackingCode)
+			require.Equal(t, ParcelStatusDelivered, info.Parcel.Status)
+			require.True(t, info.Delivered)
+			require.True(t, info.Parcel.SentAt.Valid)
+			require.True(t, info.Parcel.DeliveredAt.Valid)
+			require.True(t, info.Parcel.DeliveredAt.Time.Equal(info.EstimatedDelivery))
+
+			require.Len(t, info.History, 2)
+			require.Equal(t, ParcelStatusRegistered, info.History[0].OldStatus)
+			require.Equal(t, ParcelStatusSent, info.History[0].NewStatus)
+			require.Equal(t, ParcelStatusSent, info.History[1].OldStatus)
+			require.Equal(t, ParcelStatusDelivered, info.History[1].NewStatus)
+			for _, change := range info.History {
+				require.Equal(t, parcel.Number, change.Number)
+				require.False(t, change.ChangedAt.IsZero())
+			}
+
+			_, err = service.Track(ctx, int(parcel.Number)+100)
+			require.ErrorIs(t, err, ErrParcelNotFound)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoUpdateFields is returned by ParcelStore.Update when the update
+// does not set any field.
+var ErrNoUpdateFields = errors.New("no fields to update")
+
+// ParcelUpdate lists the fields of a parcel to change with
+// ParcelStore.Update. Nil fields are left unchanged.
+type ParcelUpdate struct {
+	// Address is the new destination address of the parcel.
+	Address *string
+	// Status is the new status of the parcel.
+	Status *ParcelStatus
+}
+
+// Update changes the given fields of a parcel in a single UPDATE, so
+// the address and the status can be changed together with one
+// UpdatedAt bump and one version increment.
+//
+// A status change is recorded in the parcel's status history within
+// the same transaction and updates SentAt and DeliveredAt like
+// SetStatus. The update is conditional on the status read, so a
+// concurrent status change results in ErrStatusChanged. Soft-deleted
+// parcels are not updated.
+//
+// Parameters:
+// - ctx: the context controlling cancellation of the statements.
+// - number: the unique number of the parcel to be updated.
+// - fields: the fields to change; at least one must be set.
+//
+// Returns:
+//   - ErrNoUpdateFields if fields does not set anything.
+//   - ErrParcelNotFound if no parcel that is not deleted has the given
+//     number.
+//   - ErrStatusChanged if the status was changed concurrently.
+//   - An error, if the status is invalid or the update operation fails.
+func (s ParcelStore) Update(ctx context.Context, number int, fields ParcelUpdate) (err error) {
+	ctx, span := s.startSpan(ctx, "Update", attrNumber(number))
+	defer s.observe(span, "Update", &err)
+
+	if fields.Address == nil && fields.Status == nil {
+		return ErrNoUpdateFields
+	}
+
+	if fields.Status != nil && !fields.Status.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, *fields.Status)
+	}
+
+	now := time.Now().UTC()
+
+	var (
+		columns []string
+		args    []any
+	)
+
+	if fields.Address != nil {
+		columns = append(columns, "address = ?")
+		args = append(args, *fields.Address)
+	}
+
+	if fields.Status == nil {
+		columns = append(columns, "updated_at = ?")
+		args = append(args, now, number)
+
+		result, err := s.executor().ExecContext(ctx, s.updateQuery(columns, ""), args...)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(result)
+	}
+
+	return s.WithTx(ctx, func(tx ParcelStore) error {
+		var current ParcelStatus
+
+		err := tx.executor().QueryRowContext(ctx, s.dialect.Rebind("SELECT status FROM "+s.table.String()+" WHERE number = ? AND deleted_at IS NULL"), number).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrParcelNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		set, setArgs := statusSet(current, *fields.Status, now)
+
+		result, err := tx.executor().ExecContext(ctx, s.updateQuery(append(columns, set), " AND status = ?"),
+			append(append(args, setArgs...), number, current)...)
+		if err != nil {
+			return err
+		}
+
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if updated == 0 {
+			return ErrStatusChanged
+		}
+
+		return tx.recordStatusChange(ctx, number, current, *fields.Status, now)
+	})
+}
+
+// updateQuery builds the UPDATE statement of Update from its SET
+// assignments, bumping the version. It skips soft-deleted parcels;
+// condition adds further conditions to the WHERE clause.
+func (s ParcelStore) updateQuery(assignments []string, condition string) string {
+	return s.dialect.Rebind("UPDATE " + s.table.String() + " SET " + strings.Join(assignments, ", ") +
+		", version = version + 1 WHERE number = ? AND deleted_at IS NULL" + condition)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	const (
+		selectStatus  = "SELECT status FROM parcel WHERE number = ? AND deleted_at IS NULL"
+		insertHistory = "INSERT INTO parcel_status_history (number, old_status, new_status, changed_at) VALUES (?, ?, ?, ?)"
+	)
+
+	address := "new address"
+	sent := ParcelStatusSent
+	lost := ParcelStatus("lost")
+
+	tests := []struct {
+		name    string
+		fields  ParcelUpdate
+		mocks   func(dbMock sqlmock.Sqlmock)
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:   "address only",
+			fields: ParcelUpdate{Address: &address},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL")).
+					WithArgs(address, sqlmock.AnyArg(), 101).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:   "status only",
+			fields: ParcelUpdate{Status: &sent},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL AND status = ?")).
+					WithArgs(sent, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusRegistered).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(101, ParcelStatusRegistered, sent, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:   "address and status",
+			fields: ParcelUpdate{Address: &address, Status: &sent},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ?, status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL AND status = ?")).
+					WithArgs(address, sent, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusRegistered).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta(insertHistory)).
+					WithArgs(101, ParcelStatusRegistered, sent, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				dbMock.ExpectCommit()
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:   "address of missing parcel",
+			fields: ParcelUpdate{Address: &address},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL")).
+					WithArgs(address, sqlmock.AnyArg(), 101).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name:   "status of missing parcel",
+			fields: ParcelUpdate{Status: &sent},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrParcelNotFound, i...)
+			},
+		},
+		{
+			name:   "status changed concurrently",
+			fields: ParcelUpdate{Status: &sent},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.
+					ExpectQuery(regexp.QuoteMeta(selectStatus)).
+					WithArgs(101).
+					WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(ParcelStatusRegistered))
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET status = ?, updated_at = ?, sent_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL AND status = ?")).
+					WithArgs(sent, sqlmock.AnyArg(), sqlmock.AnyArg(), 101, ParcelStatusRegistered).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				dbMock.ExpectRollback()
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrStatusChanged, i...)
+			},
+		},
+		{
+			name:   "database error",
+			fields: ParcelUpdate{Address: &address},
+			mocks: func(dbMock sqlmock.Sqlmock) {
+				dbMock.
+					ExpectExec(regexp.QuoteMeta("UPDATE parcel SET address = ?, updated_at = ?, version = version + 1 WHERE number = ? AND deleted_at IS NULL")).
+					WithArgs(address, sqlmock.AnyArg(), 101).
+					WillReturnError(errors.New("database error"))
+			},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.EqualError(tt, err, "parcelstore.Update: database error", i...)
+			},
+		},
+		{
+			name:   "no fields",
+			fields: ParcelUpdate{},
+			mocks:  func(dbMock sqlmock.Sqlmock) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrNoUpdateFields, i...)
+			},
+		},
+		{
+			name:   "invalid status",
+			fields: ParcelUpdate{Address: &address, Status: &lost},
+			mocks:  func(dbMock sqlmock.Sqlmock) {},
+			wantErr: func(tt require.TestingT, err error, i ...interface{}) {
+				require.ErrorIs(tt, err, ErrInvalidStatus, i...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, dbMock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			store := NewParcelStore(db)
+			tt.mocks(dbMock)
+
+			err = store.Update(context.Background(), 101, tt.fields)
+			tt.wantErr(t, err)
+
+			require.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateSQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	parcel := addParcel(t, store, 1000, time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+
+	address := "new address"
+	sent := ParcelStatusSent
+	require.NoError(t, store.Update(ctx, int(parcel.Number), ParcelUpdate{Address: &address, Status: &sent}))
+
+	got, err := store.Get(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	require.Equal(t, address, got.Address)
+	require.Equal(t, sent, got.Status)
+	require.Equal(t, parcel.Version+1, got.Version)
+
+	history, err := store.GetStatusHistory(ctx, int(parcel.Number))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, ParcelStatusSent, history[0].NewStatus)
+}
+
+func TestUpdateSoftDeletedSQLite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewParcelStore(openTestDB(t))
+
+	parcel := addParcel(t, store, 1000, time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, store.SoftDelete(ctx, int(parcel.Number)))
+
+	address := "new address"
+	sent := ParcelStatusSent
+	for _, fields := range []ParcelUpdate{{Address: &address}, {Status: &sent}, {Address: &address, Status: &sent}} {
+		require.ErrorIs(t, store.Update(ctx, int(parcel.Number), fields), ErrParcelNotFound)
+	}
+
+	var got Parcel
+	row := store.db.QueryRowContext(ctx, "SELECT "+parcelColumns+" FROM parcel WHERE number = ?", parcel.Number)
+	require.NoError(t, scanParcel(row, &got))
+	require.Equal(t, parcel.Address, got.Address)
+	require.Equal(t, parcel.Status, got.Status)
+}